@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"goruf/platform/core"
+	"goruf/platform/tcp"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli/v3"
+)
+
+func list(ctx context.Context, cmd *cli.Command) error {
+	var rs []core.DeploymentInfo
+	output, err := query(ctx, cmd, core.NewRequest(core.CmdListReq, ""), core.CmdListRep, &rs)
+	if err != nil {
+		return err
+	}
+	if output == "json" {
+		return printJson(os.Stdout, rs)
+	}
+	return printInfos(os.Stdout, rs)
+}
+
+func get(ctx context.Context, cmd *cli.Command) error {
+	endpoint, err := endpointArg(cmd)
+	if err != nil {
+		return err
+	}
+	var info core.DeploymentInfo
+	output, err := query(ctx, cmd, core.NewRequest(core.CmdGetReq, endpoint), core.CmdGetRep, &info)
+	if err != nil {
+		return err
+	}
+	if output == "json" {
+		return printJson(os.Stdout, info)
+	}
+	return printInfo(os.Stdout, info)
+}
+
+func releases(ctx context.Context, cmd *cli.Command) error {
+	endpoint, err := endpointArg(cmd)
+	if err != nil {
+		return err
+	}
+	var rs []core.DeploymentInfo
+	output, err := query(ctx, cmd, core.NewRequest(core.CmdReleasesReq, endpoint), core.CmdReleasesRep, &rs)
+	if err != nil {
+		return err
+	}
+	if output == "json" {
+		return printJson(os.Stdout, rs)
+	}
+	return printInfos(os.Stdout, rs)
+}
+
+func status(ctx context.Context, cmd *cli.Command) error {
+	var st core.ServerStatus
+	output, err := query(ctx, cmd, core.NewRequest(core.CmdStatusReq, ""), core.CmdStatusRep, &st)
+	if err != nil {
+		return err
+	}
+	if output == "json" {
+		return printJson(os.Stdout, st)
+	}
+	c, err := currentContext(cmd)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	if c.Name != "" {
+		fmt.Fprintf(tw, "Context:\t%s\n", c.Name)
+	}
+	fmt.Fprintf(tw, "Address:\t%s\n", c.Address)
+	fmt.Fprintf(tw, "Version:\t%s\n", st.Version)
+	fmt.Fprintf(tw, "Uptime:\t%s\n", time.Since(st.StartedAt).Round(time.Second))
+	fmt.Fprintf(tw, "Deployments:\t%d\n", st.Deployments)
+	fmt.Fprintf(tw, "Releases:\t%d\n", st.Releases)
+	fmt.Fprintf(tw, "Connections:\t%d\n", st.Connections)
+	return tw.Flush()
+}
+
+func remove(ctx context.Context, cmd *cli.Command) error {
+	endpoint, err := endpointArg(cmd)
+	if err != nil {
+		return err
+	}
+	return command(ctx, cmd, core.NewRequest(core.CmdDeleteReq, endpoint), core.CmdDeleteRep, endpoint, "deleted")
+}
+
+func rollback(ctx context.Context, cmd *cli.Command) error {
+	endpoint, err := endpointArg(cmd)
+	if err != nil {
+		return err
+	}
+	return command(ctx, cmd, core.NewRollback(endpoint), core.CmdRollbackRep, endpoint, "rolled back")
+}
+
+// query sends req and decodes the JSON payload of the reply into v, it
+// returns the output format the result should be printed in.
+func query(ctx context.Context, cmd *cli.Command, req []byte, rep uint32, v any) (string, error) {
+	output, err := outputFormat(cmd)
+	if err != nil {
+		return "", err
+	}
+	c, err := currentContext(cmd)
+	if err != nil {
+		return "", err
+	}
+	d, err := newDeployer(cmd, c)
+	if err != nil {
+		return "", err
+	}
+	defer d.client.Close()
+	reply, err := d.expect(ctx, req, rep)
+	if err != nil {
+		return "", err
+	}
+	payload, err := tcp.GetTlv(tcp.TypePayload, reply)
+	if err != nil {
+		return "", err
+	}
+	return output, json.Unmarshal(payload.Value, v)
+}
+
+// command sends req, which has no result besides its reply command.
+func command(ctx context.Context, cmd *cli.Command, req []byte, rep uint32, endpoint string, done string) error {
+	output, err := outputFormat(cmd)
+	if err != nil {
+		return err
+	}
+	c, err := currentContext(cmd)
+	if err != nil {
+		return err
+	}
+	d, err := newDeployer(cmd, c)
+	if err != nil {
+		return err
+	}
+	defer d.client.Close()
+	if _, err := d.expect(ctx, req, rep); err != nil {
+		return err
+	}
+	if output == "json" {
+		return printJson(os.Stdout, map[string]string{"endpoint": endpoint, "status": done})
+	}
+	fmt.Printf("%s %s\n", endpoint, done)
+	return nil
+}
+
+func endpointArg(cmd *cli.Command) (string, error) {
+	endpoint := core.NormalizeEndpoint(cmd.Args().First())
+	if endpoint == "" {
+		return "", fmt.Errorf("endpoint must be specified")
+	}
+	return endpoint, nil
+}
+
+func printJson(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func printInfos(w io.Writer, rs []core.DeploymentInfo) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ENDPOINT\tKIND\tVERSION\tCONTAINER\tSIGNER\tCREATED\tACTIVE\tID")
+	for _, r := range rs {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%t\t%s\n",
+			r.Endpoint, r.Kind, r.Version, r.Container, r.Signer,
+			r.CreatedAt.Local().Format(time.DateTime), r.Active, r.Id)
+	}
+	return tw.Flush()
+}
+
+// printNavigations writes one row per item, children indented below their group.
+func printNavigations(w io.Writer, items []core.Navigation, indent string) {
+	for _, n := range items {
+		name := n.Endpoint
+		if name == "" {
+			name = "[" + n.Id + "]"
+		}
+		fmt.Fprintf(w, "%s%s\t%s\t%d\t%s\t%t\t%s\n",
+			indent, name, n.Title, n.Order, n.Icon, n.Hidden, strings.Join(n.Roles, ","))
+		printNavigations(w, n.Children, indent+"  ")
+	}
+}
+
+func printInfo(w io.Writer, r core.DeploymentInfo) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Endpoint:\t%s\n", r.Endpoint)
+	fmt.Fprintf(tw, "Kind:\t%s\n", r.Kind)
+	fmt.Fprintf(tw, "Version:\t%s\n", r.Version)
+	fmt.Fprintf(tw, "Container:\t%s\n", r.Container)
+	fmt.Fprintf(tw, "Signer:\t%s\n", r.Signer)
+	fmt.Fprintf(tw, "Created:\t%s\n", r.CreatedAt.Local().Format(time.DateTime))
+	fmt.Fprintf(tw, "Id:\t%s\n", r.Id)
+	if r.Deployment == nil {
+		return tw.Flush()
+	}
+	if len(r.Deployment.Navigations) > 0 {
+		fmt.Fprintln(tw, "\nNAVIGATION\tTITLE\tORDER\tICON\tHIDDEN\tROLES")
+		printNavigations(tw, r.Deployment.Navigations, "")
+	}
+	if len(r.Deployment.Proxies) > 0 {
+		fmt.Fprintln(tw, "\nBACKEND\tADDRESS\tSTRATEGY\tSECURE\tROUTES")
+		for _, p := range r.Deployment.Proxies {
+			prefixes := make([]string, 0)
+			for _, route := range core.RoutesOf(p) {
+				prefixes = append(prefixes, route.Prefix)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%t\t%s\n", p.BackendCode, strings.Join(core.Targets(p), ","), core.StrategyOf(p), p.Secure, strings.Join(prefixes, ","))
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config lists the control planes mfe.cli knows about, similar to a kubeconfig.
+type Config struct {
+	CurrentContext string    `yaml:"current-context,omitempty"`
+	Contexts       []Context `yaml:"contexts,omitempty"`
+}
+
+type Context struct {
+	Name       string     `yaml:"name"`
+	Address    string     `yaml:"address,omitempty"`
+	TLS        *TLSConfig `yaml:"tls,omitempty"`
+	Token      string     `yaml:"token,omitempty"`
+	SigningKey string     `yaml:"signing-key,omitempty"`
+}
+
+type TLSConfig struct {
+	CA                 string `yaml:"ca,omitempty"`
+	Cert               string `yaml:"cert,omitempty"`
+	Key                string `yaml:"key,omitempty"`
+	ServerName         string `yaml:"server-name,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecure-skip-verify,omitempty"`
+}
+
+// defaultConfigFile returns $XDG_CONFIG_HOME/mfe/config.yaml, falling back
+// to ~/.config/mfe/config.yaml.
+func defaultConfigFile() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "mfe", "config.yaml")
+}
+
+// LoadConfig reads the config at path, a missing file is an empty config.
+func LoadConfig(path string) (*Config, error) {
+	c := &Config{}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+// Save writes the config readable by its owner only as it may hold credentials.
+func (c *Config) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	b, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o600)
+}
+
+func (c *Config) Lookup(name string) (Context, bool) {
+	for _, ctx := range c.Contexts {
+		if ctx.Name == name {
+			return ctx, true
+		}
+	}
+	return Context{}, false
+}
+
+// Set adds ctx or replaces the context of the same name and reports whether it existed.
+func (c *Config) Set(ctx Context) bool {
+	for i := range c.Contexts {
+		if c.Contexts[i].Name == ctx.Name {
+			c.Contexts[i] = ctx
+			return true
+		}
+	}
+	c.Contexts = append(c.Contexts, ctx)
+	return false
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v3"
+)
+
+func contextFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "config",
+			Sources: cli.EnvVars("CONFIG"),
+			Usage:   "path to config file holding the contexts",
+			Value:   defaultConfigFile(),
+		},
+		&cli.StringFlag{
+			Name:    "context",
+			Sources: cli.EnvVars("CONTEXT"),
+			Usage:   "name of context to use instead of the current one",
+		},
+		&cli.StringFlag{
+			Name:    "token",
+			Sources: cli.EnvVars("TOKEN"),
+			Usage:   "token presented to control plane",
+		},
+		&cli.StringFlag{
+			Name:    "tls-ca",
+			Sources: cli.EnvVars("TLS_CA"),
+			Usage:   "PEM encoded CA certificates used to verify control plane, enables TLS",
+		},
+		&cli.StringFlag{
+			Name:    "tls-cert",
+			Sources: cli.EnvVars("TLS_CERT"),
+			Usage:   "PEM encoded client certificate, enables TLS",
+		},
+		&cli.StringFlag{
+			Name:    "tls-key",
+			Sources: cli.EnvVars("TLS_KEY"),
+			Usage:   "PEM encoded private key of client certificate",
+		},
+		&cli.StringFlag{
+			Name:    "tls-server-name",
+			Sources: cli.EnvVars("TLS_SERVER_NAME"),
+			Usage:   "name expected in the certificate of control plane, enables TLS",
+		},
+		&cli.BoolFlag{
+			Name:    "insecure-skip-tls-verify",
+			Sources: cli.EnvVars("TLS_INSECURE_SKIP_VERIFY"),
+			Usage:   "do not verify the certificate of control plane, enables TLS",
+		},
+	}
+}
+
+func contextCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "context",
+		Usage: "manage the control planes stored in config file",
+		Commands: []*cli.Command{
+			{
+				Name:   "list",
+				Usage:  "list contexts",
+				Action: listContexts,
+			},
+			{
+				Name:      "use",
+				Usage:     "set the current context",
+				ArgsUsage: "<name>",
+				Action:    useContext,
+			},
+			{
+				Name:      "add",
+				Usage:     "add a context, or replace the one of the same name, from the connection flags",
+				ArgsUsage: "<name>",
+				Action:    addContext,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "signing-key",
+						Usage: "path to PKCS#8 PEM encoded ed25519 private key used to sign deployments",
+					},
+					&cli.BoolFlag{
+						Name:  "use",
+						Usage: "make it the current context",
+					},
+				},
+			},
+		},
+	}
+}
+
+// currentContext returns the context selected by --context or the config
+// file, with the connection flags and env vars set explicitly applied on top.
+func currentContext(cmd *cli.Command) (Context, error) {
+	config, err := LoadConfig(cmd.String("config"))
+	if err != nil {
+		return Context{}, err
+	}
+	name := cmd.String("context")
+	if name == "" {
+		name = config.CurrentContext
+	}
+	c := Context{Name: name}
+	if name != "" {
+		var ok bool
+		if c, ok = config.Lookup(name); !ok {
+			return Context{}, fmt.Errorf("context %s is not defined in %s", name, cmd.String("config"))
+		}
+	}
+	if cmd.IsSet("address") || c.Address == "" {
+		c.Address = cmd.String("address")
+	}
+	if cmd.IsSet("token") {
+		c.Token = cmd.String("token")
+	}
+	if cmd.IsSet("signing-key") {
+		c.SigningKey = cmd.String("signing-key")
+	}
+	applyTlsFlags(cmd, &c)
+	return c, nil
+}
+
+// applyTlsFlags overrides the TLS settings of c with the flags that are set,
+// any of them enables TLS.
+func applyTlsFlags(cmd *cli.Command, c *Context) {
+	for _, name := range []string{"tls-ca", "tls-cert", "tls-key", "tls-server-name", "insecure-skip-tls-verify"} {
+		if cmd.IsSet(name) && c.TLS == nil {
+			c.TLS = &TLSConfig{}
+		}
+	}
+	if c.TLS == nil {
+		return
+	}
+	if cmd.IsSet("tls-ca") {
+		c.TLS.CA = absPath(cmd.String("tls-ca"))
+	}
+	if cmd.IsSet("tls-cert") {
+		c.TLS.Cert = absPath(cmd.String("tls-cert"))
+	}
+	if cmd.IsSet("tls-key") {
+		c.TLS.Key = absPath(cmd.String("tls-key"))
+	}
+	if cmd.IsSet("tls-server-name") {
+		c.TLS.ServerName = cmd.String("tls-server-name")
+	}
+	if cmd.IsSet("insecure-skip-tls-verify") {
+		c.TLS.InsecureSkipVerify = cmd.Bool("insecure-skip-tls-verify")
+	}
+}
+
+func listContexts(ctx context.Context, cmd *cli.Command) error {
+	output, err := outputFormat(cmd)
+	if err != nil {
+		return err
+	}
+	config, err := LoadConfig(cmd.String("config"))
+	if err != nil {
+		return err
+	}
+	type contextOutput struct {
+		Name    string `json:"name"`
+		Address string `json:"address"`
+		TLS     bool   `json:"tls"`
+		Current bool   `json:"current"`
+	}
+	rs := make([]contextOutput, 0, len(config.Contexts))
+	for _, c := range config.Contexts {
+		rs = append(rs, contextOutput{
+			Name:    c.Name,
+			Address: c.Address,
+			TLS:     c.TLS != nil,
+			Current: c.Name == config.CurrentContext,
+		})
+	}
+	if output == "json" {
+		return printJson(os.Stdout, rs)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CURRENT\tNAME\tADDRESS\tTLS")
+	for _, c := range rs {
+		current := ""
+		if c.Current {
+			current = "*"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%t\n", current, c.Name, c.Address, c.TLS)
+	}
+	return tw.Flush()
+}
+
+func useContext(ctx context.Context, cmd *cli.Command) error {
+	name := strings.TrimSpace(cmd.Args().First())
+	if name == "" {
+		return fmt.Errorf("name of context must be specified")
+	}
+	path := cmd.String("config")
+	config, err := LoadConfig(path)
+	if err != nil {
+		return err
+	}
+	if _, ok := config.Lookup(name); !ok {
+		return fmt.Errorf("context %s is not defined in %s", name, path)
+	}
+	config.CurrentContext = name
+	if err := config.Save(path); err != nil {
+		return err
+	}
+	fmt.Printf("switched to context %s\n", name)
+	return nil
+}
+
+func addContext(ctx context.Context, cmd *cli.Command) error {
+	name := strings.TrimSpace(cmd.Args().First())
+	if name == "" {
+		return fmt.Errorf("name of context must be specified")
+	}
+	path := cmd.String("config")
+	config, err := LoadConfig(path)
+	if err != nil {
+		return err
+	}
+	c := Context{
+		Name:    name,
+		Address: cmd.String("address"),
+		Token:   cmd.String("token"),
+	}
+	if key := cmd.String("signing-key"); key != "" {
+		c.SigningKey = absPath(key)
+	}
+	applyTlsFlags(cmd, &c)
+	replaced := config.Set(c)
+	if cmd.Bool("use") || config.CurrentContext == "" {
+		config.CurrentContext = name
+	}
+	if err := config.Save(path); err != nil {
+		return err
+	}
+	if replaced {
+		fmt.Printf("context %s updated\n", name)
+	} else {
+		fmt.Printf("context %s added\n", name)
+	}
+	return nil
+}
+
+func absPath(p string) string {
+	if rs, err := filepath.Abs(p); err == nil {
+		return rs
+	}
+	return p
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"goruf/platform/core"
+	"goruf/platform/tcp"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+const (
+	statusDeployed   = "deployed"
+	statusFailed     = "failed"
+	statusSkipped    = "skipped"
+	statusRolledBack = "rolled back"
+)
+
+// deployment is a descriptor read from Source, which names the file and the
+// index of the document within it.
+type deployment struct {
+	Source  string
+	Format  core.Format
+	Request core.DeploymentRequest
+	Status  string
+	Err     error
+	Diff    *core.ReleaseDiff
+	// Assets are signed with the deployment and uploaded once it is active.
+	Assets []localAsset
+	// Applied is set once the server activated the deployment.
+	Applied bool
+}
+
+// loadDeployments reads every deployment of the given files and directories.
+// Directories are walked recursively for YAML, JSON and TOML files, skipping
+// overlay files. The overlay named overlay, if any, is merged into each file.
+// Files are decoded in format, or according to their extension when empty.
+func loadDeployments(paths []string, strict bool, overlay string, format core.Format) ([]*deployment, error) {
+	files := make([]string, 0)
+	for _, p := range paths {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, p)
+			continue
+		}
+		err = filepath.WalkDir(p, func(path string, e fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if _, err := core.FormatOf(path); err == nil && !e.IsDir() && !isOverlayFile(path) {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("file must be specified")
+	}
+	rs := make([]*deployment, 0)
+	failed := 0
+	var err error
+	for _, f := range files {
+		f, format := f, format
+		if format == "" {
+			format, err = core.FormatOf(f)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", f, err)
+				failed++
+				continue
+			}
+		}
+		reqs, err := readDeploymentFile(f, strict, overlay, format)
+		if err != nil {
+			failed++
+			continue
+		}
+		for i, req := range reqs {
+			source := f
+			if len(reqs) > 1 {
+				source = fmt.Sprintf("%s#%d", f, i+1)
+			}
+			rs = append(rs, &deployment{Source: source, Format: format, Request: req})
+		}
+	}
+	if failed > 0 {
+		return nil, fmt.Errorf("%d of %d file(s) are not valid", failed, len(files))
+	}
+	return rs, nil
+}
+
+// overlaySuffix marks overlay files, which are never deployed on their own.
+const overlaySuffix = ".overlay"
+
+// overlayFile returns the path of the overlay named overlay for f, e.g.
+// deployment.prod.overlay.yaml for deployment.yaml, or "" if it does not exist.
+func overlayFile(f string, overlay string) string {
+	if overlay == "" {
+		return ""
+	}
+	ext := filepath.Ext(f)
+	p := strings.TrimSuffix(f, ext) + "." + overlay + overlaySuffix + ext
+	if _, err := os.Stat(p); err != nil {
+		return ""
+	}
+	return p
+}
+
+// isOverlayFile reports whether f is named like base.name.overlay.ext.
+func isOverlayFile(f string) bool {
+	return strings.HasSuffix(strings.TrimSuffix(filepath.Base(f), filepath.Ext(f)), overlaySuffix)
+}
+
+// sortDeployments orders the batch so that containers are deployed before
+// the microapps mounted into them.
+func sortDeployments(ds []*deployment) {
+	rank := func(d *deployment) int {
+		spec, _ := core.LookupKind(string(d.Request.Kind))
+		switch {
+		case spec.RendersShell:
+			return 0
+		case spec.Mountable:
+			return 2
+		default:
+			return 1
+		}
+	}
+	sort.SliceStable(ds, func(i, j int) bool {
+		return rank(ds[i]) < rank(ds[j])
+	})
+}
+
+type deployer struct {
+	client *tcp.Client
+	key    ed25519.PrivateKey
+}
+
+// deploy sends the request of dep encoded in the format it was read from,
+// together with the digests of its assets. In dry-run mode nothing is
+// applied and the diff computed by the server is returned.
+func (d *deployer) deploy(ctx context.Context, dep *deployment, dryRun bool) (*core.ReleaseDiff, error) {
+	b, err := core.MarshalDeployment(dep.Request, dep.Format)
+	if err != nil {
+		return nil, err
+	}
+	assets, err := assetDigests(dep.Assets)
+	if err != nil {
+		return nil, err
+	}
+	connectCmd := core.CmdConnect{
+		Cmd:         core.CmdConnectReq,
+		Payload:     b,
+		ContentType: dep.Format.ContentType(),
+		DryRun:      dryRun,
+		Assets:      assets,
+	}
+	if d.key != nil {
+		connectCmd.KeyId = core.KeyId(d.key.Public().(ed25519.PublicKey))
+		connectCmd.Signature = core.Sign(d.key, core.Manifest{Deployment: b, Assets: assets})
+	}
+	reply, err := d.expect(ctx, connectCmd.Pack(), core.CmdConnectRep)
+	if err != nil || !dryRun {
+		return nil, err
+	}
+	payload, err := tcp.GetTlv(tcp.TypePayload, reply)
+	if err != nil {
+		return nil, fmt.Errorf("diff is missing from reply")
+	}
+	var diff core.ReleaseDiff
+	if err := json.Unmarshal(payload.Value, &diff); err != nil {
+		return nil, err
+	}
+	return &diff, nil
+}
+
+func (d *deployer) rollback(ctx context.Context, endpoint string) error {
+	_, err := d.expect(ctx, core.NewRollback(endpoint), core.CmdRollbackRep)
+	return err
+}
+
+func (d *deployer) expect(ctx context.Context, payload []byte, cmd uint32) ([]byte, error) {
+	reply, err := d.client.Do(ctx, payload)
+	if err != nil {
+		return nil, err
+	}
+	rep, err := tcp.GetTlv(tcp.TypeCmd, reply)
+	if err != nil {
+		return nil, err
+	}
+	if rep.GetUInt32() != cmd {
+		return nil, fmt.Errorf("unexpected reply command %d", rep.GetUInt32())
+	}
+	return reply, nil
+}
+
+// deployBatch deploys ds in order, uploading their assets, and stops at the
+// first failure. When rollback is set, the deployments already applied are
+// rolled back in reverse order.
+func (d *deployer) deployBatch(ctx context.Context, ds []*deployment, rollback bool) error {
+	var failure error
+	for i, dep := range ds {
+		if failure != nil {
+			dep.Status = statusSkipped
+			continue
+		}
+		_, dep.Err = d.deploy(ctx, dep, false)
+		if dep.Err == nil {
+			dep.Applied = true
+			_, dep.Err = d.uploadAssets(ctx, dep.Request.Endpoint, dep.Assets)
+		}
+		if dep.Err != nil {
+			dep.Status = statusFailed
+			failure = fmt.Errorf("failed to deploy %s: %w", ds[i].Source, dep.Err)
+			continue
+		}
+		dep.Status = statusDeployed
+	}
+	if failure == nil || !rollback {
+		return failure
+	}
+	for i := len(ds) - 1; i >= 0; i-- {
+		if !ds[i].Applied {
+			continue
+		}
+		err := d.rollback(ctx, ds[i].Request.Endpoint)
+		if err != nil {
+			failure = errors.Join(failure, fmt.Errorf("failed to roll back %s: %w", ds[i].Source, err))
+			continue
+		}
+		ds[i].Status = statusRolledBack
+	}
+	return failure
+}
+
+// diffBatch asks the server for the diff of every deployment of ds.
+func (d *deployer) diffBatch(ctx context.Context, ds []*deployment) error {
+	failed := 0
+	for _, dep := range ds {
+		dep.Diff, dep.Err = d.deploy(ctx, dep, true)
+		if dep.Err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d deployment(s) are rejected", failed, len(ds))
+	}
+	return nil
+}
+
+type diffOutput struct {
+	Source string            `json:"source"`
+	Diff   *core.ReleaseDiff `json:"diff,omitempty"`
+	Error  string            `json:"error,omitempty"`
+}
+
+func printDiffs(w io.Writer, ds []*deployment, output string) error {
+	if output == "json" {
+		rs := make([]diffOutput, 0, len(ds))
+		for _, d := range ds {
+			o := diffOutput{Source: d.Source, Diff: d.Diff}
+			if d.Err != nil {
+				o.Error = d.Err.Error()
+			}
+			rs = append(rs, o)
+		}
+		return printJson(w, rs)
+	}
+	for _, d := range ds {
+		if d.Err != nil {
+			fmt.Fprintf(w, "%s\n  ! %v\n", d.Source, d.Err)
+			continue
+		}
+		d.Diff.Print(w)
+	}
+	return nil
+}
+
+type summaryOutput struct {
+	Source   string `json:"source"`
+	Kind     string `json:"kind"`
+	Endpoint string `json:"endpoint"`
+	Version  string `json:"version,omitempty"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+}
+
+func printSummary(w io.Writer, ds []*deployment, output string) error {
+	rs := make([]summaryOutput, 0, len(ds))
+	for _, d := range ds {
+		o := summaryOutput{
+			Source:   d.Source,
+			Kind:     string(d.Request.Kind),
+			Endpoint: d.Request.Endpoint,
+			Version:  d.Request.Version,
+			Status:   d.Status,
+		}
+		if d.Err != nil {
+			o.Error = d.Err.Error()
+		}
+		rs = append(rs, o)
+	}
+	if output == "json" {
+		return printJson(w, rs)
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SOURCE\tKIND\tENDPOINT\tVERSION\tSTATUS\tERROR")
+	for _, o := range rs {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			o.Source, o.Kind, o.Endpoint, o.Version, o.Status, o.Error)
+	}
+	return tw.Flush()
+}
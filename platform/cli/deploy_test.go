@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"goruf/platform/core"
+	"goruf/platform/tcp"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// fakePlatform records the deployments and rollbacks it receives and
+// rejects the deployment of the endpoint named by fail.
+type fakePlatform struct {
+	mu       sync.Mutex
+	fail     string
+	requests []string
+}
+
+func (p *fakePlatform) Handle(msg tcp.Msg) ([]byte, error) {
+	cmd, err := tcp.GetTlv(tcp.TypeCmd, msg.Payload)
+	if err != nil {
+		return nil, err
+	}
+	switch cmd.GetUInt32() {
+	case core.CmdConnectReq:
+		payload, err := tcp.GetTlv(tcp.TypePayload, msg.Payload)
+		if err != nil {
+			return tcp.Join(tcp.TlvUInt32(tcp.TypeCmd, core.CmdConnectRep)), nil
+		}
+		d, err := core.ParseDeployment(payload.Value, false)
+		if err != nil {
+			return nil, err
+		}
+		p.record("deploy " + d.Endpoint)
+		if d.Endpoint == p.fail {
+			return nil, fmt.Errorf("%s is rejected", d.Endpoint)
+		}
+		return tcp.Join(tcp.TlvUInt32(tcp.TypeCmd, core.CmdConnectRep)), nil
+	case core.CmdRollbackReq:
+		endpoint, _ := tcp.GetTlv(core.TypeEndpoint, msg.Payload)
+		p.record("rollback " + endpoint.GetString())
+		return tcp.Join(tcp.TlvUInt32(tcp.TypeCmd, core.CmdRollbackRep)), nil
+	default:
+		return nil, fmt.Errorf("unexpected command %d", cmd.GetUInt32())
+	}
+}
+
+func (p *fakePlatform) record(r string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.requests = append(p.requests, r)
+}
+
+func (p *fakePlatform) recorded() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.requests
+}
+
+// newTestDeployer returns a deployer connected to p listening on a random local port.
+func newTestDeployer(t *testing.T, p *fakePlatform) *deployer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Logf("failed to listen: %v", err)
+		t.FailNow()
+	}
+	t.Cleanup(func() { l.Close() })
+	go tcp.Serve(l, tcp.ServerOptions{}, func() tcp.MessageHandler { return p })
+	opts := tcp.DefaultClientOptions(l.Addr().String())
+	opts.Idempotent = core.IsIdempotent
+	opts.PoolSize = 1
+	d := &deployer{client: tcp.NewClient(opts)}
+	t.Cleanup(func() { d.client.Close() })
+	return d
+}
+
+func testBatch() []*deployment {
+	ds := make([]*deployment, 0)
+	for _, d := range []core.DeploymentRequest{
+		{Kind: core.KindContainer, Endpoint: "shop"},
+		{Kind: core.KindMicroapp, Endpoint: "cart", Container: "shop"},
+		{Kind: core.KindMicroapp, Endpoint: "checkout", Container: "shop"},
+		{Kind: core.KindMicroapp, Endpoint: "orders", Container: "shop"},
+	} {
+		ds = append(ds, &deployment{Source: d.Endpoint + ".yaml", Format: core.FormatYaml, Request: d})
+	}
+	return ds
+}
+
+func statuses(ds []*deployment) []string {
+	rs := make([]string, 0, len(ds))
+	for _, d := range ds {
+		rs = append(rs, d.Status)
+	}
+	return rs
+}
+
+func TestDeployBatch(t *testing.T) {
+	p := &fakePlatform{fail: "checkout"}
+	d := newTestDeployer(t, p)
+	ds := testBatch()
+	if err := d.deployBatch(context.Background(), ds, false); err == nil {
+		t.Logf("expected batch to fail at checkout")
+		t.FailNow()
+	}
+	expected := []string{statusDeployed, statusDeployed, statusFailed, statusSkipped}
+	if !reflect.DeepEqual(statuses(ds), expected) {
+		t.Logf("expected statuses %v, actual = %v", expected, statuses(ds))
+		t.FailNow()
+	}
+	if !reflect.DeepEqual(p.recorded(), []string{"deploy shop", "deploy cart", "deploy checkout"}) {
+		t.Logf("expected nothing to be rolled back, actual = %v", p.recorded())
+		t.FailNow()
+	}
+}
+
+func TestDeployBatchRollback(t *testing.T) {
+	p := &fakePlatform{fail: "checkout"}
+	d := newTestDeployer(t, p)
+	ds := testBatch()
+	if err := d.deployBatch(context.Background(), ds, true); err == nil {
+		t.Logf("expected batch to fail at checkout")
+		t.FailNow()
+	}
+	expected := []string{statusRolledBack, statusRolledBack, statusFailed, statusSkipped}
+	if !reflect.DeepEqual(statuses(ds), expected) {
+		t.Logf("expected statuses %v, actual = %v", expected, statuses(ds))
+		t.FailNow()
+	}
+	requests := []string{"deploy shop", "deploy cart", "deploy checkout", "rollback cart", "rollback shop"}
+	if !reflect.DeepEqual(p.recorded(), requests) {
+		t.Logf("expected applied deployments to be rolled back in reverse order, actual = %v", p.recorded())
+		t.FailNow()
+	}
+
+	p.mu.Lock()
+	p.requests, p.fail = nil, ""
+	p.mu.Unlock()
+	ds = testBatch()
+	if err := d.deployBatch(context.Background(), ds, true); err != nil {
+		t.Logf("failed to deployBatch(ctx, ds, true): %v", err)
+		t.FailNow()
+	}
+	if len(p.recorded()) != len(ds) {
+		t.Logf("expected nothing to be rolled back, actual = %v", p.recorded())
+		t.FailNow()
+	}
+}
+
+func TestSortDeployments(t *testing.T) {
+	ds := []*deployment{
+		{Source: "cart", Request: core.DeploymentRequest{Kind: core.KindMicroapp}},
+		{Source: "static", Request: core.DeploymentRequest{Kind: core.KindCdn}},
+		{Source: "orders", Request: core.DeploymentRequest{Kind: core.KindMicroapp}},
+		{Source: "shop", Request: core.DeploymentRequest{Kind: core.KindContainer}},
+	}
+	sortDeployments(ds)
+	sources := make([]string, 0, len(ds))
+	for _, d := range ds {
+		sources = append(sources, d.Source)
+	}
+	if !reflect.DeepEqual(sources, []string{"shop", "static", "cart", "orders"}) {
+		t.Logf("expected containers first and microapps last in file order, actual = %v", sources)
+		t.FailNow()
+	}
+}
+
+func TestLoadDeployments(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"shop.yaml":                    "Kind: container\nEndpoint: shop\nVersion: v1\n",
+		"shop.prod.overlay.yaml":       "Endpoint: shop\nVersion: v2\n",
+		"apps/cart.json":               `[{"Kind": "microapp", "Endpoint": "cart", "Container": "shop"}, {"Kind": "microapp", "Endpoint": "orders", "Container": "shop"}]`,
+		"apps/README.md":               "not a deployment",
+		"apps/checkout.v2.yaml":        "Kind: microapp\nEndpoint: checkout\nContainer: shop\n",
+		"apps/checkout.toml":           "Kind = \"microapp\"\nEndpoint = \"checkout-v1\"\nContainer = \"shop\"\n",
+		"apps/checkout.v2.overlay.yml": "Endpoint: other\n",
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(p), 0o755)
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Logf("failed to write %s: %v", p, err)
+			t.FailNow()
+		}
+	}
+	ds, err := loadDeployments([]string{dir}, true, "prod", "")
+	if err != nil {
+		t.Logf("failed to loadDeployments(dir): %v", err)
+		t.FailNow()
+	}
+	versions := make(map[string]string)
+	for _, d := range ds {
+		versions[d.Request.Endpoint] = d.Request.Version
+	}
+	expected := map[string]string{"shop": "v2", "cart": "", "orders": "", "checkout": "", "checkout-v1": ""}
+	if !reflect.DeepEqual(versions, expected) {
+		t.Logf("expected %v, actual = %v", expected, versions)
+		t.FailNow()
+	}
+	for _, d := range ds {
+		if d.Request.Endpoint == "orders" && d.Source != filepath.Join(dir, "apps/cart.json")+"#2" {
+			t.Logf("expected source to name the document, actual = %s", d.Source)
+			t.FailNow()
+		}
+	}
+
+	os.WriteFile(filepath.Join(dir, "broken.yaml"), []byte("Kind: container\n"), 0o644)
+	if _, err := loadDeployments([]string{dir}, true, "", ""); err == nil {
+		t.Logf("expected an invalid file to fail the whole batch")
+		t.FailNow()
+	}
+	if _, err := loadDeployments(nil, false, "", ""); err == nil {
+		t.Logf("expected an error when no file is given")
+		t.FailNow()
+	}
+}
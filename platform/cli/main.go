@@ -1,20 +1,19 @@
 package main
 
 import (
-	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"goruf/platform/core"
 	"goruf/platform/tcp"
-	"net"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/urfave/cli/v3"
-	"gopkg.in/yaml.v3"
 )
 
 var (
@@ -46,8 +45,64 @@ func main() {
 		Name:      "mfe.cli",
 		Copyright: "xuanloc0511@gmail.com",
 		Version:   version,
-		Flags:     getFlags(),
-		Action:    run,
+		Flags:     append(append(getFlags(), contextFlags()...), local(deployFlags())...),
+		// deploying without a command is kept for existing scripts
+		Action: run,
+		Commands: []*cli.Command{
+			{
+				Name:   "deploy",
+				Usage:  "deploy files to control plane",
+				Action: run,
+				Flags:  deployFlags(),
+			},
+			{
+				Name:   "validate",
+				Usage:  "validate deployment file without sending it",
+				Action: validate,
+				Flags:  deploymentFlags(),
+			},
+			{
+				Name:   "list",
+				Usage:  "list active deployments",
+				Action: list,
+			},
+			{
+				Name:      "get",
+				Usage:     "show the active release of an endpoint",
+				ArgsUsage: "<endpoint>",
+				Action:    get,
+			},
+			{
+				Name:      "delete",
+				Usage:     "remove an endpoint together with its releases",
+				ArgsUsage: "<endpoint>",
+				Action:    remove,
+			},
+			{
+				Name:      "releases",
+				Usage:     "list the releases of an endpoint, latest first",
+				ArgsUsage: "<endpoint>",
+				Action:    releases,
+			},
+			{
+				Name:      "rollback",
+				Usage:     "restore the previous release of an endpoint",
+				ArgsUsage: "<endpoint>",
+				Action:    rollback,
+			},
+			{
+				Name:      "upload",
+				Usage:     "upload files or directories as assets of the active release of an endpoint",
+				ArgsUsage: "<endpoint> <file or directory>...",
+				Action:    upload,
+			},
+			{
+				Name:   "status",
+				Usage:  "show the status of control plane",
+				Action: status,
+			},
+			contextCommand(),
+		},
 	}
 	err := cmd.Run(context.Background(), os.Args)
 	if err != nil {
@@ -65,11 +120,11 @@ func getFlags() []cli.Flag {
 			Value:   "localhost:8081",
 		},
 		&cli.StringFlag{
-			Name:    "file",
-			Aliases: []string{"f"},
-			Sources: cli.EnvVars("FILE"),
-			Usage:   "path to deployment file",
-			Value:   "",
+			Name:    "output",
+			Aliases: []string{"O"},
+			Sources: cli.EnvVars("OUTPUT"),
+			Usage:   "output format, table or json",
+			Value:   "table",
 		},
 		&cli.UintFlag{
 			Name:    "max-payload-size",
@@ -78,57 +133,227 @@ func getFlags() []cli.Flag {
 			Usage:   "max size of payload of package",
 			Value:   1024 * 1024,
 		},
+		&cli.DurationFlag{
+			Name:    "keepalive",
+			Sources: cli.EnvVars("KEEPALIVE"),
+			Usage:   "interval of tcp keep-alive probes, keeps long uploads alive across NAT",
+			Value:   15 * time.Second,
+		},
+		&cli.StringFlag{
+			Name:    "compression",
+			Sources: cli.EnvVars("COMPRESSION"),
+			Usage:   "codecs offered to control plane in order of preference (zstd, gzip or none)",
+			Value:   "zstd,gzip",
+		},
+	}
+}
+
+// deploymentFlags are the flags of the commands reading deployment files.
+func deploymentFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:    "file",
+			Aliases: []string{"f"},
+			Sources: cli.EnvVars("FILE"),
+			Usage:   "path to deployment file or directory of deployment files, can be repeated",
+		},
+		&cli.StringFlag{
+			Name:    "overlay",
+			Aliases: []string{"o"},
+			Sources: cli.EnvVars("OVERLAY"),
+			Usage:   "name of overlay merged into each deployment file, e.g. prod merges deployment.prod.overlay.yaml into deployment.yaml",
+		},
+		&cli.StringFlag{
+			Name:    "format",
+			Sources: cli.EnvVars("FORMAT"),
+			Usage:   "format of deployment files (yaml, json or toml), detected from file extension by default",
+		},
+		&cli.BoolFlag{
+			Name:    "strict",
+			Sources: cli.EnvVars("STRICT"),
+			Usage:   "reject unknown keys in deployment file",
+		},
 	}
 }
 
+// deployFlags are the flags of the deploy command.
+func deployFlags() []cli.Flag {
+	return append(deploymentFlags(),
+		&cli.BoolFlag{
+			Name:    "rollback",
+			Sources: cli.EnvVars("ROLLBACK"),
+			Usage:   "roll back the whole batch when one deployment fails",
+		},
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "show the changes against the active releases without applying them",
+		},
+		&cli.StringSliceFlag{
+			Name:    "assets",
+			Sources: cli.EnvVars("ASSETS"),
+			Usage:   "files or directories uploaded as assets of the deployment, their digests are signed with it, can be repeated",
+		},
+		&cli.StringFlag{
+			Name:    "signing-key",
+			Sources: cli.EnvVars("SIGNING_KEY"),
+			Usage:   "path to PKCS#8 PEM encoded ed25519 private key used to sign the deployment",
+		},
+	)
+}
+
+// local keeps flags of the root command from being inherited by subcommands.
+func local(flags []cli.Flag) []cli.Flag {
+	for _, f := range flags {
+		switch f := f.(type) {
+		case *cli.StringFlag:
+			f.Local = true
+		case *cli.StringSliceFlag:
+			f.Local = true
+		case *cli.BoolFlag:
+			f.Local = true
+		}
+	}
+	return flags
+}
+
 func run(ctx context.Context, cmd *cli.Command) error {
-	maxPayloadSize := cmd.Uint("max-payload-size")
-	f := cmd.String("file")
-	if strings.TrimSpace(f) == "" {
-		return fmt.Errorf("file must be specified")
+	output, err := outputFormat(cmd)
+	if err != nil {
+		return err
 	}
-	depl, err := readDeploymentFile(f)
+	format, err := inputFormat(cmd)
 	if err != nil {
 		return err
 	}
-	addr := cmd.String("address")
-	if strings.TrimSpace(addr) == "" {
-		return fmt.Errorf("address of platform must be specified")
-	}
-	return tcp.ConnectAndTransferData(addr, func(conn net.Conn) error {
-		w := bufio.NewWriter(conn)
-		b, _ := yaml.Marshal(depl)
-		connectCmd := core.CmdConnect{
-			Cmd:     core.CmdConnectReq,
-			Payload: b,
+	ds, err := loadDeployments(cmd.StringSlice("file"), cmd.Bool("strict"), cmd.String("overlay"), format)
+	if err != nil {
+		return err
+	}
+	sortDeployments(ds)
+	if files := cmd.StringSlice("assets"); len(files) > 0 {
+		if len(ds) != 1 {
+			return fmt.Errorf("assets can only be deployed with a single deployment, found %d", len(ds))
 		}
-		msgs, err := tcp.Pack(connectCmd.Pack(), uint32(maxPayloadSize))
+		if ds[0].Assets, err = readAssets(files); err != nil {
+			return err
+		}
+	}
+	c, err := currentContext(cmd)
+	if err != nil {
+		return err
+	}
+	d, err := newDeployer(cmd, c)
+	if err != nil {
+		return err
+	}
+	defer d.client.Close()
+	if keyFile := c.SigningKey; strings.TrimSpace(keyFile) != "" {
+		d.key, err = core.LoadPrivateKey(keyFile)
 		if err != nil {
 			return err
 		}
-		for _, msg := range msgs {
-			_, err := w.Write(msg)
-			if err != nil {
-				return err
-			}
+	}
+	if cmd.Bool("dry-run") {
+		err = d.diffBatch(ctx, ds)
+		if perr := printDiffs(os.Stdout, ds, output); perr != nil {
+			return perr
 		}
-		err = w.Flush()
+		return err
+	}
+	err = d.deployBatch(ctx, ds, cmd.Bool("rollback"))
+	if perr := printSummary(os.Stdout, ds, output); perr != nil {
+		return perr
+	}
+	return err
+}
+
+// newDeployer connects to the control plane of c.
+func newDeployer(cmd *cli.Command, c Context) (*deployer, error) {
+	addr := c.Address
+	if strings.TrimSpace(addr) == "" {
+		return nil, fmt.Errorf("address of platform must be specified")
+	}
+	opts := tcp.DefaultClientOptions(addr)
+	opts.MaxPayloadSize = uint32(cmd.Uint("max-payload-size"))
+	opts.Idempotent = core.IsIdempotent
+	opts.KeepAlive = cmd.Duration("keepalive")
+	opts.Heartbeat = core.NewHeartbeat(0, 0)
+	opts.Handshake = core.NewHandshake(cmd.String("compression"), c.Token)
+	if c.TLS != nil {
+		tlsConfig, err := tcp.ClientTLSConfig(c.TLS.CA, c.TLS.Cert, c.TLS.Key, c.TLS.ServerName, c.TLS.InsecureSkipVerify)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		return nil
-	})
+		opts.TLSConfig = tlsConfig
+	}
+	// a single pooled connection keeps the whole batch in one session
+	opts.PoolSize = 1
+	return &deployer{client: tcp.NewClient(opts)}, nil
+}
+
+func validate(ctx context.Context, cmd *cli.Command) error {
+	format, err := inputFormat(cmd)
+	if err != nil {
+		return err
+	}
+	ds, err := loadDeployments(cmd.StringSlice("file"), cmd.Bool("strict"), cmd.String("overlay"), format)
+	if err != nil {
+		return err
+	}
+	for _, d := range ds {
+		fmt.Printf("%s is valid\n", d.Source)
+	}
+	return nil
+}
+
+func outputFormat(cmd *cli.Command) (string, error) {
+	switch o := strings.ToLower(strings.TrimSpace(cmd.String("output"))); o {
+	case "", "table", "text":
+		return "table", nil
+	case "json":
+		return o, nil
+	default:
+		return "", fmt.Errorf("unknown output format %s", o)
+	}
 }
 
-func readDeploymentFile(f string) (core.DeploymentRequest, error) {
-	var d core.DeploymentRequest
+func inputFormat(cmd *cli.Command) (core.Format, error) {
+	if f := cmd.String("format"); strings.TrimSpace(f) != "" {
+		return core.ParseFormat(f)
+	}
+	return "", nil
+}
+
+func readDeploymentFile(f string, strict bool, overlay string, format core.Format) ([]core.DeploymentRequest, error) {
 	b, err := os.ReadFile(f)
 	if err != nil {
-		return d, err
+		return nil, err
+	}
+	opts := core.ParseOptions{
+		Strict: strict,
+		Env:    os.LookupEnv,
+		Format: format,
+	}
+	if o := overlayFile(f, overlay); o != "" {
+		ob, err := os.ReadFile(o)
+		if err != nil {
+			return nil, err
+		}
+		opts.Overlays = append(opts.Overlays, core.Overlay{File: o, Content: ob})
+	}
+	ds, err := core.ParseDeploymentsWith(b, opts)
+	var errs core.ValidationErrors
+	if errors.As(err, &errs) {
+		for _, e := range errs {
+			if e.File == "" {
+				e.File = f
+			}
+			fmt.Fprintln(os.Stderr, e.Error())
+		}
+		return ds, fmt.Errorf("%s has %d error(s)", f, len(errs))
 	}
-	err = yaml.Unmarshal(b, &d)
 	if err != nil {
-		return d, err
+		fmt.Fprintf(os.Stderr, "%s: %v\n", f, err)
 	}
-	return d, nil
+	return ds, err
 }
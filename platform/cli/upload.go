@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"goruf/platform/core"
+	"goruf/platform/tcp"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v3"
+)
+
+// localAsset is a file uploaded as the asset at Path.
+type localAsset struct {
+	Path string
+	File string
+}
+
+// readAssets returns the assets of files, a file is uploaded under its name
+// and the files of a directory under their path relative to it.
+func readAssets(files []string) ([]localAsset, error) {
+	rs := make([]localAsset, 0)
+	seen := make(map[string]string)
+	add := func(p string, file string) error {
+		p, err := core.CleanAssetPath(filepath.ToSlash(p))
+		if err != nil {
+			return err
+		}
+		if other, ok := seen[p]; ok {
+			return fmt.Errorf("%s and %s are both uploaded as %s", other, file, p)
+		}
+		seen[p] = file
+		rs = append(rs, localAsset{Path: p, File: file})
+		return nil
+	}
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			if err := add(filepath.Base(f), f); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		err = filepath.WalkDir(f, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(f, p)
+			if err != nil {
+				return err
+			}
+			return add(rel, p)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(rs, func(i, j int) bool {
+		return rs[i].Path < rs[j].Path
+	})
+	return rs, nil
+}
+
+// assetDigests returns the digests of the assets keyed by path, nil when
+// there are none.
+func assetDigests(assets []localAsset) (map[string]string, error) {
+	if len(assets) == 0 {
+		return nil, nil
+	}
+	rs := make(map[string]string, len(assets))
+	for _, a := range assets {
+		content, err := os.ReadFile(a.File)
+		if err != nil {
+			return nil, err
+		}
+		rs[a.Path] = core.AssetDigest(content)
+	}
+	return rs, nil
+}
+
+// uploadAssets uploads the assets to the release active at endpoint.
+func (d *deployer) uploadAssets(ctx context.Context, endpoint string, assets []localAsset) ([]core.AssetInfo, error) {
+	rs := make([]core.AssetInfo, 0, len(assets))
+	for _, a := range assets {
+		content, err := os.ReadFile(a.File)
+		if err != nil {
+			return rs, err
+		}
+		req, rep := core.UploadCommandOf(a.Path)
+		reply, err := d.expect(ctx, core.NewUpload(req, endpoint, a.Path, content), rep)
+		if err != nil {
+			return rs, fmt.Errorf("failed to upload %s: %w", a.File, err)
+		}
+		payload, err := tcp.GetTlv(tcp.TypePayload, reply)
+		if err != nil {
+			return rs, fmt.Errorf("asset of %s is missing from reply", a.File)
+		}
+		var info core.AssetInfo
+		if err := json.Unmarshal(payload.Value, &info); err != nil {
+			return rs, err
+		}
+		rs = append(rs, info)
+	}
+	return rs, nil
+}
+
+func upload(ctx context.Context, cmd *cli.Command) error {
+	endpoint, err := endpointArg(cmd)
+	if err != nil {
+		return err
+	}
+	if cmd.Args().Len() < 2 {
+		return fmt.Errorf("files to upload must be specified")
+	}
+	output, err := outputFormat(cmd)
+	if err != nil {
+		return err
+	}
+	assets, err := readAssets(cmd.Args().Tail())
+	if err != nil {
+		return err
+	}
+	c, err := currentContext(cmd)
+	if err != nil {
+		return err
+	}
+	d, err := newDeployer(cmd, c)
+	if err != nil {
+		return err
+	}
+	defer d.client.Close()
+	rs, err := d.uploadAssets(ctx, endpoint, assets)
+	if output == "json" {
+		if perr := printJson(os.Stdout, rs); perr != nil {
+			return perr
+		}
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PATH\tDIGEST\tINTEGRITY")
+	for _, a := range rs {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", a.Path, a.Digest, a.Integrity)
+	}
+	if perr := tw.Flush(); perr != nil {
+		return perr
+	}
+	return err
+}
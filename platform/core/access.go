@@ -0,0 +1,48 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Access restricts a resource to the users having any of Roles and, for each
+// claim of Claims, one of its values. Everyone is allowed when both are empty.
+type Access struct {
+	Roles []string `yaml:"Roles,omitempty" json:"Roles,omitempty" toml:"Roles,omitempty"`
+	// Claims are keyed by claim name, nested claims are named by their path,
+	// e.g. realm_access.roles.
+	Claims map[string][]string `yaml:"Claims,omitempty" json:"Claims,omitempty" toml:"Claims,omitempty"`
+}
+
+// IsEmpty reports whether a allows everyone.
+func (a *Access) IsEmpty() bool {
+	return a == nil || len(a.Roles) == 0 && len(a.Claims) == 0
+}
+
+// Access returns the access of the item.
+func (n Navigation) Access() Access {
+	return Access{Roles: n.Roles, Claims: n.Claims}
+}
+
+// validateAccess checks the roles and claims of the access at path.
+func (v *validator) validateAccess(path string, roles []string, claims map[string][]string) {
+	for j, role := range roles {
+		if strings.TrimSpace(role) == "" {
+			v.errorf(fmt.Sprintf("%s.Roles[%d]", path, j), "role must not be empty")
+		}
+	}
+	names := make([]string, 0, len(claims))
+	for name := range claims {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		switch {
+		case strings.TrimSpace(name) == "":
+			v.errorf(path+".Claims", "claim name must not be empty")
+		case len(claims[name]) == 0:
+			v.errorf(path+".Claims."+name, "claim %s needs at least one value", name)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package core
+
+import "time"
+
+// DeploymentInfo describes a release of an endpoint as reported to mfe.cli.
+type DeploymentInfo struct {
+	Id          string    `json:"id"`
+	Endpoint    string    `json:"endpoint"`
+	Kind        Kind      `json:"kind"`
+	Version     string    `json:"version,omitempty"`
+	Container   string    `json:"container,omitempty"`
+	Signer      string    `json:"signer,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+	Active      bool      `json:"active"`
+	Navigations int       `json:"navigations"`
+	Proxies     int       `json:"proxies"`
+	Assets      int       `json:"assets"`
+	// Deployment is only filled in when a single deployment is requested.
+	Deployment *DeploymentRequest `json:"deployment,omitempty"`
+}
+
+type ServerStatus struct {
+	Version     string    `json:"version"`
+	StartedAt   time.Time `json:"startedAt"`
+	Deployments int       `json:"deployments"`
+	Releases    int       `json:"releases"`
+	Connections int64     `json:"connections"`
+}
+
+const (
+	AuditSourceApi     = "api"
+	AuditSourceCluster = "cluster"
+)
+
+// AuditEntry records a mutating action of an operator, whether it succeeded or not.
+type AuditEntry struct {
+	Id   string    `json:"id"`
+	Time time.Time `json:"time"`
+	// Actor is the token name or certificate subject the operator authenticated with.
+	Actor   string `json:"actor"`
+	Address string `json:"address"`
+	// Source is the admin API or the cluster port.
+	Source   string `json:"source"`
+	Command  string `json:"command"`
+	Endpoint string `json:"endpoint,omitempty"`
+	// Error is empty when the action succeeded.
+	Error string       `json:"error,omitempty"`
+	Diff  *ReleaseDiff `json:"diff,omitempty"`
+}
+
+// AssetInfo describes an uploaded asset, Integrity is the value of the
+// integrity attribute of the elements loading it.
+type AssetInfo struct {
+	Path      string `json:"path"`
+	Url       string `json:"url,omitempty"`
+	Digest    string `json:"digest"`
+	Integrity string `json:"integrity"`
+}
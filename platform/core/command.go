@@ -1,6 +1,12 @@
 package core
 
-import "goruf/platform/tcp"
+import (
+	"encoding/json"
+	"goruf/platform/tcp"
+	"path"
+	"strings"
+	"time"
+)
 
 const (
 	CmdConnectReq uint32 = iota
@@ -9,16 +15,152 @@ const (
 	CmdUploadJsRep
 	CmdUploadCssReq
 	CmdUploadCssRep
+	CmdPingReq
+	CmdPingRep
+	CmdRollbackReq
+	CmdRollbackRep
+	CmdListReq
+	CmdListRep
+	CmdGetReq
+	CmdGetRep
+	CmdDeleteReq
+	CmdDeleteRep
+	CmdReleasesReq
+	CmdReleasesRep
+	CmdStatusReq
+	CmdStatusRep
+)
+
+const (
+	TypeSignature   uint8 = 20
+	TypeKeyId       uint8 = 21
+	TypeEndpoint    uint8 = 22
+	TypeContentType uint8 = 23
+	TypeDryRun      uint8 = 24
+	TypeToken       uint8 = 25
+	TypePath        uint8 = 26
+	TypeAssets      uint8 = 27
 )
 
 type CmdConnect struct {
-	Cmd     uint32
-	Payload []byte
+	Cmd         uint32
+	Payload     []byte
+	ContentType string
+	KeyId       string
+	Signature   []byte
+	// DryRun asks the server for the diff against the active release without applying it.
+	DryRun bool
+	// Assets are the digests of the assets of the deployment keyed by path,
+	// they are signed with Payload and uploads must match them.
+	Assets map[string]string
 }
 
 func (c CmdConnect) Pack() []byte {
-	return tcp.Join(
+	tlvs := []tcp.Tlv{
 		tcp.TlvUInt32(tcp.TypeCmd, c.Cmd),
 		tcp.NewTlv(tcp.TypePayload, c.Payload),
+	}
+	if c.ContentType != "" {
+		tlvs = append(tlvs, tcp.TlvString(TypeContentType, c.ContentType))
+	}
+	if c.DryRun {
+		tlvs = append(tlvs, tcp.NewTlv(TypeDryRun, []byte{0x01}))
+	}
+	if len(c.Assets) > 0 {
+		// a map of strings always encodes
+		b, _ := json.Marshal(c.Assets)
+		tlvs = append(tlvs, tcp.NewTlv(TypeAssets, b))
+	}
+	if len(c.Signature) > 0 {
+		tlvs = append(tlvs,
+			tcp.TlvString(TypeKeyId, c.KeyId),
+			tcp.NewTlv(TypeSignature, c.Signature),
+		)
+	}
+	return tcp.Join(tlvs...)
+}
+
+// NewRollback asks the server to restore the release of endpoint that was
+// active before the last deployment.
+func NewRollback(endpoint string) []byte {
+	return NewRequest(CmdRollbackReq, endpoint)
+}
+
+// NewUpload uploads content as the asset at path of the release active at
+// endpoint, cmd is CmdUploadJsReq or CmdUploadCssReq.
+func NewUpload(cmd uint32, endpoint string, path string, content []byte) []byte {
+	return tcp.Join(
+		tcp.TlvUInt32(tcp.TypeCmd, cmd),
+		tcp.TlvString(TypeEndpoint, endpoint),
+		tcp.TlvString(TypePath, path),
+		tcp.NewTlv(tcp.TypePayload, content),
 	)
 }
+
+// UploadCommandOf returns the request and reply commands uploading the
+// asset at p, stylesheets are uploaded as CSS and any other asset as JS.
+func UploadCommandOf(p string) (uint32, uint32) {
+	if strings.EqualFold(path.Ext(p), ".css") {
+		return CmdUploadCssReq, CmdUploadCssRep
+	}
+	return CmdUploadJsReq, CmdUploadJsRep
+}
+
+// NewRequest builds a request for cmd, scoped to endpoint unless it is empty.
+func NewRequest(cmd uint32, endpoint string) []byte {
+	tlvs := []tcp.Tlv{tcp.TlvUInt32(tcp.TypeCmd, cmd)}
+	if endpoint != "" {
+		tlvs = append(tlvs, tcp.TlvString(TypeEndpoint, endpoint))
+	}
+	return tcp.Join(tlvs...)
+}
+
+// NewHandshake returns the CmdConnectReq sent when a connection is opened,
+// offering codecs, a comma separated list in order of preference, and the
+// token authenticating the client, if any.
+func NewHandshake(codecs string, token string) []byte {
+	tlvs := []tcp.Tlv{
+		tcp.TlvUInt32(tcp.TypeCmd, CmdConnectReq),
+		tcp.TlvString(tcp.TypeCodec, codecs),
+	}
+	if token != "" {
+		tlvs = append(tlvs, tcp.TlvString(TypeToken, token))
+	}
+	return tcp.Join(tlvs...)
+}
+
+// IsIdempotent reports whether the request in payload can be safely retried.
+// A connect request carrying a deployment activates a new release every
+// time it is applied, only its dry run can be retried.
+func IsIdempotent(payload []byte) bool {
+	cmd, err := tcp.GetTlv(tcp.TypeCmd, payload)
+	if err != nil {
+		return false
+	}
+	switch cmd.GetUInt32() {
+	case CmdConnectReq:
+		if _, err := tcp.GetTlv(tcp.TypePayload, payload); err != nil {
+			return true
+		}
+		dryRun, _ := tcp.GetTlv(TypeDryRun, payload)
+		return dryRun.GetBool()
+	case CmdPingReq, CmdListReq, CmdGetReq, CmdReleasesReq, CmdStatusReq:
+		return true
+	default:
+		return false
+	}
+}
+
+// NewHeartbeat returns the heartbeat settings of the cluster protocol.
+func NewHeartbeat(interval time.Duration, maxMissed int) tcp.Heartbeat {
+	return tcp.Heartbeat{
+		Interval:  interval,
+		MaxMissed: maxMissed,
+		Ping:      tcp.Join(tcp.TlvUInt32(tcp.TypeCmd, CmdPingReq)),
+		Pong:      tcp.Join(tcp.TlvUInt32(tcp.TypeCmd, CmdPingRep)),
+		IsPing: func(payload []byte) bool {
+			cmd, err := tcp.GetTlv(tcp.TypeCmd, payload)
+			return err == nil && cmd.GetUInt32() == CmdPingReq
+		},
+	}
+}
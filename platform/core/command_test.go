@@ -0,0 +1,24 @@
+package core
+
+import "testing"
+
+func TestIsIdempotent(t *testing.T) {
+	deploy := CmdConnect{Cmd: CmdConnectReq, Payload: []byte("Kind: container\nEndpoint: shop\n")}
+	dryRun := deploy
+	dryRun.DryRun = true
+	expected := map[string]bool{
+		"handshake": IsIdempotent(NewHandshake("zstd", "")),
+		"dry run":   IsIdempotent(dryRun.Pack()),
+		"list":      IsIdempotent(NewRequest(CmdListReq, "")),
+	}
+	for name, idempotent := range expected {
+		if !idempotent {
+			t.Logf("expected %s to be retried", name)
+			t.FailNow()
+		}
+	}
+	if IsIdempotent(deploy.Pack()) || IsIdempotent(NewRollback("shop")) || IsIdempotent(NewUpload(CmdUploadJsReq, "shop", "index.js", nil)) {
+		t.Logf("expected deploy, rollback and upload not to be retried")
+		t.FailNow()
+	}
+}
@@ -0,0 +1,67 @@
+package core
+
+import "strings"
+
+// CorsAnyOrigin allows every origin, it cannot be combined with credentials.
+const CorsAnyOrigin = "*"
+
+// DefaultCorsMethods are allowed when a policy does not list its methods.
+var DefaultCorsMethods = []string{"GET", "HEAD", "POST"}
+
+// Cors lets pages of other origins load the assets and resources of a
+// deployment, only the same origin may when it is not declared.
+type Cors struct {
+	// AllowedOrigins are origins such as https://shop.example, a leading *.
+	// in the host matches its subdomains, * matches any origin.
+	AllowedOrigins []string `yaml:"AllowedOrigins,omitempty" json:"AllowedOrigins,omitempty" toml:"AllowedOrigins,omitempty"`
+	// AllowedMethods default to DefaultCorsMethods.
+	AllowedMethods   []string `yaml:"AllowedMethods,omitempty" json:"AllowedMethods,omitempty" toml:"AllowedMethods,omitempty"`
+	AllowedHeaders   []string `yaml:"AllowedHeaders,omitempty" json:"AllowedHeaders,omitempty" toml:"AllowedHeaders,omitempty"`
+	ExposedHeaders   []string `yaml:"ExposedHeaders,omitempty" json:"ExposedHeaders,omitempty" toml:"ExposedHeaders,omitempty"`
+	AllowCredentials bool     `yaml:"AllowCredentials,omitempty" json:"AllowCredentials,omitempty" toml:"AllowCredentials,omitempty"`
+	// MaxAge is how long browsers cache the answer to a preflight request.
+	MaxAge Duration `yaml:"MaxAge,omitempty" json:"MaxAge,omitempty" toml:"MaxAge,omitempty"`
+}
+
+// AllowsOrigin reports whether a page of origin may send requests.
+func (c Cors) AllowsOrigin(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, allowed := range c.AllowedOrigins {
+		allowed = strings.ToLower(allowed)
+		if allowed == CorsAnyOrigin || allowed == origin {
+			return true
+		}
+		scheme, host, ok := strings.Cut(allowed, "://*.")
+		if ok && strings.HasPrefix(origin, scheme+"://") && strings.HasSuffix(origin, "."+host) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Cors) MethodsOf() []string {
+	if len(c.AllowedMethods) == 0 {
+		return DefaultCorsMethods
+	}
+	return c.AllowedMethods
+}
+
+// AllowsMethod reports whether method may be sent, method names are case sensitive.
+func (c Cors) AllowsMethod(method string) bool {
+	for _, m := range c.MethodsOf() {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsHeader reports whether the header name may be sent.
+func (c Cors) AllowsHeader(name string) bool {
+	for _, h := range c.AllowedHeaders {
+		if h == "*" || strings.EqualFold(h, name) {
+			return true
+		}
+	}
+	return false
+}
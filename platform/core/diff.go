@@ -0,0 +1,183 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+)
+
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	ChangeChanged = "changed"
+)
+
+// Release is a deployment together with the digests of its assets keyed by path.
+type Release struct {
+	Deployment DeploymentRequest
+	Assets     map[string]string
+}
+
+type Change struct {
+	Op     string `json:"op"`
+	Key    string `json:"key"`
+	Before any    `json:"before,omitempty"`
+	After  any    `json:"after,omitempty"`
+}
+
+// ReleaseDiff describes what activating a release would change compared to
+// the release currently active at the same endpoint.
+type ReleaseDiff struct {
+	Endpoint    string   `json:"endpoint"`
+	Initial     bool     `json:"initial"`
+	Fields      []Change `json:"fields,omitempty"`
+	Navigations []Change `json:"navigations,omitempty"`
+	Proxies     []Change `json:"proxies,omitempty"`
+	Assets      []Change `json:"assets,omitempty"`
+	Warnings    []string `json:"warnings,omitempty"`
+}
+
+// DiffReleases compares next with current, which is nil when nothing is deployed yet.
+func DiffReleases(current *Release, next Release) ReleaseDiff {
+	d := ReleaseDiff{
+		Endpoint: NormalizeEndpoint(next.Deployment.Endpoint),
+		Initial:  current == nil,
+	}
+	if current == nil {
+		current = &Release{}
+	}
+	before, after := current.Deployment, next.Deployment
+	d.Fields = diffFields(map[string][2]any{
+		"Kind":      {string(before.Kind), string(after.Kind)},
+		"Version":   {before.Version, after.Version},
+		"Container": {before.Container, after.Container},
+		"Locales":   {before.Locales, after.Locales},
+		"Access":    {before.Access, after.Access},
+		"Cors":      {before.Cors, after.Cors},
+	})
+	d.Navigations = diffByKey(before.Navigations, after.Navigations, Navigation.Key)
+	d.Proxies = diffByKey(before.Proxies, after.Proxies, func(p Proxy) string {
+		return p.BackendCode
+	})
+	d.Assets = diffAssets(current.Assets, next.Assets)
+	return d
+}
+
+func (d ReleaseDiff) HasChanges() bool {
+	return d.Initial || len(d.Fields)+len(d.Navigations)+len(d.Proxies)+len(d.Assets) > 0
+}
+
+// Print writes the diff in a human readable form.
+func (d ReleaseDiff) Print(w io.Writer) {
+	title := d.Endpoint
+	switch {
+	case d.Initial:
+		title += " (new)"
+	case !d.HasChanges():
+		title += " (unchanged)"
+	}
+	fmt.Fprintln(w, title)
+	print := func(kind string, changes []Change) {
+		for _, c := range changes {
+			switch c.Op {
+			case ChangeAdded:
+				fmt.Fprintf(w, "  + %s %s %s\n", kind, c.Key, formatValue(c.After))
+			case ChangeRemoved:
+				fmt.Fprintf(w, "  - %s %s %s\n", kind, c.Key, formatValue(c.Before))
+			default:
+				fmt.Fprintf(w, "  ~ %s %s %s -> %s\n", kind, c.Key, formatValue(c.Before), formatValue(c.After))
+			}
+		}
+	}
+	print("field", d.Fields)
+	print("navigation", d.Navigations)
+	print("proxy", d.Proxies)
+	print("asset", d.Assets)
+	for _, warning := range d.Warnings {
+		fmt.Fprintf(w, "  ! %s\n", warning)
+	}
+}
+
+// formatValue prints strings as is and anything else as compact JSON.
+func formatValue(v any) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+func diffFields(fields map[string][2]any) []Change {
+	rs := make([]Change, 0)
+	for name, v := range fields {
+		if reflect.DeepEqual(v[0], v[1]) {
+			continue
+		}
+		c := Change{Op: ChangeChanged, Key: name, Before: v[0], After: v[1]}
+		if reflect.ValueOf(v[0]).IsZero() {
+			c.Op = ChangeAdded
+		} else if reflect.ValueOf(v[1]).IsZero() {
+			c.Op = ChangeRemoved
+		}
+		rs = append(rs, c)
+	}
+	sort.Slice(rs, func(i, j int) bool {
+		return rs[i].Key < rs[j].Key
+	})
+	return rs
+}
+
+// diffByKey compares two lists whose items are identified by key, keeping
+// the order of after followed by removed items.
+func diffByKey[T any](before []T, after []T, key func(T) string) []Change {
+	rs := make([]Change, 0)
+	old := make(map[string]T)
+	for _, item := range before {
+		old[key(item)] = item
+	}
+	seen := make(map[string]bool)
+	for _, item := range after {
+		k := key(item)
+		seen[k] = true
+		prev, ok := old[k]
+		switch {
+		case !ok:
+			rs = append(rs, Change{Op: ChangeAdded, Key: k, After: item})
+		case !reflect.DeepEqual(prev, item):
+			rs = append(rs, Change{Op: ChangeChanged, Key: k, Before: prev, After: item})
+		}
+	}
+	for _, item := range before {
+		if k := key(item); !seen[k] {
+			rs = append(rs, Change{Op: ChangeRemoved, Key: k, Before: item})
+		}
+	}
+	return rs
+}
+
+func diffAssets(before map[string]string, after map[string]string) []Change {
+	rs := make([]Change, 0)
+	for path, digest := range after {
+		prev, ok := before[path]
+		switch {
+		case !ok:
+			rs = append(rs, Change{Op: ChangeAdded, Key: path, After: digest})
+		case prev != digest:
+			rs = append(rs, Change{Op: ChangeChanged, Key: path, Before: prev, After: digest})
+		}
+	}
+	for path, digest := range before {
+		if _, ok := after[path]; !ok {
+			rs = append(rs, Change{Op: ChangeRemoved, Key: path, Before: digest})
+		}
+	}
+	sort.Slice(rs, func(i, j int) bool {
+		return rs[i].Key < rs[j].Key
+	})
+	return rs
+}
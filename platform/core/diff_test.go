@@ -0,0 +1,59 @@
+package core
+
+import "testing"
+
+func TestDiffReleases(t *testing.T) {
+	current := Release{
+		Deployment: DeploymentRequest{
+			Kind:     KindContainer,
+			Version:  "v1",
+			Endpoint: "portal",
+			Proxies: []Proxy{
+				{BackendCode: "orders", BackendAddress: "orders"},
+				{BackendCode: "billing", BackendAddress: "billing"},
+			},
+			Navigations: []Navigation{{Endpoint: "/home", Title: "Home"}},
+		},
+		Assets: map[string]string{"index.js": "a", "main.css": "b"},
+	}
+	next := Release{
+		Deployment: DeploymentRequest{
+			Kind:     KindContainer,
+			Version:  "v2",
+			Endpoint: "portal",
+			Proxies: []Proxy{
+				{BackendCode: "orders", BackendAddress: "orders:8080"},
+			},
+			Navigations: []Navigation{
+				{Endpoint: "/home", Title: "Home"},
+				{Endpoint: "/orders", Title: "Orders"},
+			},
+		},
+		Assets: map[string]string{"index.js": "c", "main.css": "b"},
+	}
+	d := DiffReleases(&current, next)
+	if d.Initial || !d.HasChanges() {
+		t.Logf("diff should have changes against the current release")
+		t.FailNow()
+	}
+	if len(d.Fields) != 1 || d.Fields[0].Key != "Version" {
+		t.Logf("expected version change, actual = %v", d.Fields)
+		t.FailNow()
+	}
+	if len(d.Proxies) != 2 || d.Proxies[0].Op != ChangeChanged || d.Proxies[1].Op != ChangeRemoved {
+		t.Logf("expected changed and removed proxy, actual = %v", d.Proxies)
+		t.FailNow()
+	}
+	if len(d.Navigations) != 1 || d.Navigations[0].Op != ChangeAdded {
+		t.Logf("expected added navigation, actual = %v", d.Navigations)
+		t.FailNow()
+	}
+	if len(d.Assets) != 1 || d.Assets[0].Key != "index.js" {
+		t.Logf("expected changed asset, actual = %v", d.Assets)
+		t.FailNow()
+	}
+	if DiffReleases(&current, current).HasChanges() {
+		t.Logf("same release should have no changes")
+		t.FailNow()
+	}
+}
@@ -0,0 +1,110 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Format is an encoding of deployment descriptors. Whatever the format,
+// descriptors are decoded into the YAML node tree used for validation and
+// overlays, then into DeploymentRequest, so they convert without loss.
+type Format string
+
+const (
+	FormatYaml Format = "yaml"
+	FormatJson Format = "json"
+	FormatToml Format = "toml"
+)
+
+var contentTypes = map[Format]string{
+	FormatYaml: "application/yaml",
+	FormatJson: "application/json",
+	FormatToml: "application/toml",
+}
+
+func ParseFormat(name string) (Format, error) {
+	f := Format(strings.ToLower(strings.TrimSpace(name)))
+	if f == "yml" {
+		f = FormatYaml
+	}
+	if _, ok := contentTypes[f]; !ok {
+		return "", fmt.Errorf("unsupported format %s", name)
+	}
+	return f, nil
+}
+
+// FormatOf picks the format of a file from its extension.
+func FormatOf(path string) (Format, error) {
+	return ParseFormat(strings.TrimPrefix(filepath.Ext(path), "."))
+}
+
+func (f Format) ContentType() string {
+	return contentTypes[f]
+}
+
+// FormatOfContentType returns the format of a payload, YAML when ct is empty.
+func FormatOfContentType(ct string) (Format, error) {
+	if strings.TrimSpace(ct) == "" {
+		return FormatYaml, nil
+	}
+	for f, t := range contentTypes {
+		if t == ct {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported content type %s", ct)
+}
+
+// MarshalDeployment encodes d in format f.
+func MarshalDeployment(d DeploymentRequest, f Format) ([]byte, error) {
+	switch f {
+	case FormatJson:
+		return json.MarshalIndent(d, "", "  ")
+	case FormatToml:
+		var buf bytes.Buffer
+		if err := toml.NewEncoder(&buf).Encode(d); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		return yaml.Marshal(d)
+	}
+}
+
+// decodeDocuments returns the root nodes of the non-empty documents of b.
+// A JSON array holds one document per item. TOML carries no positions, so
+// errors found in TOML descriptors are reported without line and column.
+func decodeDocuments(b []byte, f Format) ([]*yaml.Node, error) {
+	if f == FormatToml {
+		var m map[string]any
+		if _, err := toml.Decode(string(b), &m); err != nil {
+			return nil, err
+		}
+		var root yaml.Node
+		if err := root.Encode(m); err != nil {
+			return nil, err
+		}
+		return []*yaml.Node{{Kind: yaml.DocumentNode, Content: []*yaml.Node{&root}}}, nil
+	}
+	docs, err := decodeYamlDocuments(b)
+	if err != nil || f != FormatJson {
+		return docs, err
+	}
+	rs := make([]*yaml.Node, 0, len(docs))
+	for _, doc := range docs {
+		if doc.Content[0].Kind != yaml.SequenceNode {
+			rs = append(rs, doc)
+			continue
+		}
+		for _, item := range doc.Content[0].Content {
+			rs = append(rs, &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{item}})
+		}
+	}
+	return rs, nil
+}
@@ -0,0 +1,35 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMarshalDeploymentRoundTrip(t *testing.T) {
+	d := DeploymentRequest{
+		Version:  "v1.0.0",
+		Kind:     KindContainer,
+		Endpoint: "portal",
+		Proxies: []Proxy{
+			{BackendCode: "orders", BackendAddress: "orders:8080", Secure: true},
+			{BackendCode: "billing", BackendAddress: "billing"},
+		},
+		Navigations: []Navigation{{Endpoint: "/orders", Title: "Orders"}},
+	}
+	for _, f := range []Format{FormatYaml, FormatJson, FormatToml} {
+		b, err := MarshalDeployment(d, f)
+		if err != nil {
+			t.Logf("failed to MarshalDeployment(d, %s): %v", f, err)
+			t.FailNow()
+		}
+		actual, err := ParseDeploymentAs(b, f, true)
+		if err != nil {
+			t.Logf("failed to ParseDeploymentAs(b, %s): %v", f, err)
+			t.FailNow()
+		}
+		if !reflect.DeepEqual(d, actual) {
+			t.Logf("deployment should survive a round trip through %s, actual = %v", f, actual)
+			t.FailNow()
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package core
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// AssetDigest returns the hex encoded sha256 digest assets are addressed
+// and signed by, see Manifest.
+func AssetDigest(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// Integrity returns the Subresource Integrity metadata of content, browsers
+// refuse to run a script or apply a stylesheet that does not match it.
+func Integrity(content []byte) string {
+	sum := sha512.Sum384(content)
+	return "sha384-" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// AssetManifestPath is served under /cdn/{endpoint}/ with the digests and
+// integrity of the assets of the deployment, it cannot be uploaded.
+const AssetManifestPath = "asset-manifest.json"
+
+// ValidateAssets checks the paths and digests of the assets of a manifest.
+func ValidateAssets(assets map[string]string) error {
+	for p, digest := range assets {
+		if clean, err := CleanAssetPath(p); err != nil {
+			return err
+		} else if clean != p {
+			return fmt.Errorf("asset path %q is not clean", p)
+		}
+		if b, err := hex.DecodeString(digest); err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("digest of asset %s is not a sha256 digest", p)
+		}
+	}
+	return nil
+}
+
+// CleanAssetPath returns p relative to the assets of a deployment, it is
+// rejected when it leaves them.
+func CleanAssetPath(p string) (string, error) {
+	clean := path.Clean("/" + strings.TrimSpace(p))[1:]
+	if clean == "" || clean != strings.TrimPrefix(strings.TrimSpace(p), "/") {
+		return "", fmt.Errorf("asset path %q is not clean", p)
+	}
+	if clean == AssetManifestPath {
+		return "", fmt.Errorf("asset path %s is reserved", AssetManifestPath)
+	}
+	return clean, nil
+}
@@ -0,0 +1,73 @@
+package core
+
+import (
+	"sort"
+	"strings"
+)
+
+type Kind string
+
+const (
+	KindContainer Kind = "container"
+	KindMicroapp  Kind = "microapp"
+	KindCdn       Kind = "cdn"
+)
+
+// KindSpec declares what a kind of deployment must provide and how the
+// platform serves it.
+type KindSpec struct {
+	Name Kind
+	// Required lists the descriptor fields that must be set.
+	Required []string
+	// Forbidden lists the descriptor fields that must be left empty.
+	Forbidden []string
+	// RendersShell tells the platform to serve an HTML shell at /{endpoint}/.
+	RendersShell bool
+	// Mountable kinds are added to the import map of the container they name.
+	Mountable bool
+	// PublishesAssets kinds have their assets served under /cdn/{endpoint}/.
+	PublishesAssets bool
+}
+
+var kinds = map[Kind]KindSpec{
+	KindContainer: {
+		Name:            KindContainer,
+		Required:        []string{"Endpoint"},
+		Forbidden:       []string{"Container"},
+		RendersShell:    true,
+		PublishesAssets: true,
+	},
+	KindMicroapp: {
+		Name:            KindMicroapp,
+		Required:        []string{"Endpoint", "Container"},
+		Mountable:       true,
+		PublishesAssets: true,
+	},
+	KindCdn: {
+		Name:            KindCdn,
+		Required:        []string{"Endpoint"},
+		Forbidden:       []string{"Container", "Proxies", "Navigations"},
+		PublishesAssets: true,
+	},
+}
+
+func LookupKind(kind string) (KindSpec, bool) {
+	spec, ok := kinds[Kind(strings.ToLower(strings.TrimSpace(kind)))]
+	return spec, ok
+}
+
+// KindNames returns the registered kinds in alphabetical order.
+func KindNames() []string {
+	names := make([]string, 0, len(kinds))
+	for k := range kinds {
+		names = append(names, string(k))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NormalizeEndpoint strips surrounding spaces and slashes so that endpoints
+// can be compared with the first segment of a request path.
+func NormalizeEndpoint(endpoint string) string {
+	return strings.Trim(strings.TrimSpace(endpoint), "/")
+}
@@ -0,0 +1,56 @@
+package core
+
+import (
+	"golang.org/x/text/language"
+)
+
+// CanonicalLocale returns the BCP 47 form of locale, e.g. en-us becomes en-US.
+func CanonicalLocale(locale string) (string, error) {
+	tag, err := language.Parse(locale)
+	if err != nil {
+		return "", err
+	}
+	return tag.String(), nil
+}
+
+// LocaleChain returns the locales to try in order for the given preferences,
+// each followed by its parents, e.g. de-CH then de, and ending with fallback.
+// Invalid preferences are ignored.
+func LocaleChain(preferences []string, fallback string) []string {
+	rs := make([]string, 0)
+	seen := make(map[string]bool)
+	candidates := append(append(make([]string, 0, len(preferences)+1), preferences...), fallback)
+	for _, p := range candidates {
+		tag, err := language.Parse(p)
+		if err != nil {
+			continue
+		}
+		for ; tag != language.Und; tag = tag.Parent() {
+			if s := tag.String(); !seen[s] {
+				seen[s] = true
+				rs = append(rs, s)
+			}
+		}
+	}
+	return rs
+}
+
+// Localize returns the translation of title for the first locale of chain
+// found in titles, or title itself when there is none.
+func Localize(title string, titles map[string]string, chain []string) string {
+	if len(titles) == 0 {
+		return title
+	}
+	canonical := make(map[string]string, len(titles))
+	for locale, t := range titles {
+		if c, err := CanonicalLocale(locale); err == nil {
+			canonical[c] = t
+		}
+	}
+	for _, locale := range chain {
+		if t, ok := canonical[locale]; ok && t != "" {
+			return t
+		}
+	}
+	return title
+}
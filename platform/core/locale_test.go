@@ -0,0 +1,48 @@
+package core
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestLocaleChain(t *testing.T) {
+	chain := LocaleChain([]string{"de-ch", "not a locale", "fr"}, "en")
+	expected := []string{"de-CH", "de", "fr", "en"}
+	if !reflect.DeepEqual(chain, expected) {
+		t.Logf("expected chain %v, actual = %v", expected, chain)
+		t.FailNow()
+	}
+	titles := map[string]string{"de": "Bestellungen", "fr-fr": "Commandes"}
+	if title := Localize("Orders", titles, chain); title != "Bestellungen" {
+		t.Logf("expected de-CH to fall back to de, actual = %s", title)
+		t.FailNow()
+	}
+	if title := Localize("Orders", titles, LocaleChain([]string{"ja"}, "en")); title != "Orders" {
+		t.Logf("expected untranslated title, actual = %s", title)
+		t.FailNow()
+	}
+}
+
+func TestValidateTranslations(t *testing.T) {
+	b := []byte(`Kind: container
+Endpoint: portal
+Locales: [en, de]
+Navigations:
+  - Endpoint: /orders
+    Title: Orders
+    Titles:
+      en: Orders
+      de: Bestellungen
+  - Endpoint: /invoices
+    Title: Invoices
+    Titles:
+      en: Invoices
+`)
+	_, err := ParseDeployment(b, true)
+	var errs ValidationErrors
+	if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Field != "Navigations[1].Titles" || errs[0].Line != 13 {
+		t.Logf("expected missing de translation at line 13, actual = %v", err)
+		t.FailNow()
+	}
+}
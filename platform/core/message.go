@@ -1,20 +1,159 @@
 package core
 
 type DeploymentRequest struct {
-	Version     string       `yaml:"Version,omitempty"`
-	Kind        string       `yaml:"Kind,omitempty"`
-	Endpoint    string       `yaml:"Endpoint,omitempty"`
-	Proxies     []Proxy      `yaml:"Proxies,omitempty"`
-	Navigations []Navigation `yaml:"Navigations,omitempty"`
+	Version   string `yaml:"Version,omitempty" json:"Version,omitempty" toml:"Version,omitempty"`
+	Kind      Kind   `yaml:"Kind,omitempty" json:"Kind,omitempty" toml:"Kind,omitempty"`
+	Endpoint  string `yaml:"Endpoint,omitempty" json:"Endpoint,omitempty" toml:"Endpoint,omitempty"`
+	Container string `yaml:"Container,omitempty" json:"Container,omitempty" toml:"Container,omitempty"`
+	// Locales the deployment is translated to, every title needs a translation for each of them.
+	Locales     []string     `yaml:"Locales,omitempty" json:"Locales,omitempty" toml:"Locales,omitempty"`
+	Proxies     []Proxy      `yaml:"Proxies,omitempty" json:"Proxies,omitempty" toml:"Proxies,omitempty"`
+	Navigations []Navigation `yaml:"Navigations,omitempty" json:"Navigations,omitempty" toml:"Navigations,omitempty"`
+	// Access restricts the shell of the deployment and hides its navigations.
+	Access *Access `yaml:"Access,omitempty" json:"Access,omitempty" toml:"Access,omitempty"`
+	// Cors applies to the assets under /cdn/{endpoint}/ and to the resources
+	// of the backends of the deployment under /resource/{backend}/.
+	Cors *Cors `yaml:"Cors,omitempty" json:"Cors,omitempty" toml:"Cors,omitempty"`
 }
 
+// Navigation is an item of the portal menu. An item with Children is a
+// group, groups of the same Id declared by several deployments are merged.
 type Navigation struct {
-	Endpoint string `yaml:",omitempty"`
-	Title    string `yaml:",omitempty"`
+	Id       string `yaml:"Id,omitempty" json:"Id,omitempty" toml:"Id,omitempty"`
+	Endpoint string `yaml:"Endpoint,omitempty" json:"Endpoint,omitempty" toml:"Endpoint,omitempty"`
+	Title    string `yaml:"Title,omitempty" json:"Title,omitempty" toml:"Title,omitempty"`
+	// Titles holds the translations of Title keyed by locale, e.g. en-US.
+	Titles map[string]string `yaml:"Titles,omitempty" json:"Titles,omitempty" toml:"Titles,omitempty"`
+	Icon   string            `yaml:"Icon,omitempty" json:"Icon,omitempty" toml:"Icon,omitempty"`
+	// Order sorts the items of a level, lower first.
+	Order  int  `yaml:"Order,omitempty" json:"Order,omitempty" toml:"Order,omitempty"`
+	Hidden bool `yaml:"Hidden,omitempty" json:"Hidden,omitempty" toml:"Hidden,omitempty"`
+	// Roles lists the roles of which a user needs any to see the item, and
+	// Claims the claims the user needs, everyone sees it when both are empty.
+	Roles    []string            `yaml:"Roles,omitempty" json:"Roles,omitempty" toml:"Roles,omitempty"`
+	Claims   map[string][]string `yaml:"Claims,omitempty" json:"Claims,omitempty" toml:"Claims,omitempty"`
+	Children []Navigation        `yaml:"Children,omitempty" json:"Children,omitempty" toml:"Children,omitempty"`
+}
+
+// Key identifies the item within its level, the Id of a group or the Endpoint.
+func (n Navigation) Key() string {
+	if n.Id != "" {
+		return n.Id
+	}
+	return n.Endpoint
 }
 
 type Proxy struct {
-	BackendCode    string `yaml:"BackendCode,omitempty"`
-	BackendAddress string `yaml:"BackendAddress,omitempty"`
-	Secure         bool   `yaml:"Secure,omitempty"`
+	BackendCode    string `yaml:"BackendCode,omitempty" json:"BackendCode,omitempty" toml:"BackendCode,omitempty"`
+	BackendAddress string `yaml:"BackendAddress,omitempty" json:"BackendAddress,omitempty" toml:"BackendAddress,omitempty"`
+	Secure         bool   `yaml:"Secure,omitempty" json:"Secure,omitempty" toml:"Secure,omitempty"`
+	// Upstreams are further replicas of the backend, requests are balanced
+	// across BackendAddress and Upstreams.
+	Upstreams    []string      `yaml:"Upstreams,omitempty" json:"Upstreams,omitempty" toml:"Upstreams,omitempty"`
+	LoadBalancer *LoadBalancer `yaml:"LoadBalancer,omitempty" json:"LoadBalancer,omitempty" toml:"LoadBalancer,omitempty"`
+	HealthCheck  *HealthCheck  `yaml:"HealthCheck,omitempty" json:"HealthCheck,omitempty" toml:"HealthCheck,omitempty"`
+	Ejection     *Ejection     `yaml:"Ejection,omitempty" json:"Ejection,omitempty" toml:"Ejection,omitempty"`
+	// Timeouts, Retry and CircuitBreaker make the API proxy resilient to
+	// slow and failing upstreams.
+	Timeouts       *Timeouts       `yaml:"Timeouts,omitempty" json:"Timeouts,omitempty" toml:"Timeouts,omitempty"`
+	Retry          *Retry          `yaml:"Retry,omitempty" json:"Retry,omitempty" toml:"Retry,omitempty"`
+	CircuitBreaker *CircuitBreaker `yaml:"CircuitBreaker,omitempty" json:"CircuitBreaker,omitempty" toml:"CircuitBreaker,omitempty"`
+	// Access restricts the API requests sent to the backend.
+	Access *Access `yaml:"Access,omitempty" json:"Access,omitempty" toml:"Access,omitempty"`
+	// Auth selects the credentials of the signed in user sent to the backend.
+	Auth *ProxyAuth `yaml:"Auth,omitempty" json:"Auth,omitempty" toml:"Auth,omitempty"`
+	// RateLimits are all applied, a request is rejected when one of them is exceeded.
+	RateLimits []RateLimit `yaml:"RateLimits,omitempty" json:"RateLimits,omitempty" toml:"RateLimits,omitempty"`
+	// Routes select the API requests sent to the backend, /api/<BackendCode>
+	// with the prefix stripped when there is none.
+	Routes []Route `yaml:"Routes,omitempty" json:"Routes,omitempty" toml:"Routes,omitempty"`
+}
+
+type LoadBalancer struct {
+	// Strategy is round-robin when empty, see LoadBalancingStrategies.
+	Strategy string `yaml:"Strategy,omitempty" json:"Strategy,omitempty" toml:"Strategy,omitempty"`
+	// HashHeader, or else HashCookie, keys the consistent-hash strategy, the
+	// client address is used when neither is set or sent.
+	HashHeader string `yaml:"HashHeader,omitempty" json:"HashHeader,omitempty" toml:"HashHeader,omitempty"`
+	HashCookie string `yaml:"HashCookie,omitempty" json:"HashCookie,omitempty" toml:"HashCookie,omitempty"`
+}
+
+// HealthCheck periodically requests Path from every upstream, an upstream
+// answering with an error status UnhealthyThreshold times in a row stops
+// receiving requests until it succeeds HealthyThreshold times in a row.
+type HealthCheck struct {
+	Path               string   `yaml:"Path,omitempty" json:"Path,omitempty" toml:"Path,omitempty"`
+	Interval           Duration `yaml:"Interval,omitempty" json:"Interval,omitempty" toml:"Interval,omitempty"`
+	Timeout            Duration `yaml:"Timeout,omitempty" json:"Timeout,omitempty" toml:"Timeout,omitempty"`
+	HealthyThreshold   int      `yaml:"HealthyThreshold,omitempty" json:"HealthyThreshold,omitempty" toml:"HealthyThreshold,omitempty"`
+	UnhealthyThreshold int      `yaml:"UnhealthyThreshold,omitempty" json:"UnhealthyThreshold,omitempty" toml:"UnhealthyThreshold,omitempty"`
+}
+
+// Ejection removes an upstream from the balancing for Duration after
+// MaxFailures consecutive failed requests.
+type Ejection struct {
+	MaxFailures int      `yaml:"MaxFailures,omitempty" json:"MaxFailures,omitempty" toml:"MaxFailures,omitempty"`
+	Duration    Duration `yaml:"Duration,omitempty" json:"Duration,omitempty" toml:"Duration,omitempty"`
+}
+
+// Timeouts bound the time to connect to an upstream and to receive the
+// headers of its response, unset timeouts use the defaults of the proxy.
+type Timeouts struct {
+	Connect  Duration `yaml:"Connect,omitempty" json:"Connect,omitempty" toml:"Connect,omitempty"`
+	Response Duration `yaml:"Response,omitempty" json:"Response,omitempty" toml:"Response,omitempty"`
+}
+
+// Retry resends idempotent requests up to Attempts times when an upstream
+// fails, waiting Backoff doubled after each attempt up to MaxBackoff.
+type Retry struct {
+	Attempts   int      `yaml:"Attempts,omitempty" json:"Attempts,omitempty" toml:"Attempts,omitempty"`
+	Backoff    Duration `yaml:"Backoff,omitempty" json:"Backoff,omitempty" toml:"Backoff,omitempty"`
+	MaxBackoff Duration `yaml:"MaxBackoff,omitempty" json:"MaxBackoff,omitempty" toml:"MaxBackoff,omitempty"`
+}
+
+// CircuitBreaker opens the circuit of an upstream after FailureThreshold
+// consecutive failures. After OpenDuration up to HalfOpenRequests trial
+// requests are let through, the circuit closes when they all succeed.
+type CircuitBreaker struct {
+	FailureThreshold int      `yaml:"FailureThreshold,omitempty" json:"FailureThreshold,omitempty" toml:"FailureThreshold,omitempty"`
+	OpenDuration     Duration `yaml:"OpenDuration,omitempty" json:"OpenDuration,omitempty" toml:"OpenDuration,omitempty"`
+	HalfOpenRequests int      `yaml:"HalfOpenRequests,omitempty" json:"HalfOpenRequests,omitempty" toml:"HalfOpenRequests,omitempty"`
+}
+
+// RateLimit lets each client send Requests per Period to the backend, with
+// bursts of up to Burst requests. Key identifies the clients, see
+// RateLimitKeys, or is header:<name> for the value of a request header.
+type RateLimit struct {
+	Key      string   `yaml:"Key,omitempty" json:"Key,omitempty" toml:"Key,omitempty"`
+	Requests int      `yaml:"Requests,omitempty" json:"Requests,omitempty" toml:"Requests,omitempty"`
+	Period   Duration `yaml:"Period,omitempty" json:"Period,omitempty" toml:"Period,omitempty"`
+	Burst    int      `yaml:"Burst,omitempty" json:"Burst,omitempty" toml:"Burst,omitempty"`
+}
+
+// ProxyAuth selects how the access token of the signed in user is forwarded
+// to a backend, see TokenForwardModes. The exchange mode trades it at the
+// provider for a token issued to Audience with Scopes.
+type ProxyAuth struct {
+	Forward  string   `yaml:"Forward,omitempty" json:"Forward,omitempty" toml:"Forward,omitempty"`
+	Audience string   `yaml:"Audience,omitempty" json:"Audience,omitempty" toml:"Audience,omitempty"`
+	Scopes   []string `yaml:"Scopes,omitempty" json:"Scopes,omitempty" toml:"Scopes,omitempty"`
+}
+
+// Route forwards the API requests under Prefix to the backend of its proxy.
+type Route struct {
+	Prefix string `yaml:"Prefix,omitempty" json:"Prefix,omitempty" toml:"Prefix,omitempty"`
+	// StripPrefix removes Prefix from the forwarded path, ReplacePrefix
+	// substitutes it and implies StripPrefix.
+	StripPrefix   bool   `yaml:"StripPrefix,omitempty" json:"StripPrefix,omitempty" toml:"StripPrefix,omitempty"`
+	ReplacePrefix string `yaml:"ReplacePrefix,omitempty" json:"ReplacePrefix,omitempty" toml:"ReplacePrefix,omitempty"`
+	// Methods allowed through the route, all when empty.
+	Methods []string `yaml:"Methods,omitempty" json:"Methods,omitempty" toml:"Methods,omitempty"`
+	// SetHeaders are added to the forwarded request, replacing the values sent
+	// by the client, after RemoveHeaders are removed.
+	SetHeaders    map[string]string `yaml:"SetHeaders,omitempty" json:"SetHeaders,omitempty" toml:"SetHeaders,omitempty"`
+	RemoveHeaders []string          `yaml:"RemoveHeaders,omitempty" json:"RemoveHeaders,omitempty" toml:"RemoveHeaders,omitempty"`
+	// Host overrides the Host header, the host of the backend address is sent otherwise.
+	Host string `yaml:"Host,omitempty" json:"Host,omitempty" toml:"Host,omitempty"`
+	// Access restricts the requests of the route further than the access of its proxy.
+	Access *Access `yaml:"Access,omitempty" json:"Access,omitempty" toml:"Access,omitempty"`
 }
@@ -0,0 +1,166 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+)
+
+// NavigationNode is an item of the navigation tree merged from all deployments.
+type NavigationNode struct {
+	Id       string              `json:"id,omitempty"`
+	Endpoint string              `json:"endpoint,omitempty"`
+	Title    string              `json:"title"`
+	Titles   map[string]string   `json:"titles,omitempty"`
+	Icon     string              `json:"icon,omitempty"`
+	Order    int                 `json:"order"`
+	Hidden   bool                `json:"hidden,omitempty"`
+	Roles    []string            `json:"roles,omitempty"`
+	Claims   map[string][]string `json:"claims,omitempty"`
+	// Deployments are the endpoints of the deployments declaring the item.
+	Deployments []string          `json:"deployments"`
+	Children    []*NavigationNode `json:"children,omitempty"`
+	// unrestricted is set once a declaration of the item allows everyone,
+	// the roles and claims of other declarations do not apply then.
+	unrestricted bool
+}
+
+// Access returns the access of the item.
+func (n *NavigationNode) Access() Access {
+	return Access{Roles: n.Roles, Claims: n.Claims}
+}
+
+// NavigationConflict is a navigation endpoint declared by more than one deployment.
+type NavigationConflict struct {
+	Endpoint   string `json:"endpoint"`
+	Deployment string `json:"deployment"`
+	DeclaredBy string `json:"declaredBy"`
+}
+
+func (c NavigationConflict) Error() string {
+	return fmt.Sprintf("navigation endpoint %s of %s is already declared by %s", c.Endpoint, c.Deployment, c.DeclaredBy)
+}
+
+// MergeNavigations merges the navigations of each deployment, keyed by its
+// endpoint, into one tree. Groups of the same Id at the same level are merged,
+// the first deployment declaring an endpoint wins and later ones are reported
+// as conflicts. Deployments are merged in endpoint order so the result does
+// not depend on map iteration.
+func MergeNavigations(sources map[string][]Navigation) ([]*NavigationNode, []NavigationConflict) {
+	deployments := make([]string, 0, len(sources))
+	for d := range sources {
+		deployments = append(deployments, d)
+	}
+	sort.Strings(deployments)
+	m := navigationMerger{owners: make(map[string]string)}
+	var root []*NavigationNode
+	for _, d := range deployments {
+		root = m.merge(root, sources[d], d)
+	}
+	sortNavigation(root)
+	return root, m.conflicts
+}
+
+type navigationMerger struct {
+	// owners maps each navigation endpoint to the deployment declaring it.
+	owners    map[string]string
+	conflicts []NavigationConflict
+}
+
+func (m *navigationMerger) merge(level []*NavigationNode, items []Navigation, deployment string) []*NavigationNode {
+	for _, item := range items {
+		if item.Endpoint != "" {
+			if owner, ok := m.owners[item.Endpoint]; ok && owner != deployment {
+				m.conflicts = append(m.conflicts, NavigationConflict{
+					Endpoint:   item.Endpoint,
+					Deployment: deployment,
+					DeclaredBy: owner,
+				})
+				continue
+			}
+			m.owners[item.Endpoint] = deployment
+		}
+		var node *NavigationNode
+		if item.Id != "" {
+			for _, n := range level {
+				if n.Id == item.Id {
+					node = n
+					break
+				}
+			}
+		}
+		if node == nil {
+			node = &NavigationNode{Id: item.Id, Order: item.Order, Hidden: item.Hidden}
+			level = append(level, node)
+		}
+		node.fill(item, deployment)
+		node.Children = m.merge(node.Children, item.Children, deployment)
+	}
+	return level
+}
+
+// fill copies the attributes of item the node does not have yet.
+func (n *NavigationNode) fill(item Navigation, deployment string) {
+	if n.Endpoint == "" {
+		n.Endpoint = item.Endpoint
+	}
+	if n.Title == "" {
+		n.Title = item.Title
+	}
+	if n.Icon == "" {
+		n.Icon = item.Icon
+	}
+	for locale, title := range item.Titles {
+		if n.Titles == nil {
+			n.Titles = make(map[string]string)
+		}
+		if _, ok := n.Titles[locale]; !ok {
+			n.Titles[locale] = title
+		}
+	}
+	switch {
+	case n.unrestricted:
+	case len(item.Roles) == 0 && len(item.Claims) == 0:
+		n.Roles, n.Claims, n.unrestricted = nil, nil, true
+	default:
+		// declarations of a group each grant access
+		for _, role := range item.Roles {
+			if !contains(n.Roles, role) {
+				n.Roles = append(n.Roles, role)
+			}
+		}
+		for name, values := range item.Claims {
+			if n.Claims == nil {
+				n.Claims = make(map[string][]string)
+			}
+			for _, value := range values {
+				if !contains(n.Claims[name], value) {
+					n.Claims[name] = append(n.Claims[name], value)
+				}
+			}
+		}
+	}
+	if !contains(n.Deployments, deployment) {
+		n.Deployments = append(n.Deployments, deployment)
+	}
+}
+
+func sortNavigation(level []*NavigationNode) {
+	sort.SliceStable(level, func(i, j int) bool {
+		if level[i].Order != level[j].Order {
+			return level[i].Order < level[j].Order
+		}
+		return level[i].Title < level[j].Title
+	})
+	for _, n := range level {
+		sortNavigation(n.Children)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package core
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMergeNavigations(t *testing.T) {
+	sources := map[string][]Navigation{
+		"orders": {
+			{Id: "sales", Title: "Sales", Order: 2, Children: []Navigation{
+				{Endpoint: "/orders", Title: "Orders", Order: 2},
+			}},
+			{Endpoint: "/home", Title: "Orders home"},
+		},
+		"invoices": {
+			{Id: "sales", Title: "Sales", Icon: "cart", Order: 2, Roles: []string{"sales"}, Children: []Navigation{
+				{Endpoint: "/invoices", Title: "Invoices", Order: 1},
+			}},
+			{Endpoint: "/home", Title: "Home", Order: 1},
+		},
+	}
+	tree, conflicts := MergeNavigations(sources)
+	if len(tree) != 2 || tree[0].Endpoint != "/home" || tree[1].Id != "sales" {
+		t.Logf("expected /home then sales at top level, actual = %v", tree)
+		t.FailNow()
+	}
+	sales := tree[1]
+	if len(sales.Children) != 2 || sales.Children[0].Endpoint != "/invoices" || sales.Icon != "cart" || len(sales.Deployments) != 2 {
+		t.Logf("expected sales group to be merged, actual = %+v", sales)
+		t.FailNow()
+	}
+	if access := sales.Access(); !access.IsEmpty() {
+		t.Logf("expected sales group to be unrestricted as orders declares it without roles, actual = %+v", access)
+		t.FailNow()
+	}
+	if len(conflicts) != 1 || conflicts[0].Endpoint != "/home" || conflicts[0].Deployment != "orders" || conflicts[0].DeclaredBy != "invoices" {
+		t.Logf("expected /home of orders to conflict with invoices, actual = %v", conflicts)
+		t.FailNow()
+	}
+}
+
+func TestValidateNavigations(t *testing.T) {
+	b := []byte(`Kind: container
+Endpoint: portal
+Navigations:
+  - Title: Sales
+    Children:
+      - Endpoint: /orders
+        Title: Orders
+      - Endpoint: orders
+  - Endpoint: /orders
+    Title: Orders
+`)
+	_, err := ParseDeployment(b, true)
+	var errs ValidationErrors
+	if !errors.As(err, &errs) || len(errs) != 4 {
+		t.Logf("expected 4 errors, actual = %v", err)
+		t.FailNow()
+	}
+	expected := []string{
+		"Navigations[0].Id",
+		"Navigations[0].Children[1].Title",
+		"Navigations[0].Children[1].Endpoint",
+		"Navigations[1].Endpoint",
+	}
+	for i, field := range expected {
+		if errs[i].Field != field {
+			t.Logf("expected error of %s, actual = %v", field, errs[i])
+			t.FailNow()
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package core
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// MergeKeys names, for each list field, the fields identifying its items when
+// an overlay is merged, the first one set is used. Lists not listed here are
+// replaced by the overlay.
+var MergeKeys = map[string][]string{
+	"Proxies":     {"BackendCode"},
+	"Navigations": {"Id", "Endpoint"},
+	"Children":    {"Id", "Endpoint"},
+	"Routes":      {"Prefix"},
+	"RateLimits":  {"Key"},
+}
+
+// MergeDocuments merges overlay documents into the base documents having the
+// same Endpoint. Overlay documents without a base are added as new documents.
+// Endpoints are compared as they are, so documents are interpolated first.
+//
+// Within a document mappings are merged key by key, scalars and lists not in
+// MergeKeys are replaced, and items of lists in MergeKeys are merged with the
+// base item of the same key or appended when there is none.
+func MergeDocuments(base []*yaml.Node, overlay []*yaml.Node) []*yaml.Node {
+	for _, o := range overlay {
+		endpoint := NormalizeEndpoint(scalarOf(o.Content[0], "Endpoint"))
+		merged := false
+		for _, b := range base {
+			if NormalizeEndpoint(scalarOf(b.Content[0], "Endpoint")) == endpoint {
+				b.Content[0] = mergeNode(b.Content[0], o.Content[0], "")
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			base = append(base, o)
+		}
+	}
+	return base
+}
+
+func mergeNode(base *yaml.Node, overlay *yaml.Node, field string) *yaml.Node {
+	switch {
+	case base.Kind == yaml.MappingNode && overlay.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(overlay.Content); i += 2 {
+			key, value := overlay.Content[i], overlay.Content[i+1]
+			j := indexOf(base, key.Value)
+			if j < 0 {
+				base.Content = append(base.Content, key, value)
+				continue
+			}
+			base.Content[j+1] = mergeNode(base.Content[j+1], value, key.Value)
+		}
+		return base
+	case base.Kind == yaml.SequenceNode && overlay.Kind == yaml.SequenceNode && len(MergeKeys[field]) > 0:
+		keys := MergeKeys[field]
+		for _, item := range overlay.Content {
+			id := itemKey(item, keys)
+			merged := false
+			for i, b := range base.Content {
+				if id != "" && itemKey(b, keys) == id {
+					base.Content[i] = mergeNode(b, item, "")
+					merged = true
+					break
+				}
+			}
+			if !merged {
+				base.Content = append(base.Content, item)
+			}
+		}
+		return base
+	default:
+		return overlay
+	}
+}
+
+// itemKey identifies a list item by the first of keys it has a value for.
+func itemKey(item *yaml.Node, keys []string) string {
+	for _, key := range keys {
+		if v := scalarOf(item, key); v != "" {
+			return key + "=" + v
+		}
+	}
+	return ""
+}
+
+// indexOf returns the position of key in a mapping node, or -1.
+func indexOf(m *yaml.Node, key string) int {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func scalarOf(m *yaml.Node, key string) string {
+	if m.Kind != yaml.MappingNode {
+		return ""
+	}
+	i := indexOf(m, key)
+	if i < 0 || m.Content[i+1].Kind != yaml.ScalarNode {
+		return ""
+	}
+	return m.Content[i+1].Value
+}
+
+var variablePattern = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+
+// Interpolate replaces ${VAR} and ${VAR:-default} in the scalar values of
+// node. The default applies when VAR is unset or empty, and $${ produces a
+// literal ${. Variables without value or default are reported as errors.
+func Interpolate(node *yaml.Node, env func(name string) (string, bool)) ValidationErrors {
+	return interpolate(node, env, nil)
+}
+
+// interpolate is Interpolate reporting errors in the file of files the node comes from.
+func interpolate(node *yaml.Node, env func(name string) (string, bool), files map[*yaml.Node]string) ValidationErrors {
+	var errs ValidationErrors
+	var walk func(n *yaml.Node)
+	walk = func(n *yaml.Node) {
+		switch n.Kind {
+		case yaml.DocumentNode, yaml.SequenceNode:
+			for _, c := range n.Content {
+				walk(c)
+			}
+		case yaml.MappingNode:
+			for i := 1; i < len(n.Content); i += 2 {
+				walk(n.Content[i])
+			}
+		case yaml.ScalarNode:
+			if !strings.Contains(n.Value, "${") {
+				return
+			}
+			n.Value = variablePattern.ReplaceAllStringFunc(n.Value, func(m string) string {
+				if m == "$${" {
+					return "${"
+				}
+				sub := variablePattern.FindStringSubmatch(m)
+				if v, ok := env(sub[1]); ok && v != "" {
+					return v
+				}
+				if strings.Contains(m, ":-") {
+					return sub[2]
+				}
+				errs = append(errs, ValidationError{
+					File:    files[n],
+					Line:    n.Line,
+					Column:  n.Column,
+					Message: fmt.Sprintf("variable %s is not set", sub[1]),
+				})
+				return ""
+			})
+			if n.Style == 0 {
+				// let the decoder resolve the type of the interpolated value
+				n.Tag = ""
+			}
+		}
+	}
+	walk(node)
+	return errs
+}
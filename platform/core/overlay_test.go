@@ -0,0 +1,109 @@
+package core
+
+import "testing"
+
+func TestParseDeploymentsWithOverlay(t *testing.T) {
+	base := []byte(`Kind: container
+Endpoint: portal
+Proxies:
+  - BackendCode: orders
+    BackendAddress: ${ORDERS_HOST:-localhost}:8080
+    Secure: ${SECURE:-true}
+  - BackendCode: billing
+    BackendAddress: billing
+Navigations:
+  - Endpoint: /home
+    Title: Home
+`)
+	overlay := []byte(`Endpoint: portal
+Proxies:
+  - BackendCode: orders
+    Secure: false
+  - BackendCode: audit
+    BackendAddress: audit
+Navigations:
+  - Endpoint: /home
+    Title: Start
+`)
+	env := map[string]string{"ORDERS_HOST": "orders.prod"}
+	ds, err := ParseDeploymentsWith(base, ParseOptions{
+		Env: func(name string) (string, bool) {
+			v, ok := env[name]
+			return v, ok
+		},
+		Overlays: []Overlay{{File: "deployment.prod.yaml", Content: overlay}},
+	})
+	if err != nil || len(ds) != 1 {
+		t.Logf("failed to ParseDeploymentsWith(base, opts): %v", err)
+		t.FailNow()
+	}
+	d := ds[0]
+	if len(d.Proxies) != 3 {
+		t.Logf("expected 3 proxies, actual = %v", d.Proxies)
+		t.FailNow()
+	}
+	if d.Proxies[0].BackendAddress != "orders.prod:8080" || d.Proxies[0].Secure {
+		t.Logf("orders proxy should be interpolated and overridden, actual = %v", d.Proxies[0])
+		t.FailNow()
+	}
+	if d.Proxies[2].BackendCode != "audit" {
+		t.Logf("audit proxy should be appended, actual = %v", d.Proxies[2])
+		t.FailNow()
+	}
+	if len(d.Navigations) != 1 || d.Navigations[0].Title != "Start" {
+		t.Logf("navigation should be merged by endpoint, actual = %v", d.Navigations)
+		t.FailNow()
+	}
+}
+
+func TestOverlayErrorFile(t *testing.T) {
+	base := []byte(`Kind: container
+Endpoint: portal
+Proxies:
+  - BackendCode: orders
+    BackendAddress: orders:8080
+`)
+	overlay := []byte(`Endpoint: portal
+Proxies:
+  - BackendCode: orders
+    BackendAddress: ${ORDERS_HOST}
+  - BackendCode: ""
+`)
+	_, err := ParseDeploymentsWith(base, ParseOptions{
+		Env:      func(name string) (string, bool) { return "", false },
+		Overlays: []Overlay{{File: "deployment.prod.yaml", Content: overlay}},
+	})
+	errs, ok := err.(ValidationErrors)
+	if !ok || len(errs) == 0 {
+		t.Logf("expected validation errors, actual = %v", err)
+		t.FailNow()
+	}
+	for _, e := range errs {
+		if e.File != "deployment.prod.yaml" || e.Line < 4 {
+			t.Logf("expected errors to point into the overlay, actual = %v", errs)
+			t.FailNow()
+		}
+	}
+}
+
+func TestOverlayInterpolatedEndpoint(t *testing.T) {
+	base := []byte(`Kind: container
+Endpoint: ${APP}
+Version: v1
+`)
+	overlay := []byte(`Endpoint: portal
+Version: v2
+`)
+	ds, err := ParseDeploymentsWith(base, ParseOptions{
+		Env:      func(name string) (string, bool) { return "portal", name == "APP" },
+		Overlays: []Overlay{{File: "deployment.prod.yaml", Content: overlay}},
+	})
+	if err != nil || len(ds) != 1 {
+		t.Logf("expected the overlay to merge into the interpolated base, actual = %v %v", ds, err)
+		t.FailNow()
+	}
+	if ds[0].Endpoint != "portal" || ds[0].Version != "v2" {
+		t.Logf("expected portal v2, actual = %s %s", ds[0].Endpoint, ds[0].Version)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,84 @@
+package core
+
+import (
+	"net/http"
+	"strings"
+)
+
+// DefaultRoute is the route of a proxy that declares none.
+func DefaultRoute(backendCode string) Route {
+	return Route{Prefix: "/api/" + backendCode, StripPrefix: true}
+}
+
+// RoutesOf returns the routes of p, or its default route.
+func RoutesOf(p Proxy) []Route {
+	if len(p.Routes) == 0 {
+		return []Route{DefaultRoute(p.BackendCode)}
+	}
+	return p.Routes
+}
+
+// Match reports whether path is Prefix or below it, matching whole segments.
+func (r Route) Match(path string) bool {
+	prefix := strings.TrimSuffix(r.Prefix, "/")
+	return path == prefix || strings.HasPrefix(path, prefix+"/") || prefix == ""
+}
+
+// Overlaps reports whether r and o both match some path, e.g. because one
+// prefix lies below the other.
+func (r Route) Overlaps(o Route) bool {
+	return r.Match(strings.TrimSuffix(o.Prefix, "/")) || o.Match(strings.TrimSuffix(r.Prefix, "/"))
+}
+
+// Allows reports whether method may be sent through the route.
+func (r Route) Allows(method string) bool {
+	if len(r.Methods) == 0 {
+		return true
+	}
+	for _, m := range r.Methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// Rewrite returns the path forwarded to the backend for path, which the
+// route matches.
+func (r Route) Rewrite(path string) string {
+	if !r.StripPrefix && r.ReplacePrefix == "" {
+		return path
+	}
+	rest := strings.TrimPrefix(path, strings.TrimSuffix(r.Prefix, "/"))
+	rs := strings.TrimSuffix(r.ReplacePrefix, "/") + rest
+	if !strings.HasPrefix(rs, "/") {
+		rs = "/" + rs
+	}
+	return rs
+}
+
+var knownMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodOptions: true,
+}
+
+// isToken reports whether s is a valid HTTP header name.
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.ContainsRune("!#$%&'*+-.^_`|~", c):
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,48 @@
+package core
+
+import "testing"
+
+func TestRouteRewrite(t *testing.T) {
+	cases := []struct {
+		route    Route
+		path     string
+		match    bool
+		expected string
+	}{
+		{Route{Prefix: "/api/orders", ReplacePrefix: "/v1"}, "/api/orders/42", true, "/v1/42"},
+		{Route{Prefix: "/api/orders/", StripPrefix: true}, "/api/orders", true, "/"},
+		{Route{Prefix: "/api/orders"}, "/api/orders/42", true, "/api/orders/42"},
+		{Route{Prefix: "/api/orders"}, "/api/ordersx", false, ""},
+		{DefaultRoute("billing"), "/api/billing/invoices", true, "/invoices"},
+	}
+	for _, c := range cases {
+		if c.route.Match(c.path) != c.match {
+			t.Logf("expected %s to match %s: %t", c.route.Prefix, c.path, c.match)
+			t.FailNow()
+		}
+		if c.match && c.route.Rewrite(c.path) != c.expected {
+			t.Logf("expected %s to be rewritten to %s, actual = %s", c.path, c.expected, c.route.Rewrite(c.path))
+			t.FailNow()
+		}
+	}
+}
+
+func TestRouteOverlaps(t *testing.T) {
+	cases := []struct {
+		a, b     string
+		overlaps bool
+	}{
+		{"/api/orders", "/api/orders/", true},
+		{"/api/orders", "/api/orders/v2", true},
+		{"/api/orders/v2", "/api", true},
+		{"/api/orders", "/api/ordersx", false},
+		{"/api/orders", "/api/billing", false},
+	}
+	for _, c := range cases {
+		a, b := Route{Prefix: c.a}, Route{Prefix: c.b}
+		if a.Overlaps(b) != c.overlaps || b.Overlaps(a) != c.overlaps {
+			t.Logf("expected %s and %s to overlap: %t", c.a, c.b, c.overlaps)
+			t.FailNow()
+		}
+	}
+}
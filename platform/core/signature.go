@@ -0,0 +1,146 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Manifest is the signed content of a deployment: the descriptor as sent on
+// the wire plus the sha256 digests of its assets keyed by path.
+type Manifest struct {
+	Deployment []byte
+	Assets     map[string]string
+}
+
+// Digest returns a hash of the manifest that does not depend on map ordering.
+func (m Manifest) Digest() []byte {
+	h := sha256.New()
+	h.Write(binary.BigEndian.AppendUint64(nil, uint64(len(m.Deployment))))
+	h.Write(m.Deployment)
+	paths := make([]string, 0, len(m.Assets))
+	for p := range m.Assets {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+		h.Write([]byte(m.Assets[p]))
+		h.Write([]byte{'\n'})
+	}
+	return h.Sum(nil)
+}
+
+func Sign(key ed25519.PrivateKey, m Manifest) []byte {
+	return ed25519.Sign(key, m.Digest())
+}
+
+// KeyId identifies a public key without sending it, so the server can pick
+// the trusted key to verify with.
+func KeyId(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+type TrustedKey struct {
+	Name string
+	Key  ed25519.PublicKey
+}
+
+// TrustedKeys holds the public keys deployments may be signed with, by key id.
+type TrustedKeys map[string]TrustedKey
+
+// Verify checks sig against the trusted key keyId and returns the name of the signer.
+func (t TrustedKeys) Verify(m Manifest, keyId string, sig []byte) (string, error) {
+	trusted, ok := t[keyId]
+	if !ok {
+		return "", fmt.Errorf("deployment is signed by untrusted key %s", keyId)
+	}
+	if !ed25519.Verify(trusted.Key, m.Digest(), sig) {
+		return "", fmt.Errorf("signature of deployment is not valid")
+	}
+	return trusted.Name, nil
+}
+
+// LoadTrustedKeys reads every PEM encoded public key (*.pub, *.pem) in dir.
+// The file name without extension is used as the signer identity.
+func LoadTrustedKeys(dir string) (TrustedKeys, error) {
+	keys := make(TrustedKeys)
+	if strings.TrimSpace(dir) == "" {
+		return keys, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		ext := filepath.Ext(e.Name())
+		if e.IsDir() || (ext != ".pub" && ext != ".pem") {
+			continue
+		}
+		key, err := LoadPublicKey(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		keys[KeyId(key)] = TrustedKey{
+			Name: strings.TrimSuffix(e.Name(), ext),
+			Key:  key,
+		}
+	}
+	return keys, nil
+}
+
+func LoadPublicKey(f string) (ed25519.PublicKey, error) {
+	der, err := readPem(f)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an ed25519 public key", f)
+	}
+	return pub, nil
+}
+
+// LoadPrivateKey reads a PKCS#8 PEM encoded key, as generated by
+// `openssl genpkey -algorithm ed25519`.
+func LoadPrivateKey(f string) (ed25519.PrivateKey, error) {
+	der, err := readPem(f)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an ed25519 private key", f)
+	}
+	return priv, nil
+}
+
+func readPem(f string) ([]byte, error) {
+	b, err := os.ReadFile(f)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("%s does not contain a PEM block", f)
+	}
+	return block.Bytes, nil
+}
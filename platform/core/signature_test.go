@@ -0,0 +1,48 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	keys := TrustedKeys{KeyId(pub): TrustedKey{Name: "alice", Key: pub}}
+	m := Manifest{
+		Deployment: []byte("Endpoint: shop"),
+		Assets:     map[string]string{"main.js": "abc", "main.css": "def"},
+	}
+	sig := Sign(priv, m)
+	signer, err := keys.Verify(m, KeyId(pub), sig)
+	if err != nil || signer != "alice" {
+		t.Logf("signature should be valid, signer = %v, err = %v", signer, err)
+		t.FailNow()
+	}
+	m.Assets["main.js"] = "tampered"
+	if _, err := keys.Verify(m, KeyId(pub), sig); err == nil {
+		t.Logf("signature of tampered manifest should be rejected")
+		t.FailNow()
+	}
+	other, _, _ := ed25519.GenerateKey(nil)
+	if _, err := keys.Verify(m, KeyId(other), sig); err == nil {
+		t.Logf("signature of untrusted key should be rejected")
+		t.FailNow()
+	}
+}
+
+func TestIntegrity(t *testing.T) {
+	if v := Integrity(nil); v != "sha384-OLBgp1GsljhM2TJ+sbHjaiH9txEUvgdDTAzHv2P24donTt6/529l+9Ua0vFImLlb" {
+		t.Logf("expected sha384 integrity of empty content, actual = %s", v)
+		t.FailNow()
+	}
+	for _, p := range []string{"../index.js", "js/../../x.js", "", AssetManifestPath} {
+		if _, err := CleanAssetPath(p); err == nil {
+			t.Logf("expected %q to be rejected", p)
+			t.FailNow()
+		}
+	}
+	if p, err := CleanAssetPath("/js/index.js"); err != nil || p != "js/index.js" {
+		t.Logf("expected js/index.js, actual = %s %v", p, err)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,112 @@
+package core
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	StrategyRoundRobin       = "round-robin"
+	StrategyLeastConnections = "least-connections"
+	StrategyConsistentHash   = "consistent-hash"
+)
+
+var LoadBalancingStrategies = []string{StrategyRoundRobin, StrategyLeastConnections, StrategyConsistentHash}
+
+// MaxRetryAttempts bounds Retry.Attempts of a proxy.
+const MaxRetryAttempts = 5
+
+// Duration is a time.Duration written as text, e.g. 1m30s, in descriptors.
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(b []byte) error {
+	v, err := time.ParseDuration(strings.TrimSpace(string(b)))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Or returns d, or def when d is not set.
+func (d Duration) Or(def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return time.Duration(d)
+}
+
+// Targets returns the addresses requests of p are balanced across.
+func Targets(p Proxy) []string {
+	rs := make([]string, 0, len(p.Upstreams)+1)
+	if strings.TrimSpace(p.BackendAddress) != "" {
+		rs = append(rs, p.BackendAddress)
+	}
+	return append(rs, p.Upstreams...)
+}
+
+// StrategyOf returns the load balancing strategy of p.
+func StrategyOf(p Proxy) string {
+	if p.LoadBalancer == nil || p.LoadBalancer.Strategy == "" {
+		return StrategyRoundRobin
+	}
+	return strings.ToLower(p.LoadBalancer.Strategy)
+}
+
+const (
+	// RateLimitByIp limits each client address, it is the default key.
+	RateLimitByIp = "ip"
+	// RateLimitByUser limits each authenticated user, anonymous requests are
+	// limited by address.
+	RateLimitByUser = "user"
+	// RateLimitByBackend shares one limit between all clients.
+	RateLimitByBackend = "backend"
+	// RateLimitHeaderPrefix prefixes the name of the header keying a limit.
+	RateLimitHeaderPrefix = "header:"
+)
+
+var RateLimitKeys = []string{RateLimitByIp, RateLimitByUser, RateLimitByBackend, RateLimitHeaderPrefix + "<name>"}
+
+// KeyOf returns the key of l, RateLimitByIp when not set.
+func (l RateLimit) KeyOf() string {
+	if strings.TrimSpace(l.Key) == "" {
+		return RateLimitByIp
+	}
+	return strings.TrimSpace(l.Key)
+}
+
+// PeriodOf returns the period of l, a second when not set.
+func (l RateLimit) PeriodOf() time.Duration {
+	return l.Period.Or(time.Second)
+}
+
+// BurstOf returns the burst of l, Requests when not set.
+func (l RateLimit) BurstOf() int {
+	if l.Burst <= 0 {
+		return l.Requests
+	}
+	return l.Burst
+}
+
+const (
+	// ForwardAccessToken sends the access token of the user, it is the default.
+	ForwardAccessToken = "access-token"
+	// ForwardExchange sends a token exchanged for the access token of the user.
+	ForwardExchange = "exchange"
+	// ForwardNone sends no token.
+	ForwardNone = "none"
+)
+
+var TokenForwardModes = []string{ForwardAccessToken, ForwardExchange, ForwardNone}
+
+// ForwardOf returns how p forwards tokens, ForwardAccessToken when not set.
+func ForwardOf(p Proxy) string {
+	if p.Auth == nil || strings.TrimSpace(p.Auth.Forward) == "" {
+		return ForwardAccessToken
+	}
+	return strings.ToLower(strings.TrimSpace(p.Auth.Forward))
+}
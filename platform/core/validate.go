@@ -0,0 +1,738 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ValidationError is a problem found in a deployment descriptor, Line and
+// Column point to the offending node and are zero when unknown. File names
+// the overlay the node comes from, it is empty for the base descriptor.
+type ValidationError struct {
+	File    string
+	Line    int
+	Column  int
+	Field   string
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	msg := e.Message
+	if e.Field != "" {
+		msg = e.Field + ": " + msg
+	}
+	if e.Line > 0 {
+		msg = fmt.Sprintf("%d:%d: %s", e.Line, e.Column, msg)
+	}
+	if e.File != "" {
+		return e.File + ":" + msg
+	}
+	return msg
+}
+
+type ValidationErrors []ValidationError
+
+func (errs ValidationErrors) Error() string {
+	msgs := make([]string, 0, len(errs))
+	for _, e := range errs {
+		msgs = append(msgs, e.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+var (
+	endpointPattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+	typeErrorLine   = regexp.MustCompile(`^line (\d+): `)
+	// reservedEndpoints are the first path segments routed by the platform itself.
+	reservedEndpoints = map[string]bool{
+		"api":             true,
+		"cdn":             true,
+		"resource":        true,
+		"navigation.json": true,
+		"auth":            true,
+		"csp-report":      true,
+	}
+)
+
+// ParseDeployment decodes a YAML deployment descriptor and validates it.
+// In strict mode keys that do not map to a field are rejected. All problems
+// are reported at once as ValidationErrors.
+func ParseDeployment(b []byte, strict bool) (DeploymentRequest, error) {
+	return ParseDeploymentAs(b, FormatYaml, strict)
+}
+
+// ParseDeploymentAs is like ParseDeployment for a descriptor in format f.
+func ParseDeploymentAs(b []byte, f Format, strict bool) (DeploymentRequest, error) {
+	ds, err := ParseDeploymentsWith(b, ParseOptions{Strict: strict, Format: f})
+	if err != nil {
+		return DeploymentRequest{}, err
+	}
+	if len(ds) != 1 {
+		return DeploymentRequest{}, fmt.Errorf("expected one deployment, found %d", len(ds))
+	}
+	return ds[0], nil
+}
+
+// ParseDeployments is like ParseDeployment for a stream of YAML documents
+// separated by ---. Empty documents are skipped.
+func ParseDeployments(b []byte, strict bool) ([]DeploymentRequest, error) {
+	return ParseDeploymentsWith(b, ParseOptions{Strict: strict})
+}
+
+type ParseOptions struct {
+	// Strict rejects keys that do not map to a field.
+	Strict bool
+	// Env resolves ${VAR} references, interpolation is disabled when nil.
+	Env func(name string) (string, bool)
+	// Overlays are merged in order over the base documents, see MergeDocuments.
+	// They are expected in the same format as the base.
+	Overlays []Overlay
+	// Format of the descriptors, YAML when empty.
+	Format Format
+}
+
+// Overlay is a descriptor merged over the base ones, File names it in errors.
+type Overlay struct {
+	File    string
+	Content []byte
+}
+
+func ParseDeploymentsWith(b []byte, opts ParseOptions) ([]DeploymentRequest, error) {
+	if opts.Format == "" {
+		opts.Format = FormatYaml
+	}
+	docs, err := decodeDocuments(b, opts.Format)
+	if err != nil {
+		return nil, err
+	}
+	// files keeps the overlay each merged node comes from
+	files := make(map[*yaml.Node]string)
+	var errs ValidationErrors
+	// documents are interpolated before they are merged, so they are
+	// matched by their resolved Endpoint
+	interpolateAll := func(docs []*yaml.Node) {
+		if opts.Env != nil {
+			for _, doc := range docs {
+				errs = append(errs, interpolate(doc, opts.Env, files)...)
+			}
+		}
+	}
+	interpolateAll(docs)
+	for _, overlay := range opts.Overlays {
+		overlayDocs, err := decodeDocuments(overlay.Content, opts.Format)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", overlay.File, err)
+		}
+		for _, doc := range overlayDocs {
+			markFile(doc, overlay.File, files)
+		}
+		interpolateAll(overlayDocs)
+		docs = MergeDocuments(docs, overlayDocs)
+	}
+	rs := make([]DeploymentRequest, 0)
+	for _, doc := range docs {
+		d, err := parseDocument(doc, opts.Strict, files)
+		var docErrs ValidationErrors
+		if errors.As(err, &docErrs) {
+			errs = append(errs, docErrs...)
+		} else if err != nil {
+			return nil, err
+		}
+		rs = append(rs, d)
+	}
+	if len(errs) > 0 {
+		return rs, errs
+	}
+	return rs, nil
+}
+
+// decodeYamlDocuments returns the root nodes of the non-empty documents of b.
+func decodeYamlDocuments(b []byte) ([]*yaml.Node, error) {
+	docs := make([]*yaml.Node, 0)
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	for {
+		var root yaml.Node
+		err := dec.Decode(&root)
+		if errors.Is(err, io.EOF) {
+			return docs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(root.Content) == 0 || root.Content[0].Kind == yaml.ScalarNode && root.Content[0].Tag == "!!null" {
+			continue
+		}
+		docs = append(docs, &root)
+	}
+}
+
+// markFile records file as the origin of node and its descendants.
+func markFile(node *yaml.Node, file string, files map[*yaml.Node]string) {
+	files[node] = file
+	for _, c := range node.Content {
+		markFile(c, file, files)
+	}
+}
+
+func parseDocument(root *yaml.Node, strict bool, files map[*yaml.Node]string) (DeploymentRequest, error) {
+	var d DeploymentRequest
+	v := validator{nodes: make(map[string]*yaml.Node), files: files}
+	if len(root.Content) > 0 {
+		v.walk(root.Content[0], reflect.TypeOf(d), "", strict)
+		if err := root.Content[0].Decode(&d); err != nil {
+			v.decodeError(err)
+		}
+	}
+	v.validate(d)
+	if len(v.errs) > 0 {
+		sort.SliceStable(v.errs, func(i, j int) bool {
+			a, b := v.errs[i], v.errs[j]
+			if a.File != b.File {
+				return a.File < b.File
+			}
+			if a.Line != b.Line {
+				return b.Line == 0 || (a.Line != 0 && a.Line < b.Line)
+			}
+			return a.Column < b.Column
+		})
+		return d, v.errs
+	}
+	return d, nil
+}
+
+// ValidateDeployment checks a decoded deployment, positions are not available.
+func ValidateDeployment(d DeploymentRequest) error {
+	v := validator{nodes: make(map[string]*yaml.Node)}
+	v.validate(d)
+	if len(v.errs) > 0 {
+		return v.errs
+	}
+	return nil
+}
+
+type validator struct {
+	// nodes indexes the YAML nodes by field path, e.g. Proxies[0].BackendCode
+	nodes map[string]*yaml.Node
+	// files maps the nodes merged from overlays to their file
+	files map[*yaml.Node]string
+	errs  ValidationErrors
+}
+
+func (v *validator) walk(node *yaml.Node, t reflect.Type, path string, strict bool) {
+	v.nodes[path] = node
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case node.Kind == yaml.MappingNode && t.Kind() == reflect.Struct:
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			ft, ok := fields[key.Value]
+			if !ok {
+				if strict {
+					v.errs = append(v.errs, ValidationError{
+						File:    v.files[key],
+						Line:    key.Line,
+						Column:  key.Column,
+						Field:   join(path, key.Value),
+						Message: "unknown field",
+					})
+				}
+				continue
+			}
+			v.walk(value, ft, join(path, key.Value), strict)
+		}
+	case node.Kind == yaml.MappingNode && t.Kind() == reflect.Map:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			v.walk(node.Content[i+1], t.Elem(), join(path, node.Content[i].Value), strict)
+		}
+	case node.Kind == yaml.SequenceNode && t.Kind() == reflect.Slice:
+		for i, item := range node.Content {
+			v.walk(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i), strict)
+		}
+	}
+}
+
+// yamlFields maps the YAML keys of a struct to the types of their fields.
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func (v *validator) decodeError(err error) {
+	var typeErr *yaml.TypeError
+	if !errors.As(err, &typeErr) {
+		v.errs = append(v.errs, ValidationError{Message: err.Error()})
+		return
+	}
+	for _, msg := range typeErr.Errors {
+		e := ValidationError{Message: msg}
+		if m := typeErrorLine.FindStringSubmatch(msg); m != nil {
+			e.Line, _ = strconv.Atoi(m[1])
+			e.Message = strings.TrimPrefix(msg, m[0])
+			// the rightmost node on the line is the value that failed to decode
+			for path, node := range v.nodes {
+				if node.Line == e.Line && node.Column > e.Column {
+					e.File = v.files[node]
+					e.Column = node.Column
+					e.Field = path
+				}
+			}
+		}
+		v.errs = append(v.errs, e)
+	}
+}
+
+// errorf records a problem with the field at path, positioned at the closest
+// node found in the descriptor.
+func (v *validator) errorf(path string, format string, args ...any) {
+	e := ValidationError{
+		Field:   path,
+		Message: fmt.Sprintf(format, args...),
+	}
+	for p := path; ; {
+		if node, ok := v.nodes[p]; ok {
+			e.File, e.Line, e.Column = v.files[node], node.Line, node.Column
+			break
+		}
+		i := strings.LastIndexAny(p, ".[")
+		if i < 0 {
+			if node, ok := v.nodes[""]; ok {
+				e.File, e.Line, e.Column = v.files[node], node.Line, node.Column
+			}
+			break
+		}
+		p = p[:i]
+	}
+	v.errs = append(v.errs, e)
+}
+
+func (v *validator) validate(d DeploymentRequest) {
+	if strings.TrimSpace(string(d.Kind)) == "" {
+		v.errorf("Kind", "kind is required")
+	} else if spec, ok := LookupKind(string(d.Kind)); !ok {
+		v.errorf("Kind", "unknown kind %q, expected one of %s", d.Kind, strings.Join(KindNames(), ", "))
+	} else {
+		v.validateKind(spec, d)
+	}
+	endpoint := NormalizeEndpoint(d.Endpoint)
+	switch {
+	case endpoint == "":
+		// reported by the kind as a required field
+	case !endpointPattern.MatchString(endpoint):
+		v.errorf("Endpoint", "endpoint %q must be a single path segment of letters, digits, '.', '_' or '-'", d.Endpoint)
+	case reservedEndpoints[strings.ToLower(endpoint)]:
+		v.errorf("Endpoint", "endpoint %q is reserved by the platform", d.Endpoint)
+	}
+	if container := NormalizeEndpoint(d.Container); container != "" && !endpointPattern.MatchString(container) {
+		v.errorf("Container", "container %q must be a single path segment of letters, digits, '.', '_' or '-'", d.Container)
+	}
+	codes := make(map[string]int)
+	prefixes := make(map[string]string)
+	for i, p := range d.Proxies {
+		path := fmt.Sprintf("Proxies[%d]", i)
+		code := strings.TrimSpace(p.BackendCode)
+		if code == "" {
+			v.errorf(path+".BackendCode", "backend code is required")
+		} else if first, ok := codes[code]; ok {
+			v.errorf(path+".BackendCode", "duplicate backend code %q, first declared in Proxies[%d]", code, first)
+		} else {
+			codes[code] = i
+		}
+		if len(p.Upstreams) == 0 || strings.TrimSpace(p.BackendAddress) != "" {
+			if err := validateAddress(p.BackendAddress); err != nil {
+				v.errorf(path+".BackendAddress", "%v", err)
+			}
+		}
+		for j, u := range p.Upstreams {
+			if err := validateAddress(u); err != nil {
+				v.errorf(fmt.Sprintf("%s.Upstreams[%d]", path, j), "%v", err)
+			}
+		}
+		v.validateBalancing(path, p)
+		v.validateResilience(path, p)
+		v.validateRateLimits(path+".RateLimits", p.RateLimits)
+		if p.Access != nil {
+			v.validateAccess(path+".Access", p.Access.Roles, p.Access.Claims)
+		}
+		if a := p.Auth; a != nil {
+			switch mode := ForwardOf(p); {
+			case !contains(TokenForwardModes, mode):
+				v.errorf(path+".Auth.Forward", "unknown mode %q, expected one of %s", a.Forward, strings.Join(TokenForwardModes, ", "))
+			case mode == ForwardExchange && strings.TrimSpace(a.Audience) == "":
+				v.errorf(path+".Auth.Audience", "audience is required to exchange tokens")
+			case mode != ForwardExchange && (a.Audience != "" || len(a.Scopes) > 0):
+				v.errorf(path+".Auth", "audience and scopes are only used by the %s mode", ForwardExchange)
+			}
+		}
+		v.validateRoutes(path+".Routes", p.Routes, prefixes)
+	}
+	locales := make([]string, 0, len(d.Locales))
+	for i, locale := range d.Locales {
+		c, err := CanonicalLocale(locale)
+		if err != nil {
+			v.errorf(fmt.Sprintf("Locales[%d]", i), "invalid locale %q", locale)
+			continue
+		}
+		locales = append(locales, c)
+	}
+	if d.Access != nil {
+		v.validateAccess("Access", d.Access.Roles, d.Access.Claims)
+	}
+	v.validateCors("Cors", d.Cors)
+	v.validateNavigations("Navigations", d.Navigations, make(map[string]string), make(map[string]string))
+	v.validateTranslations("Navigations", d.Navigations, locales)
+}
+
+// validateBalancing checks the load balancing and health check settings of a proxy.
+func (v *validator) validateBalancing(path string, p Proxy) {
+	if lb := p.LoadBalancer; lb != nil {
+		strategy := StrategyOf(p)
+		if !contains(LoadBalancingStrategies, strategy) {
+			v.errorf(path+".LoadBalancer.Strategy", "unknown strategy %q, expected one of %s", lb.Strategy, strings.Join(LoadBalancingStrategies, ", "))
+		}
+		if lb.HashHeader != "" && !isToken(lb.HashHeader) {
+			v.errorf(path+".LoadBalancer.HashHeader", "invalid header name %q", lb.HashHeader)
+		}
+		if (lb.HashHeader != "" || lb.HashCookie != "") && strategy != StrategyConsistentHash {
+			v.errorf(path+".LoadBalancer", "hash key is only used by the %s strategy", StrategyConsistentHash)
+		}
+	}
+	if hc := p.HealthCheck; hc != nil {
+		if !strings.HasPrefix(hc.Path, "/") {
+			v.errorf(path+".HealthCheck.Path", "health check path %q must start with '/'", hc.Path)
+		}
+		if hc.Interval < 0 || hc.Timeout < 0 {
+			v.errorf(path+".HealthCheck", "interval and timeout must not be negative")
+		}
+		if hc.HealthyThreshold < 0 || hc.UnhealthyThreshold < 0 {
+			v.errorf(path+".HealthCheck", "thresholds must not be negative")
+		}
+	}
+	if e := p.Ejection; e != nil {
+		if e.MaxFailures <= 0 {
+			v.errorf(path+".Ejection.MaxFailures", "max failures must be positive")
+		}
+		if e.Duration < 0 {
+			v.errorf(path+".Ejection.Duration", "duration must not be negative")
+		}
+	}
+}
+
+// validateResilience checks the timeouts, retry and circuit breaker settings of a proxy.
+func (v *validator) validateResilience(path string, p Proxy) {
+	if t := p.Timeouts; t != nil && (t.Connect < 0 || t.Response < 0) {
+		v.errorf(path+".Timeouts", "timeouts must not be negative")
+	}
+	if r := p.Retry; r != nil {
+		if r.Attempts <= 0 || r.Attempts > MaxRetryAttempts {
+			v.errorf(path+".Retry.Attempts", "attempts must be between 1 and %d", MaxRetryAttempts)
+		}
+		if r.Backoff < 0 || r.MaxBackoff < 0 {
+			v.errorf(path+".Retry", "backoff must not be negative")
+		} else if r.MaxBackoff > 0 && r.MaxBackoff < r.Backoff {
+			v.errorf(path+".Retry.MaxBackoff", "max backoff %s is less than backoff %s", time.Duration(r.MaxBackoff), time.Duration(r.Backoff))
+		}
+	}
+	if cb := p.CircuitBreaker; cb != nil {
+		if cb.FailureThreshold <= 0 {
+			v.errorf(path+".CircuitBreaker.FailureThreshold", "failure threshold must be positive")
+		}
+		if cb.OpenDuration < 0 {
+			v.errorf(path+".CircuitBreaker.OpenDuration", "open duration must not be negative")
+		}
+		if cb.HalfOpenRequests < 0 {
+			v.errorf(path+".CircuitBreaker.HalfOpenRequests", "half-open requests must not be negative")
+		}
+	}
+}
+
+// validateRateLimits checks the rate limits of a proxy, each key is limited once.
+func (v *validator) validateRateLimits(path string, limits []RateLimit) {
+	keys := make(map[string]int)
+	for i, l := range limits {
+		p := fmt.Sprintf("%s[%d]", path, i)
+		key := l.KeyOf()
+		switch {
+		case key == RateLimitByIp, key == RateLimitByUser, key == RateLimitByBackend:
+		case strings.HasPrefix(key, RateLimitHeaderPrefix):
+			if name := strings.TrimPrefix(key, RateLimitHeaderPrefix); !isToken(name) {
+				v.errorf(p+".Key", "invalid header name %q", name)
+			}
+		default:
+			v.errorf(p+".Key", "unknown key %q, expected one of %s", l.Key, strings.Join(RateLimitKeys, ", "))
+		}
+		if j, ok := keys[key]; ok {
+			v.errorf(p+".Key", "key %s is already limited by %s[%d]", key, path, j)
+		} else {
+			keys[key] = i
+		}
+		if l.Requests <= 0 {
+			v.errorf(p+".Requests", "requests must be positive")
+		}
+		if l.Period < 0 {
+			v.errorf(p+".Period", "period must not be negative")
+		}
+		if l.Burst < 0 {
+			v.errorf(p+".Burst", "burst must not be negative")
+		}
+	}
+}
+
+// validateRoutes checks the routes of a proxy, prefixes are unique across
+// the proxies of a deployment.
+func (v *validator) validateRoutes(path string, routes []Route, prefixes map[string]string) {
+	for i, r := range routes {
+		p := fmt.Sprintf("%s[%d]", path, i)
+		prefix := strings.TrimSuffix(r.Prefix, "/")
+		switch {
+		case !strings.HasPrefix(r.Prefix, "/"):
+			v.errorf(p+".Prefix", "route prefix %q must start with '/'", r.Prefix)
+		case prefix == "":
+			v.errorf(p+".Prefix", "route prefix must not be '/', it would take the requests of every backend")
+		case prefixes[prefix] != "":
+			v.errorf(p+".Prefix", "duplicate route prefix %q, first declared in %s", r.Prefix, prefixes[prefix])
+		default:
+			prefixes[prefix] = p
+		}
+		if r.ReplacePrefix != "" && !strings.HasPrefix(r.ReplacePrefix, "/") {
+			v.errorf(p+".ReplacePrefix", "replacement prefix %q must start with '/'", r.ReplacePrefix)
+		}
+		for j, m := range r.Methods {
+			if !knownMethods[strings.ToUpper(m)] {
+				v.errorf(fmt.Sprintf("%s.Methods[%d]", p, j), "unknown method %q", m)
+			}
+		}
+		for name := range r.SetHeaders {
+			if !isToken(name) {
+				v.errorf(p+".SetHeaders."+name, "invalid header name %q", name)
+			}
+		}
+		for j, name := range r.RemoveHeaders {
+			if !isToken(name) {
+				v.errorf(fmt.Sprintf("%s.RemoveHeaders[%d]", p, j), "invalid header name %q", name)
+			}
+		}
+		if r.Host != "" && strings.ContainsAny(r.Host, " /?#@") {
+			v.errorf(p+".Host", "host %q is malformed", r.Host)
+		}
+		if r.Access != nil {
+			v.validateAccess(p+".Access", r.Access.Roles, r.Access.Claims)
+		}
+	}
+}
+
+// validateCors checks the cross-origin policy of a deployment.
+func (v *validator) validateCors(path string, c *Cors) {
+	if c == nil {
+		return
+	}
+	if len(c.AllowedOrigins) == 0 {
+		v.errorf(path+".AllowedOrigins", "at least one origin is required")
+	}
+	for i, o := range c.AllowedOrigins {
+		p := fmt.Sprintf("%s.AllowedOrigins[%d]", path, i)
+		switch {
+		case o == CorsAnyOrigin:
+			if c.AllowCredentials {
+				v.errorf(p, "origin * cannot be combined with AllowCredentials")
+			}
+		case !validOrigin(o):
+			v.errorf(p, "origin %q must be scheme://host[:port] without path", o)
+		}
+	}
+	for i, m := range c.AllowedMethods {
+		if !isToken(m) || m != strings.ToUpper(m) {
+			v.errorf(fmt.Sprintf("%s.AllowedMethods[%d]", path, i), "method %q must be an upper case HTTP method", m)
+		}
+	}
+	for i, h := range c.AllowedHeaders {
+		if !isToken(h) {
+			v.errorf(fmt.Sprintf("%s.AllowedHeaders[%d]", path, i), "header %q is not a valid header name", h)
+		} else if h == "*" && c.AllowCredentials {
+			v.errorf(fmt.Sprintf("%s.AllowedHeaders[%d]", path, i), "header * cannot be combined with AllowCredentials")
+		}
+	}
+	for i, h := range c.ExposedHeaders {
+		if !isToken(h) || h == "*" {
+			v.errorf(fmt.Sprintf("%s.ExposedHeaders[%d]", path, i), "header %q is not a valid header name", h)
+		}
+	}
+	if c.MaxAge < 0 || time.Duration(c.MaxAge) > 24*time.Hour {
+		v.errorf(path+".MaxAge", "max age must be between 0s and 24h")
+	}
+}
+
+// validOrigin reports whether o is a serialized origin, the host may start
+// with a *. wildcard.
+func validOrigin(o string) bool {
+	u, err := url.Parse(strings.Replace(o, "://*.", "://", 1))
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" &&
+		u.Path == "" && u.RawQuery == "" && u.Fragment == "" && u.User == nil && !strings.HasSuffix(o, "/")
+}
+
+// validateTranslations reports the titles lacking a translation for one of locales.
+func (v *validator) validateTranslations(path string, items []Navigation, locales []string) {
+	for i, n := range items {
+		p := fmt.Sprintf("%s[%d]", path, i)
+		translated := make(map[string]bool)
+		for locale := range n.Titles {
+			c, err := CanonicalLocale(locale)
+			if err != nil {
+				v.errorf(p+".Titles."+locale, "invalid locale %q", locale)
+				continue
+			}
+			translated[c] = strings.TrimSpace(n.Titles[locale]) != ""
+		}
+		for _, locale := range locales {
+			if !translated[locale] {
+				v.errorf(p+".Titles", "missing %s translation of title %q", locale, n.Title)
+			}
+		}
+		v.validateTranslations(p+".Children", n.Children, locales)
+	}
+}
+
+// validateNavigations checks the items of a navigation level and their
+// children, endpoints and group ids are unique across the whole tree.
+func (v *validator) validateNavigations(path string, items []Navigation, endpoints map[string]string, ids map[string]string) {
+	for i, n := range items {
+		p := fmt.Sprintf("%s[%d]", path, i)
+		switch {
+		case n.Endpoint == "" && len(n.Children) == 0:
+			v.errorf(p+".Endpoint", "navigation endpoint is required")
+		case n.Endpoint == "":
+			// a group only needs an id
+		case !strings.HasPrefix(n.Endpoint, "/"):
+			v.errorf(p+".Endpoint", "navigation endpoint %q must start with '/'", n.Endpoint)
+		case endpoints[n.Endpoint] != "":
+			v.errorf(p+".Endpoint", "duplicate navigation endpoint %q, first declared in %s", n.Endpoint, endpoints[n.Endpoint])
+		default:
+			endpoints[n.Endpoint] = p
+		}
+		switch {
+		case n.Id == "" && n.Endpoint == "" && len(n.Children) > 0:
+			v.errorf(p+".Id", "id is required for a group without endpoint")
+		case n.Id == "":
+		case !endpointPattern.MatchString(n.Id):
+			v.errorf(p+".Id", "id %q must only contain letters, digits, '.', '_' or '-'", n.Id)
+		case ids[n.Id] != "":
+			v.errorf(p+".Id", "duplicate navigation id %q, first declared in %s", n.Id, ids[n.Id])
+		default:
+			ids[n.Id] = p
+		}
+		if strings.TrimSpace(n.Title) == "" {
+			v.errorf(p+".Title", "title is required")
+		}
+		v.validateAccess(p, n.Roles, n.Claims)
+		v.validateNavigations(p+".Children", n.Children, endpoints, ids)
+	}
+}
+
+// validateKind checks the fields the kind requires or forbids.
+func (v *validator) validateKind(spec KindSpec, d DeploymentRequest) {
+	value := reflect.ValueOf(d)
+	index := yamlFieldIndexes(value.Type())
+	for _, name := range spec.Required {
+		if i, ok := index[name]; ok && isEmpty(value.Field(i)) {
+			v.errorf(name, "%s is required for kind %s", name, spec.Name)
+		}
+	}
+	for _, name := range spec.Forbidden {
+		if i, ok := index[name]; ok && !isEmpty(value.Field(i)) {
+			v.errorf(name, "%s is not allowed for kind %s", name, spec.Name)
+		}
+	}
+}
+
+func yamlFieldIndexes(t reflect.Type) map[string]int {
+	index := make(map[string]int)
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		if name != "" && name != "-" {
+			index[name] = i
+		}
+	}
+	return index
+}
+
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String:
+		return strings.TrimSpace(v.String()) == ""
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	default:
+		return v.IsZero()
+	}
+}
+
+// validateAddress accepts host, host:port or an absolute http(s) URL.
+func validateAddress(addr string) error {
+	addr = strings.TrimSpace(addr)
+	if addr == "" {
+		return fmt.Errorf("backend address is required")
+	}
+	if strings.Contains(addr, "://") {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return fmt.Errorf("backend address %q is malformed: %v", addr, err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("backend address %q must use http or https", addr)
+		}
+		if u.Host == "" {
+			return fmt.Errorf("backend address %q has no host", addr)
+		}
+		return nil
+	}
+	host := addr
+	if strings.Contains(addr, ":") {
+		h, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return fmt.Errorf("backend address %q is malformed: %v", addr, err)
+		}
+		if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+			return fmt.Errorf("backend address %q has invalid port", addr)
+		}
+		host = h
+	}
+	if host == "" || strings.ContainsAny(host, " /?#@") {
+		return fmt.Errorf("backend address %q has invalid host", addr)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseDeployment(t *testing.T) {
+	b := []byte(`Kind: container
+Endpoint: orders
+Unknown: 1
+Proxies:
+  - BackendCode: orders
+    BackendAddress: orders:8080
+  - BackendCode: orders
+    BackendAddress: "orders:port"
+Navigations:
+  - Endpoint: /orders
+    Title: Orders
+`)
+	if _, err := ParseDeployment(b, false); err == nil {
+		t.Logf("duplicate backend code and malformed address should be reported")
+		t.FailNow()
+	}
+	_, err := ParseDeployment(b, true)
+	var errs ValidationErrors
+	if !errors.As(err, &errs) || len(errs) != 3 {
+		t.Logf("expected 3 errors, actual = %v", err)
+		t.FailNow()
+	}
+	expected := []ValidationError{
+		{Line: 3, Column: 1, Field: "Unknown"},
+		{Line: 7, Column: 18, Field: "Proxies[1].BackendCode"},
+		{Line: 8, Column: 21, Field: "Proxies[1].BackendAddress"},
+	}
+	for i, e := range expected {
+		if errs[i].Line != e.Line || errs[i].Column != e.Column || errs[i].Field != e.Field {
+			t.Logf("expected error at %d:%d %s, actual = %v", e.Line, e.Column, e.Field, errs[i])
+			t.FailNow()
+		}
+	}
+}
+
+func TestValidateRootRoute(t *testing.T) {
+	d := DeploymentRequest{
+		Kind:     KindContainer,
+		Endpoint: "portal",
+		Proxies:  []Proxy{{BackendCode: "all", BackendAddress: "all:8080", Routes: []Route{{Prefix: "/"}}}},
+	}
+	var errs ValidationErrors
+	if err := ValidateDeployment(d); !errors.As(err, &errs) || len(errs) != 1 || errs[0].Field != "Proxies[0].Routes[0].Prefix" {
+		t.Logf("expected route prefix / to be rejected, actual = %v", err)
+		t.FailNow()
+	}
+}
+
+func TestValidateKind(t *testing.T) {
+	microapp := DeploymentRequest{Kind: KindMicroapp, Endpoint: "orders"}
+	if err := ValidateDeployment(microapp); err == nil {
+		t.Logf("microapp without container should be rejected")
+		t.FailNow()
+	}
+	microapp.Container = "portal"
+	if err := ValidateDeployment(microapp); err != nil {
+		t.Logf("microapp should be valid: %v", err)
+		t.FailNow()
+	}
+	cdn := DeploymentRequest{
+		Kind:        KindCdn,
+		Endpoint:    "assets",
+		Navigations: []Navigation{{Endpoint: "/home", Title: "Home"}},
+	}
+	if err := ValidateDeployment(cdn); err == nil {
+		t.Logf("cdn with navigations should be rejected")
+		t.FailNow()
+	}
+}
+
+func TestValidateResilience(t *testing.T) {
+	b := []byte(`Kind: container
+Endpoint: orders
+Proxies:
+  - BackendCode: orders
+    BackendAddress: orders:8080
+    Timeouts:
+      Connect: 2s
+      Response: 10s
+    Retry:
+      Attempts: 2
+      Backoff: 1s
+      MaxBackoff: 500ms
+    CircuitBreaker:
+      FailureThreshold: 0
+`)
+	_, err := ParseDeployment(b, true)
+	var errs ValidationErrors
+	if !errors.As(err, &errs) || len(errs) != 2 || errs[0].Field != "Proxies[0].Retry.MaxBackoff" || errs[1].Field != "Proxies[0].CircuitBreaker.FailureThreshold" {
+		t.Logf("expected max backoff and failure threshold errors, actual = %v", err)
+		t.FailNow()
+	}
+	b = bytes.Replace(b, []byte("500ms"), []byte("5s"), 1)
+	b = bytes.Replace(b, []byte("FailureThreshold: 0"), []byte("FailureThreshold: 3"), 1)
+	d, err := ParseDeployment(b, true)
+	if err != nil {
+		t.Logf("expected valid deployment, actual = %v", err)
+		t.FailNow()
+	}
+	if d.Proxies[0].Timeouts.Response != Duration(10*time.Second) {
+		t.Logf("expected response timeout of 10s, actual = %v", d.Proxies[0].Timeouts)
+		t.FailNow()
+	}
+}
+
+func TestValidateRateLimits(t *testing.T) {
+	p := Proxy{BackendCode: "orders", BackendAddress: "orders:8080", RateLimits: []RateLimit{
+		{Requests: 10},
+		{Key: "ip", Requests: 5},
+		{Key: "header:X Api Key", Requests: 5},
+		{Key: "session", Requests: 0},
+	}}
+	err := ValidateDeployment(DeploymentRequest{Kind: KindContainer, Endpoint: "orders", Proxies: []Proxy{p}})
+	var errs ValidationErrors
+	if !errors.As(err, &errs) || len(errs) != 4 {
+		t.Logf("expected duplicate key, invalid header, unknown key and missing requests, actual = %v", err)
+		t.FailNow()
+	}
+}
+
+func TestValidateCors(t *testing.T) {
+	cors := &Cors{
+		AllowedOrigins:   []string{"https://shop.example", "https://*.shop.example", "*", "https://shop.example/app", "ftp://files.example"},
+		AllowedMethods:   []string{"GET", "patch"},
+		AllowedHeaders:   []string{"X-Api-Key", "X Bad"},
+		AllowCredentials: true,
+		MaxAge:           Duration(48 * time.Hour),
+	}
+	err := ValidateDeployment(DeploymentRequest{Kind: KindCdn, Endpoint: "assets", Cors: cors})
+	var errs ValidationErrors
+	if !errors.As(err, &errs) || len(errs) != 6 {
+		t.Logf("expected * with credentials, path, scheme, method, header and max age errors, actual = %v", err)
+		t.FailNow()
+	}
+	strict := Cors{AllowedOrigins: []string{"https://*.shop.example"}}
+	if strict.AllowsOrigin("https://evilshop.example") || strict.AllowsOrigin("http://eu.shop.example") || !strict.AllowsOrigin("https://EU.shop.example") {
+		t.Logf("expected wildcard origin to match subdomains of the same scheme only")
+		t.FailNow()
+	}
+}
@@ -1,5 +1,251 @@
 package database
 
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	mu       sync.RWMutex
+	releases map[string]Release
+	// history holds the previously active releases of each endpoint, latest last.
+	history map[string][]Release
+	// generation is incremented whenever the active releases change.
+	generation uint64
+	// watchers are signalled whenever generation is incremented.
+	watchers []chan struct{}
+	// audit holds the audit records, oldest first.
+	audit []AuditRecord
+)
+
+// maxAuditRecords bounds the audit log, the oldest records are dropped first.
+const maxAuditRecords = 10000
+
 func ConnectDatabase() error {
+	mu.Lock()
+	defer mu.Unlock()
+	releases = make(map[string]Release)
+	history = make(map[string][]Release)
+	audit = nil
+	return nil
+}
+
+func NewId() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// SaveRelease activates r, replacing the release served at the same endpoint.
+func SaveRelease(r Release) error {
+	mu.Lock()
+	defer mu.Unlock()
+	endpoint := r.Deployment.Endpoint
+	if current, ok := releases[endpoint]; ok {
+		history[endpoint] = append(history[endpoint], current)
+	}
+	releases[endpoint] = r
+	changed()
 	return nil
 }
+
+// RollbackRelease restores the release that was active before the current
+// one of endpoint, or removes endpoint when there is none.
+func RollbackRelease(endpoint string) (Release, bool, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := releases[endpoint]; !ok {
+		return Release{}, false, fmt.Errorf("%s is not deployed", endpoint)
+	}
+	previous := history[endpoint]
+	changed()
+	if len(previous) == 0 {
+		delete(releases, endpoint)
+		return Release{}, false, nil
+	}
+	r := previous[len(previous)-1]
+	history[endpoint] = previous[:len(previous)-1]
+	releases[endpoint] = r
+	return r, true, nil
+}
+
+// DeleteRelease removes endpoint together with its release history.
+func DeleteRelease(endpoint string) (Release, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	r, ok := releases[endpoint]
+	if !ok {
+		return Release{}, fmt.Errorf("%s is not deployed", endpoint)
+	}
+	delete(releases, endpoint)
+	delete(history, endpoint)
+	changed()
+	return r, nil
+}
+
+// ListReleases returns the active release of endpoint followed by the
+// previous ones, latest first.
+func ListReleases(endpoint string) ([]Release, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	current, ok := releases[endpoint]
+	if !ok {
+		return nil, false
+	}
+	previous := history[endpoint]
+	rs := make([]Release, 0, len(previous)+1)
+	rs = append(rs, current)
+	for i := len(previous) - 1; i >= 0; i-- {
+		rs = append(rs, previous[i])
+	}
+	return rs, true
+}
+
+// CountReleases returns the number of active releases and of all kept releases.
+func CountReleases() (int, int) {
+	mu.RLock()
+	defer mu.RUnlock()
+	total := len(releases)
+	for _, h := range history {
+		total += len(h)
+	}
+	return len(releases), total
+}
+
+func GetRelease(endpoint string) (Release, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	r, ok := releases[endpoint]
+	return r, ok
+}
+
+func GetDeployment(endpoint string) (Deployment, bool) {
+	r, ok := GetRelease(endpoint)
+	return r.Deployment, ok
+}
+
+// Generation changes whenever a release is activated, rolled back or deleted,
+// caches derived from the active releases compare it to know they are stale.
+func Generation() uint64 {
+	mu.RLock()
+	defer mu.RUnlock()
+	return generation
+}
+
+// Watch returns a channel that receives a value after the active releases
+// change, changes made before the value is received are coalesced.
+func Watch() <-chan struct{} {
+	mu.Lock()
+	defer mu.Unlock()
+	ch := make(chan struct{}, 1)
+	watchers = append(watchers, ch)
+	return ch
+}
+
+// changed increments generation and signals the watchers, mu must be held.
+func changed() {
+	generation++
+	for _, ch := range watchers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// ActiveReleases returns the active release of every endpoint ordered by endpoint.
+func ActiveReleases() []Release {
+	mu.RLock()
+	defer mu.RUnlock()
+	rs := make([]Release, 0, len(releases))
+	for _, r := range releases {
+		rs = append(rs, r)
+	}
+	sort.Slice(rs, func(i, j int) bool {
+		return rs[i].Deployment.Endpoint < rs[j].Deployment.Endpoint
+	})
+	return rs
+}
+
+// ListDeployments returns the active deployments ordered by endpoint.
+func ListDeployments() []Deployment {
+	mu.RLock()
+	defer mu.RUnlock()
+	rs := make([]Deployment, 0, len(releases))
+	for _, r := range releases {
+		rs = append(rs, r.Deployment)
+	}
+	sort.Slice(rs, func(i, j int) bool {
+		return rs[i].Endpoint < rs[j].Endpoint
+	})
+	return rs
+}
+
+// SaveAsset adds a to the release active at endpoint, replacing the asset
+// of the same path.
+func SaveAsset(endpoint string, a Asset) error {
+	mu.Lock()
+	defer mu.Unlock()
+	r, ok := releases[endpoint]
+	if !ok {
+		return fmt.Errorf("%s is not deployed", endpoint)
+	}
+	a.DeploymentId = r.Deployment.Id
+	assets := make([]Asset, 0, len(r.Assets)+1)
+	for _, existing := range r.Assets {
+		if existing.Path != a.Path {
+			assets = append(assets, existing)
+		}
+	}
+	r.Assets = append(assets, a)
+	releases[endpoint] = r
+	changed()
+	return nil
+}
+
+func AppendAudit(r AuditRecord) {
+	mu.Lock()
+	defer mu.Unlock()
+	if len(audit) >= maxAuditRecords {
+		audit = append(audit[:0:0], audit[len(audit)-maxAuditRecords+1:]...)
+	}
+	audit = append(audit, r)
+}
+
+// AuditQuery selects audit records, empty fields match any record.
+type AuditQuery struct {
+	Actor    string
+	Source   string
+	Endpoint string
+	Since    time.Time
+	Until    time.Time
+	// Limit bounds the number of records returned, all when zero.
+	Limit int
+}
+
+// ListAudit returns the audit records matching q, latest first.
+func ListAudit(q AuditQuery) []AuditRecord {
+	mu.RLock()
+	defer mu.RUnlock()
+	rs := make([]AuditRecord, 0)
+	for i := len(audit) - 1; i >= 0; i-- {
+		r := audit[i]
+		switch {
+		case q.Actor != "" && r.Actor != q.Actor,
+			q.Source != "" && r.Source != q.Source,
+			q.Endpoint != "" && r.Endpoint != q.Endpoint,
+			!q.Since.IsZero() && r.Time.Before(q.Since),
+			!q.Until.IsZero() && !r.Time.Before(q.Until):
+			continue
+		}
+		rs = append(rs, r)
+		if q.Limit > 0 && len(rs) == q.Limit {
+			break
+		}
+	}
+	return rs
+}
@@ -1,17 +1,47 @@
 package database
 
+import "time"
+
 type Deployment struct {
-	Id       string
-	Kind     string
-	Name     string
-	Endpoint string
+	Id        string
+	Kind      string
+	Name      string
+	Version   string
+	Endpoint  string
+	Container string
+	// Signer is the name of the trusted key the deployment was signed with, empty when unsigned.
+	Signer  string
+	Locales []string
+	// AccessRoles and AccessClaims restrict the shell, see core.Access.
+	AccessRoles  []string
+	AccessClaims map[string][]string
+	// CorsOrigins is empty when only the same origin may load the assets.
+	CorsOrigins        []string
+	CorsMethods        []string
+	CorsHeaders        []string
+	CorsExposedHeaders []string
+	CorsCredentials    bool
+	CorsMaxAge         time.Duration
+	CreatedAt          time.Time
 }
 
+// Navigation is an item of the navigation tree of a deployment, stored flat.
+// ParentId is the Id of the group holding the item, empty at top level.
 type Navigation struct {
 	Id           string
 	DeploymentId string
-	Endpoint     string
-	Title        string
+	ParentId     string
+	// Position keeps the declaration order among the items of a level.
+	Position int
+	Key      string
+	Endpoint string
+	Title    string
+	Titles   map[string]string
+	Icon     string
+	Order    int
+	Hidden   bool
+	Roles    []string
+	Claims   map[string][]string
 }
 
 type Proxy struct {
@@ -19,4 +49,98 @@ type Proxy struct {
 	DeploymentId   string
 	BackendCode    string
 	BackendAddress string
+	Secure         bool
+	Upstreams      []string
+	Strategy       string
+	HashHeader     string
+	HashCookie     string
+	// HealthPath is empty when upstreams are not actively checked.
+	HealthPath         string
+	HealthInterval     time.Duration
+	HealthTimeout      time.Duration
+	HealthyThreshold   int
+	UnhealthyThreshold int
+	// EjectMaxFailures is zero when failing upstreams are not ejected.
+	EjectMaxFailures int
+	EjectDuration    time.Duration
+	ConnectTimeout   time.Duration
+	ResponseTimeout  time.Duration
+	// RetryAttempts is zero when failed requests are not retried.
+	RetryAttempts   int
+	RetryBackoff    time.Duration
+	RetryMaxBackoff time.Duration
+	// BreakerFailures is zero when upstreams have no circuit breaker.
+	BreakerFailures         int
+	BreakerOpenDuration     time.Duration
+	BreakerHalfOpenRequests int
+	// AuthForward is empty when the access token is forwarded.
+	AuthForward  string
+	AuthAudience string
+	AuthScopes   []string
+	AccessRoles  []string
+	AccessClaims map[string][]string
+}
+
+// ProxyRateLimit is a rate limit of a proxy, Position keeps the declaration order.
+type ProxyRateLimit struct {
+	Id       string
+	ProxyId  string
+	Position int
+	Key      string
+	Requests int
+	Period   time.Duration
+	Burst    int
+}
+
+// ProxyRoute is a route rule of a proxy, Position keeps the declaration order.
+type ProxyRoute struct {
+	Id            string
+	ProxyId       string
+	Position      int
+	Prefix        string
+	StripPrefix   bool
+	ReplacePrefix string
+	Methods       []string
+	SetHeaders    map[string]string
+	RemoveHeaders []string
+	Host          string
+	AccessRoles   []string
+	AccessClaims  map[string][]string
+}
+
+type Asset struct {
+	Id           string
+	DeploymentId string
+	Path         string
+	Digest       string
+	// Integrity is the sha384 Subresource Integrity metadata of the content.
+	Integrity string
+}
+
+// Release groups a deployment with the records it owns, it is activated and
+// rolled back as a whole.
+type Release struct {
+	Deployment  Deployment
+	Navigations []Navigation
+	Proxies     []Proxy
+	Routes      []ProxyRoute
+	RateLimits  []ProxyRateLimit
+	Assets      []Asset
+	// Manifest holds the digests of the assets keyed by path the deployment
+	// was sent with, uploads must match them.
+	Manifest map[string]string
+}
+
+// AuditRecord is a mutating action of an operator, Diff holds the JSON
+// encoded change of the release and is empty when nothing changed.
+type AuditRecord struct {
+	Id       string
+	Time     time.Time
+	Actor    string
+	Address  string
+	Source   string
+	Command  string
+	Endpoint string
+	Error    string
+	Diff     []byte
 }
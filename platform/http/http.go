@@ -1,9 +1,12 @@
 package http
 
 import (
+	"crypto/tls"
 	"fmt"
+	"goruf/platform/database"
 	glog "log"
 	"net/http"
+	"net/netip"
 	"strings"
 	"time"
 
@@ -22,7 +25,76 @@ func (fw *FwdToZeroWriter) Write(p []byte) (n int, err error) {
 	return len(p), nil
 }
 
-func StartWebService(port int64) error {
+type Options struct {
+	Locale LocaleOptions
+	// RateLimit keeps the buckets of the rate limits of the proxies.
+	RateLimit RateLimitStore
+	Auth      AuthOptions
+	// Policy decides the access to restricted shells, navigations and APIs.
+	Policy Policy
+	Admin  AdminOptions
+	// Security configures the security headers and the policy of the shells.
+	Security SecurityOptions
+	// TLSConfig serves HTTPS when set, see AdminOptions for its ClientCAs.
+	TLSConfig *tls.Config
+	// TrustedProxies are the proxies whose forwarding headers name the client,
+	// the socket peer is the client otherwise.
+	TrustedProxies []netip.Prefix
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Locale:    DefaultLocaleOptions(),
+		RateLimit: NewMemoryRateLimitStore(),
+		Auth:      DefaultAuthOptions(),
+		Policy:    DefaultPolicy(),
+		Security:  DefaultSecurityOptions(),
+	}
+}
+
+// options are the settings StartWebService was called with.
+var options = DefaultOptions()
+
+func StartWebService(port int64, opts Options) error {
+	if opts.Policy == nil {
+		opts.Policy = DefaultPolicy()
+	}
+	options = opts
+	if !opts.adminEnabled() {
+		log.Warn().Msg("no admin token nor client CA, the admin API is closed")
+	}
+	if opts.Auth.Issuer != "" {
+		a, err := newAuthenticator(opts.Auth)
+		if err != nil {
+			return fmt.Errorf("invalid authentication options: %w", err)
+		}
+		auth = a
+	}
+	go apiRoutes.watch(database.Watch())
+	h2s := &http2.Server{}
+	httpServer := &http.Server{
+		Addr:      fmt.Sprintf(":%d", port),
+		Handler:   h2c.NewHandler(newRouter(), h2s),
+		ErrorLog:  glog.New(&FwdToZeroWriter{}, "", 0),
+		TLSConfig: opts.TLSConfig,
+	}
+	go func() {
+		log.Info().Int("port", int(port)).Bool("tls", opts.TLSConfig != nil).Msg("start http server")
+		var err error
+		if opts.TLSConfig != nil {
+			// the certificates are part of TLSConfig
+			err = httpServer.ListenAndServeTLS("", "")
+		} else {
+			err = httpServer.ListenAndServe()
+		}
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to start http server")
+		}
+	}()
+	return nil
+}
+
+func newRouter() http.Handler {
 	r := chi.NewRouter()
 	r.Use(func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -35,35 +107,26 @@ func StartWebService(port int64) error {
 		})
 	})
 	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
+	r.Use(keepPeer)
+	r.Use(realIP)
+	r.Use(SecurityHeaders)
+	r.Use(LoadSession)
 	r.Use(FilterApi)
-	r.Get("/cdn/*", func(w http.ResponseWriter, r *http.Request) {
-		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
-	})
-	r.Get("/resource/{service}/*", func(w http.ResponseWriter, r *http.Request) {
+	r.With(withCors(cdnDeployment)).Get("/cdn/{endpoint}/*", serveAsset)
+	r.With(withCors(cdnDeployment)).Options("/cdn/{endpoint}/*", notPreflight)
+	r.With(withCors(resourceDeployment)).Get("/resource/{service}/*", func(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
 	})
+	r.With(withCors(resourceDeployment)).Options("/resource/{service}/*", notPreflight)
 	r.Mount("/api", adminRouter())
-	r.Get("/{endpoint}/*", func(w http.ResponseWriter, r *http.Request) {
-		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
-	})
+	r.Mount("/auth", authRouter())
+	r.Get("/navigation.json", serveNavigation)
+	r.Post(cspReportPath, collectCspReport)
+	r.Get("/{endpoint}/*", requireSession(serveEndpoint))
 	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("Index"))
 	})
-	h2s := &http2.Server{}
-	httpServer := &http.Server{
-		Addr:     fmt.Sprintf(":%d", port),
-		Handler:  h2c.NewHandler(r, h2s),
-		ErrorLog: glog.New(&FwdToZeroWriter{}, "", 0),
-	}
-	go func() {
-		log.Info().Int("port", int(port)).Msg("start http server")
-		err := httpServer.ListenAndServe()
-		if err != nil {
-			log.Fatal().Err(err).Msg("failed to start http server")
-		}
-	}()
-	return nil
+	return r
 }
 
 func FilterApi(next http.Handler) http.Handler {
@@ -71,7 +134,9 @@ func FilterApi(next http.Handler) http.Handler {
 		reqTyp := strings.TrimSpace(r.Header.Get("X-Request-Type"))
 		switch strings.ToLower(reqTyp) {
 		case "api":
-			apiProxy(w, r)
+			if authenticated(w, r, true) {
+				apiProxy(w, r)
+			}
 		default:
 			next.ServeHTTP(w, r)
 		}
@@ -79,21 +144,12 @@ func FilterApi(next http.Handler) http.Handler {
 	return http.HandlerFunc(fn)
 }
 
-func apiProxy(w http.ResponseWriter, _ *http.Request) {
-	http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
-}
-
 func adminRouter() http.Handler {
 	r := chi.NewRouter()
 	r.Use(middleware.Timeout(300 * time.Second))
-	r.Get("/deployments", func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte("welcome"))
-	})
-	r.Get("/navigations", func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte("welcome"))
-	})
-	r.Get("/proxies", func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte("welcome"))
-	})
+	r.Use(requireAdmin)
+	r.Get("/proxies", serveProxies)
+	r.Get("/audit", serveAudit)
+	r.Get("/csp-reports", serveCspReports)
 	return r
 }
@@ -0,0 +1,165 @@
+package http
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"goruf/platform/core"
+	"goruf/platform/database"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// AdminOptions protects the admin API under /api. Operators authenticate
+// with one of Tokens or with a client certificate verified by the ClientCAs
+// of Options.TLSConfig, the admin API is closed when neither is set.
+type AdminOptions struct {
+	// Tokens are the accepted bearer tokens keyed by the name of the operator.
+	Tokens map[string]string
+}
+
+func (o Options) adminEnabled() bool {
+	return len(o.Admin.Tokens) > 0 || o.TLSConfig != nil && o.TLSConfig.ClientCAs != nil
+}
+
+type adminKey struct{}
+
+// adminOf returns the operator of r, a verified client certificate wins
+// over a bearer token.
+func adminOf(r *http.Request) (string, bool) {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return r.TLS.VerifiedChains[0][0].Subject.CommonName, true
+	}
+	scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	for name, t := range options.Admin.Tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// requireAdmin answers 401 to requests without admin credentials.
+func requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor, ok := adminOf(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeError(w, http.StatusUnauthorized, errorBody{Error: "unauthenticated", Message: "admin credentials required"})
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), adminKey{}, actor)))
+	})
+}
+
+// RecordAudit appends e to the audit log, its Id and Time are set when empty.
+func RecordAudit(e core.AuditEntry) {
+	if e.Id == "" {
+		e.Id = database.NewId()
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	r := database.AuditRecord{
+		Id:       e.Id,
+		Time:     e.Time,
+		Actor:    e.Actor,
+		Address:  e.Address,
+		Source:   e.Source,
+		Command:  e.Command,
+		Endpoint: e.Endpoint,
+		Error:    e.Error,
+	}
+	if e.Diff != nil {
+		b, err := json.Marshal(e.Diff)
+		if err != nil {
+			log.Error().Err(err).Str("command", e.Command).Msg("failed to encode audited diff")
+		}
+		r.Diff = b
+	}
+	database.AppendAudit(r)
+	log.Info().
+		Str("actor", e.Actor).
+		Str("address", e.Address).
+		Str("source", e.Source).
+		Str("command", e.Command).
+		Str("endpoint", e.Endpoint).
+		Str("error", e.Error).
+		Msg("audit")
+}
+
+// AuditOf returns the audit entries of the records.
+func AuditOf(records []database.AuditRecord) []core.AuditEntry {
+	rs := make([]core.AuditEntry, 0, len(records))
+	for _, r := range records {
+		e := core.AuditEntry{
+			Id:       r.Id,
+			Time:     r.Time,
+			Actor:    r.Actor,
+			Address:  r.Address,
+			Source:   r.Source,
+			Command:  r.Command,
+			Endpoint: r.Endpoint,
+			Error:    r.Error,
+		}
+		if len(r.Diff) > 0 {
+			e.Diff = &core.ReleaseDiff{}
+			if err := json.Unmarshal(r.Diff, e.Diff); err != nil {
+				log.Error().Err(err).Str("id", r.Id).Msg("failed to decode audited diff")
+				e.Diff = nil
+			}
+		}
+		rs = append(rs, e)
+	}
+	return rs
+}
+
+// serveAudit lists the audit log latest first, filtered by the actor,
+// source, endpoint, since, until and limit query parameters.
+func serveAudit(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	query := database.AuditQuery{
+		Actor:    q.Get("actor"),
+		Source:   q.Get("source"),
+		Endpoint: q.Get("endpoint"),
+		Limit:    defaultAuditLimit,
+	}
+	invalid := func(msg string) {
+		writeError(w, http.StatusBadRequest, errorBody{Error: "invalid_query", Message: msg})
+	}
+	var err error
+	if v := q.Get("since"); v != "" {
+		if query.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			invalid("since must be an RFC 3339 time")
+			return
+		}
+	}
+	if v := q.Get("until"); v != "" {
+		if query.Until, err = time.Parse(time.RFC3339, v); err != nil {
+			invalid("until must be an RFC 3339 time")
+			return
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if query.Limit, err = strconv.Atoi(v); err != nil || query.Limit < 1 || query.Limit > maxAuditLimit {
+			invalid(fmt.Sprintf("limit must be between 1 and %d", maxAuditLimit))
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(AuditOf(database.ListAudit(query)))
+}
@@ -0,0 +1,75 @@
+package http
+
+import (
+	"encoding/json"
+	"goruf/platform/core"
+	"goruf/platform/database"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminApi(t *testing.T) {
+	database.ConnectDatabase()
+	previous := options
+	options.Admin.Tokens = map[string]string{"ci": "secret"}
+	defer func() { options = previous }()
+	router := newRouter()
+	send := func(method, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Set("X-Forwarded-For", "192.0.2.1")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, token := range []string{"", "guess"} {
+		if w := send(http.MethodGet, "/api/proxies", token); w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+			t.Logf("expected token %q to be rejected, actual = %d", token, w.Code)
+			t.FailNow()
+		}
+	}
+	if w := send(http.MethodGet, "/api/proxies", "secret"); w.Code != http.StatusOK {
+		t.Logf("expected operator to list proxies, actual = %d", w.Code)
+		t.FailNow()
+	}
+	if w := send(http.MethodGet, "/api/deployments", "secret"); w.Code != http.StatusNotFound {
+		t.Logf("expected no deployments listing, actual = %d", w.Code)
+		t.FailNow()
+	}
+	RecordAudit(core.AuditEntry{Actor: "deployer", Address: "10.0.0.2:4321", Source: core.AuditSourceCluster, Command: "delete", Endpoint: "shop", Error: "shop is not deployed"})
+	diff := core.DiffReleases(nil, core.Release{Deployment: core.DeploymentRequest{Endpoint: "portal", Version: "v1"}})
+	RecordAudit(core.AuditEntry{Actor: "deployer", Address: "10.0.0.2:4321", Source: core.AuditSourceCluster, Command: "deploy", Endpoint: "portal", Diff: &diff})
+
+	audit := func(query string) []core.AuditEntry {
+		w := send(http.MethodGet, "/api/audit"+query, "secret")
+		var rs []core.AuditEntry
+		if err := json.NewDecoder(w.Body).Decode(&rs); err != nil {
+			t.Logf("expected audit entries, actual = %d %v", w.Code, err)
+			t.FailNow()
+		}
+		return rs
+	}
+	rs := audit("")
+	if len(rs) != 2 || rs[0].Command != "deploy" || rs[0].Diff == nil || !rs[0].Diff.Initial || rs[0].Diff.Endpoint != "portal" {
+		t.Logf("expected deployment with its diff first, actual = %+v", rs)
+		t.FailNow()
+	}
+	failed := rs[1]
+	if failed.Command != "delete" || failed.Error != "shop is not deployed" {
+		t.Logf("expected failed deletion to be audited, actual = %+v", failed)
+		t.FailNow()
+	}
+	if rs := audit("?endpoint=shop&source=cluster&limit=1"); len(rs) != 1 || rs[0].Id != failed.Id {
+		t.Logf("expected audit to be filtered, actual = %+v", rs)
+		t.FailNow()
+	}
+	if w := send(http.MethodGet, "/api/audit?since=yesterday", "secret"); w.Code != http.StatusBadRequest {
+		t.Logf("expected invalid query to be rejected, actual = %d", w.Code)
+		t.FailNow()
+	}
+}
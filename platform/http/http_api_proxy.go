@@ -1 +1,386 @@
 package http
+
+import (
+	"encoding/json"
+	"errors"
+	"goruf/platform/core"
+	"goruf/platform/database"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ProxiesOf rebuilds the proxies of a release together with their routes.
+func ProxiesOf(r database.Release) []core.Proxy {
+	routes := make(map[string][]database.ProxyRoute)
+	for _, route := range r.Routes {
+		routes[route.ProxyId] = append(routes[route.ProxyId], route)
+	}
+	limits := make(map[string][]database.ProxyRateLimit)
+	for _, l := range r.RateLimits {
+		limits[l.ProxyId] = append(limits[l.ProxyId], l)
+	}
+	var rs []core.Proxy
+	for _, p := range r.Proxies {
+		proxy := core.Proxy{
+			BackendCode:    p.BackendCode,
+			BackendAddress: p.BackendAddress,
+			Secure:         p.Secure,
+			Upstreams:      p.Upstreams,
+		}
+		if p.Strategy != "" || p.HashHeader != "" || p.HashCookie != "" {
+			proxy.LoadBalancer = &core.LoadBalancer{
+				Strategy:   p.Strategy,
+				HashHeader: p.HashHeader,
+				HashCookie: p.HashCookie,
+			}
+		}
+		if p.HealthPath != "" {
+			proxy.HealthCheck = &core.HealthCheck{
+				Path:               p.HealthPath,
+				Interval:           core.Duration(p.HealthInterval),
+				Timeout:            core.Duration(p.HealthTimeout),
+				HealthyThreshold:   p.HealthyThreshold,
+				UnhealthyThreshold: p.UnhealthyThreshold,
+			}
+		}
+		if p.EjectMaxFailures > 0 {
+			proxy.Ejection = &core.Ejection{
+				MaxFailures: p.EjectMaxFailures,
+				Duration:    core.Duration(p.EjectDuration),
+			}
+		}
+		if p.ConnectTimeout > 0 || p.ResponseTimeout > 0 {
+			proxy.Timeouts = &core.Timeouts{
+				Connect:  core.Duration(p.ConnectTimeout),
+				Response: core.Duration(p.ResponseTimeout),
+			}
+		}
+		if p.RetryAttempts > 0 {
+			proxy.Retry = &core.Retry{
+				Attempts:   p.RetryAttempts,
+				Backoff:    core.Duration(p.RetryBackoff),
+				MaxBackoff: core.Duration(p.RetryMaxBackoff),
+			}
+		}
+		if p.BreakerFailures > 0 {
+			proxy.CircuitBreaker = &core.CircuitBreaker{
+				FailureThreshold: p.BreakerFailures,
+				OpenDuration:     core.Duration(p.BreakerOpenDuration),
+				HalfOpenRequests: p.BreakerHalfOpenRequests,
+			}
+		}
+		rows := routes[p.Id]
+		sort.SliceStable(rows, func(i, j int) bool {
+			return rows[i].Position < rows[j].Position
+		})
+		for _, row := range rows {
+			proxy.Routes = append(proxy.Routes, core.Route{
+				Prefix:        row.Prefix,
+				StripPrefix:   row.StripPrefix,
+				ReplacePrefix: row.ReplacePrefix,
+				Methods:       row.Methods,
+				SetHeaders:    row.SetHeaders,
+				RemoveHeaders: row.RemoveHeaders,
+				Host:          row.Host,
+				Access:        AccessOf(row.AccessRoles, row.AccessClaims),
+			})
+		}
+		if p.AuthForward != "" || p.AuthAudience != "" || len(p.AuthScopes) > 0 {
+			proxy.Auth = &core.ProxyAuth{Forward: p.AuthForward, Audience: p.AuthAudience, Scopes: p.AuthScopes}
+		}
+		proxy.Access = AccessOf(p.AccessRoles, p.AccessClaims)
+		ls := limits[p.Id]
+		sort.SliceStable(ls, func(i, j int) bool {
+			return ls[i].Position < ls[j].Position
+		})
+		for _, l := range ls {
+			proxy.RateLimits = append(proxy.RateLimits, core.RateLimit{
+				Key:      l.Key,
+				Requests: l.Requests,
+				Period:   core.Duration(l.Period),
+				Burst:    l.Burst,
+			})
+		}
+		rs = append(rs, proxy)
+	}
+	return rs
+}
+
+// apiRoute is a route of the API proxy bound to the upstreams it forwards to.
+type apiRoute struct {
+	core.Route
+	deployment string
+	backend    string
+	rateLimits []core.RateLimit
+	// access is the access of the proxy, the route has its own in Route.Access.
+	access *core.Access
+	// forward is the token forward mode, credentials its settings.
+	forward     string
+	credentials core.ProxyAuth
+	pool        *upstreamPool
+	proxy       *httputil.ReverseProxy
+}
+
+// routeTable caches the routes of the active releases, it is rebuilt when
+// the database generation changes. Upstream pools survive a rebuild when the
+// settings of their proxy did not change, so they keep their health.
+type routeTable struct {
+	mu         sync.Mutex
+	loaded     bool
+	generation uint64
+	routes     []*apiRoute
+	pools      map[string]*upstreamPool
+}
+
+var apiRoutes = &routeTable{}
+
+func (t *routeTable) get() []*apiRoute {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if g := database.Generation(); !t.loaded || g != t.generation {
+		t.routes = t.build()
+		t.generation, t.loaded = g, true
+	}
+	return t.routes
+}
+
+// watch rebuilds t whenever the active releases change, so the upstream
+// pools and their health checks run before the first request arrives.
+func (t *routeTable) watch(changes <-chan struct{}) {
+	t.get()
+	for range changes {
+		t.get()
+	}
+}
+
+// build returns the routes of all active deployments, longest prefix first
+// so the most specific route wins.
+func (t *routeTable) build() []*apiRoute {
+	var rs []*apiRoute
+	pools := make(map[string]*upstreamPool)
+	for _, r := range database.ActiveReleases() {
+		for _, p := range ProxiesOf(r) {
+			key := r.Deployment.Endpoint + "/" + p.BackendCode
+			pool, err := t.pool(key, p)
+			if err != nil {
+				log.Error().Err(err).
+					Str("endpoint", r.Deployment.Endpoint).
+					Str("backend", p.BackendCode).
+					Msg("invalid proxy")
+				continue
+			}
+			pools[key] = pool
+			for _, route := range core.RoutesOf(p) {
+				rt := &apiRoute{
+					Route:      route,
+					deployment: r.Deployment.Endpoint,
+					backend:    p.BackendCode,
+					rateLimits: p.RateLimits,
+					access:     p.Access,
+					forward:    core.ForwardOf(p),
+					pool:       pool,
+				}
+				if p.Auth != nil {
+					rt.credentials = *p.Auth
+				}
+				rt.proxy = &httputil.ReverseProxy{
+					Rewrite:      rt.rewrite,
+					Transport:    pool,
+					ErrorHandler: rt.proxyError,
+				}
+				rs = append(rs, rt)
+			}
+		}
+	}
+	for key, pool := range t.pools {
+		if pools[key] != pool {
+			pool.Close()
+		}
+	}
+	t.pools = pools
+	sort.SliceStable(rs, func(i, j int) bool {
+		return len(strings.TrimSuffix(rs[i].Prefix, "/")) > len(strings.TrimSuffix(rs[j].Prefix, "/"))
+	})
+	return rs
+}
+
+// pool returns the pool of the previous build for key if p did not change.
+func (t *routeTable) pool(key string, p core.Proxy) (*upstreamPool, error) {
+	settings := p
+	settings.Routes, settings.RateLimits, settings.Access = nil, nil, nil
+	if pool, ok := t.pools[key]; ok && reflect.DeepEqual(pool.proxy, settings) {
+		return pool, nil
+	}
+	return newUpstreamPool(settings)
+}
+
+// proxyStatus is a proxy of an active deployment with the state of its upstreams.
+type proxyStatus struct {
+	Endpoint  string           `json:"endpoint"`
+	Proxy     core.Proxy       `json:"proxy"`
+	Upstreams []upstreamStatus `json:"upstreams"`
+}
+
+type upstreamStatus struct {
+	Address      string     `json:"address"`
+	Healthy      bool       `json:"healthy"`
+	EjectedUntil *time.Time `json:"ejectedUntil,omitempty"`
+	Circuit      string     `json:"circuit,omitempty"`
+	Active       int64      `json:"active"`
+}
+
+func (t *routeTable) statuses() []proxyStatus {
+	t.get()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	rs := make([]proxyStatus, 0)
+	now := time.Now()
+	for _, r := range database.ActiveReleases() {
+		for _, p := range ProxiesOf(r) {
+			status := proxyStatus{Endpoint: r.Deployment.Endpoint, Proxy: p}
+			if pool, ok := t.pools[r.Deployment.Endpoint+"/"+p.BackendCode]; ok {
+				for _, u := range pool.upstreams {
+					us := upstreamStatus{Address: u.url.String(), Circuit: u.breaker.String(), Active: u.active.Load()}
+					u.mu.Lock()
+					us.Healthy = u.healthy
+					if now.Before(u.ejectedUntil) {
+						until := u.ejectedUntil
+						us.EjectedUntil = &until
+					}
+					u.mu.Unlock()
+					status.Upstreams = append(status.Upstreams, us)
+				}
+			}
+			rs = append(rs, status)
+		}
+	}
+	return rs
+}
+
+// serveProxies lists the proxies of the active deployments with their
+// settings and the state of their upstreams.
+func serveProxies(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(apiRoutes.statuses())
+}
+
+func findRoute(path string) (*apiRoute, bool) {
+	for _, rt := range apiRoutes.get() {
+		if rt.Match(path) {
+			return rt, true
+		}
+	}
+	return nil, false
+}
+
+// cleanPath resolves the dot segments of p and keeps its trailing slash.
+func cleanPath(p string) string {
+	rs := path.Clean("/" + p)
+	if strings.HasSuffix(p, "/") && rs != "/" {
+		rs += "/"
+	}
+	return rs
+}
+
+// apiProxy forwards API requests to the backend of the route matching their path.
+func apiProxy(w http.ResponseWriter, r *http.Request) {
+	// routes, their access and their rewrite must see the path the backend
+	// resolves, e.g. /api/orders/x/../admin is /api/orders/admin
+	if p := cleanPath(r.URL.Path); p != r.URL.Path {
+		r = r.Clone(r.Context())
+		r.URL.Path, r.URL.RawPath = p, ""
+	}
+	rt, ok := findRoute(r.URL.Path)
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if !rt.Allows(r.Method) {
+		w.Header().Set("Allow", strings.ToUpper(strings.Join(rt.Methods, ", ")))
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	res := Resource{Kind: ResourceApi, Deployment: rt.deployment, Name: rt.backend, Path: r.URL.Path}
+	if !allowed(r, res, rt.access, rt.Access) {
+		forbidden(w)
+		return
+	}
+	if !rt.limit(w, r) {
+		return
+	}
+	r, ok = rt.withForwardToken(w, r)
+	if !ok {
+		return
+	}
+	rt.proxy.ServeHTTP(w, r)
+}
+
+// rewrite prepares the forwarded request, the upstream is picked by the
+// transport of the pool.
+func (rt *apiRoute) rewrite(pr *httputil.ProxyRequest) {
+	pr.Out.URL.Path = rt.Rewrite(pr.In.URL.Path)
+	pr.Out.URL.RawPath = ""
+	pr.Out.Host = rt.Host
+	pr.SetXForwarded()
+	pr.Out.Header.Del("X-Request-Type")
+	forwardCredentials(pr)
+	for _, name := range rt.RemoveHeaders {
+		pr.Out.Header.Del(name)
+	}
+	for name, value := range rt.SetHeaders {
+		pr.Out.Header.Set(name, value)
+	}
+}
+
+// errorBody is the JSON body of the errors answered to API requests.
+type errorBody struct {
+	Error      string `json:"error"`
+	Message    string `json:"message"`
+	Backend    string `json:"backend,omitempty"`
+	RetryAfter int    `json:"retryAfter,omitempty"`
+}
+
+func (rt *apiRoute) proxyError(w http.ResponseWriter, r *http.Request, err error) {
+	body := errorBody{Error: "bad_gateway", Message: "backend is unavailable", Backend: rt.backend}
+	status := http.StatusBadGateway
+	var open *circuitOpenError
+	var timeout net.Error
+	switch {
+	case errors.As(err, &open):
+		status, body.Error, body.Message = http.StatusServiceUnavailable, "circuit_open", "backend is failing, retry later"
+		body.RetryAfter = int(math.Ceil(open.retryAfter.Seconds()))
+		if body.RetryAfter < 1 {
+			body.RetryAfter = 1
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(body.RetryAfter))
+	case errors.Is(err, errNoUpstream):
+		status, body.Error, body.Message = http.StatusServiceUnavailable, "no_upstream", "no healthy upstream"
+	case errors.As(err, &timeout) && timeout.Timeout():
+		status, body.Error, body.Message = http.StatusGatewayTimeout, "upstream_timeout", "backend did not answer in time"
+	}
+	log.Error().Err(err).
+		Str("endpoint", rt.deployment).
+		Str("backend", rt.backend).
+		Str("path", r.URL.Path).
+		Int("status", status).
+		Msg("failed to proxy api request")
+	writeError(w, status, body)
+}
+
+func writeError(w http.ResponseWriter, status int, body errorBody) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,95 @@
+package http
+
+import (
+	"goruf/platform/database"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestApiProxy(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Path", r.URL.Path)
+		w.Header().Set("X-Host", r.Host)
+		w.Header().Set("X-Tenant", r.Header.Get("X-Tenant"))
+		w.Header().Set("X-Cookie", r.Header.Get("Cookie"))
+	}))
+	defer backend.Close()
+	database.ConnectDatabase()
+	database.SaveRelease(database.Release{
+		Deployment: database.Deployment{Endpoint: "orders"},
+		Proxies:    []database.Proxy{{Id: "p1", BackendCode: "orders", BackendAddress: backend.URL}},
+		Routes: []database.ProxyRoute{{
+			ProxyId:       "p1",
+			Prefix:        "/api/orders",
+			ReplacePrefix: "/v1",
+			Methods:       []string{"GET"},
+			SetHeaders:    map[string]string{"X-Tenant": "acme"},
+			RemoveHeaders: []string{"Cookie"},
+			Host:          "orders.internal",
+		}},
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/orders/42", nil)
+	req.Header.Set("Cookie", "session=1")
+	w := httptest.NewRecorder()
+	apiProxy(w, req)
+	rs := w.Result()
+	io.Copy(io.Discard, rs.Body)
+	expected := map[string]string{"X-Path": "/v1/42", "X-Host": "orders.internal", "X-Tenant": "acme", "X-Cookie": ""}
+	for name, value := range expected {
+		if rs.Header.Get(name) != value {
+			t.Logf("expected %s = %q, actual = %q", name, value, rs.Header.Get(name))
+			t.FailNow()
+		}
+	}
+
+	w = httptest.NewRecorder()
+	apiProxy(w, httptest.NewRequest(http.MethodPost, "/api/orders/42", nil))
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "GET" {
+		t.Logf("expected POST to be rejected, actual = %d", w.Code)
+		t.FailNow()
+	}
+
+	w = httptest.NewRecorder()
+	apiProxy(w, httptest.NewRequest(http.MethodGet, "/api/invoices", nil))
+	if w.Code != http.StatusNotFound {
+		t.Logf("expected unknown route to be 404, actual = %d", w.Code)
+		t.FailNow()
+	}
+}
+
+func TestApiProxyDotSegments(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Path", r.URL.Path)
+	}))
+	defer backend.Close()
+	database.ConnectDatabase()
+	database.SaveRelease(database.Release{
+		Deployment: database.Deployment{Endpoint: "orders"},
+		Proxies:    []database.Proxy{{Id: "p1", BackendCode: "orders", BackendAddress: backend.URL}},
+		Routes: []database.ProxyRoute{
+			{ProxyId: "p1", Prefix: "/api/orders/admin", AccessRoles: []string{"admin"}},
+			{ProxyId: "p1", Position: 1, Prefix: "/api/orders", ReplacePrefix: "/v1"},
+		},
+	})
+	router := newRouter()
+	for _, p := range []string{"/api/orders/admin/users", "/api/orders/x/../admin/users", "/api/orders/x/%2e%2e/admin/users", "/api/orders/./admin/users"} {
+		req := httptest.NewRequest(http.MethodGet, p, nil)
+		req.Header.Set("X-Request-Type", "api")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusForbidden {
+			t.Logf("expected %s to be forbidden, actual = %d %s", p, w.Code, w.Header().Get("X-Path"))
+			t.FailNow()
+		}
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api/orders/x/../42/", nil)
+	w := httptest.NewRecorder()
+	apiProxy(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("X-Path") != "/v1/42/" {
+		t.Logf("expected cleaned path with trailing slash, actual = %d %s", w.Code, w.Header().Get("X-Path"))
+		t.FailNow()
+	}
+}
@@ -0,0 +1,539 @@
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"goruf/platform/core"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// refreshMargin refreshes access tokens shortly before they expire so
+	// they do not expire on the way to a backend.
+	refreshMargin = 30 * time.Second
+	// loginTtl bounds the time a user has to sign in at the provider.
+	loginTtl = 10 * time.Minute
+	// refreshReuse is how long the outcome of a refresh is shared with the
+	// concurrent requests still holding the previous refresh token.
+	refreshReuse = 30 * time.Second
+	// maxCachedTokens bounds the cache of exchanged tokens, the tokens
+	// closest to expiry are dropped first.
+	maxCachedTokens = 1024
+)
+
+const (
+	grantAuthorizationCode = "authorization_code"
+	grantRefreshToken      = "refresh_token"
+	grantTokenExchange     = "urn:ietf:params:oauth:grant-type:token-exchange"
+	tokenTypeAccessToken   = "urn:ietf:params:oauth:token-type:access_token"
+)
+
+// AuthOptions configures the sign in of users at an OpenID provider,
+// authentication is disabled when Issuer is empty.
+type AuthOptions struct {
+	Issuer       string
+	ClientId     string
+	ClientSecret string
+	// RedirectUrl is the absolute URL of /auth/callback as seen by browsers.
+	RedirectUrl string
+	Scopes      []string
+	// SessionKey encrypts the session cookies, a random key is used when it
+	// is empty so sessions do not survive a restart.
+	SessionKey []byte
+	SessionTtl time.Duration
+	Cookie     string
+	// Claims of the ID token kept in the session.
+	Claims []string
+}
+
+func DefaultAuthOptions() AuthOptions {
+	return AuthOptions{
+		Scopes:     []string{"openid", "profile", "email"},
+		SessionTtl: 12 * time.Hour,
+		Cookie:     "mfe_session",
+		Claims:     []string{"roles", "groups"},
+	}
+}
+
+type cachedToken struct {
+	token  string
+	expiry time.Time
+}
+
+type refreshCall struct {
+	done  chan struct{}
+	token *tokenResponse
+	err   error
+}
+
+type authenticator struct {
+	opts     AuthOptions
+	provider *provider
+	codec    *cookieCodec
+	secure   bool
+
+	mu         sync.Mutex
+	exchanged  map[string]cachedToken
+	refreshing map[string]*refreshCall
+}
+
+// auth signs users in, it is nil when authentication is disabled.
+var auth *authenticator
+
+func newAuthenticator(opts AuthOptions) (*authenticator, error) {
+	if opts.ClientId == "" {
+		return nil, errors.New("client id is required")
+	}
+	u, err := url.Parse(opts.RedirectUrl)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("redirect url %q is not an absolute http url", opts.RedirectUrl)
+	}
+	key := opts.SessionKey
+	if len(key) == 0 {
+		log.Warn().Msg("no session key, sessions end when the server restarts")
+		key = make([]byte, 32)
+		rand.Read(key)
+	}
+	codec, err := newCookieCodec(key)
+	if err != nil {
+		return nil, err
+	}
+	return &authenticator{
+		opts:       opts,
+		provider:   newProvider(opts.Issuer, opts.ClientId, opts.ClientSecret),
+		codec:      codec,
+		secure:     u.Scheme == "https",
+		exchanged:  make(map[string]cachedToken),
+		refreshing: make(map[string]*refreshCall),
+	}, nil
+}
+
+func (a *authenticator) stateCookie() string {
+	return a.opts.Cookie + "_auth"
+}
+
+type sessionKey struct{}
+
+// sessionOf returns the session of the signed in user of r, nil when anonymous.
+func sessionOf(r *http.Request) *session {
+	s, _ := r.Context().Value(sessionKey{}).(*session)
+	return s
+}
+
+// LoadSession attaches the session of the signed in user to the request,
+// refreshing the access token when it is about to expire.
+func LoadSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth != nil {
+			if s := auth.session(w, r); s != nil {
+				ctx := context.WithValue(r.Context(), sessionKey{}, s)
+				r = r.WithContext(WithUser(ctx, s.Subject))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticated reports whether r may proceed, it otherwise answers 401 to
+// API requests and sends browsers to the sign in.
+func authenticated(w http.ResponseWriter, r *http.Request, api bool) bool {
+	if auth == nil || sessionOf(r) != nil {
+		return true
+	}
+	if api {
+		writeError(w, http.StatusUnauthorized, errorBody{Error: "unauthenticated", Message: "sign in required"})
+		return false
+	}
+	http.Redirect(w, r, "/auth/login?rd="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+	return false
+}
+
+// requireSession protects the pages served by h.
+func requireSession(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if authenticated(w, r, false) {
+			h(w, r)
+		}
+	}
+}
+
+func (a *authenticator) session(w http.ResponseWriter, r *http.Request) *session {
+	value, ok := readCookie(r, a.opts.Cookie)
+	if !ok {
+		return nil
+	}
+	s := &session{}
+	now := time.Now()
+	if err := a.codec.open(a.opts.Cookie, value, s); err != nil || now.After(s.Expiry) {
+		writeCookie(w, r, a.opts.Cookie, "", 0, a.secure)
+		return nil
+	}
+	if now.Add(refreshMargin).Before(s.TokenExpiry) {
+		return s
+	}
+	if s.RefreshToken == "" {
+		writeCookie(w, r, a.opts.Cookie, "", 0, a.secure)
+		return nil
+	}
+	t, err := a.refresh(r.Context(), s.RefreshToken)
+	if err != nil {
+		log.Warn().Err(err).Str("subject", s.Subject).Msg("failed to refresh access token")
+		writeCookie(w, r, a.opts.Cookie, "", 0, a.secure)
+		return nil
+	}
+	s.AccessToken, s.TokenExpiry = t.AccessToken, tokenExpiry(t, now, s.Expiry)
+	if t.RefreshToken != "" {
+		s.RefreshToken = t.RefreshToken
+	}
+	if err := a.save(w, r, s, now); err != nil {
+		log.Error().Err(err).Msg("failed to save session")
+	}
+	return s
+}
+
+// refresh redeems refreshToken once for all the concurrent requests of a
+// session, providers rotating refresh tokens reject a second redemption.
+func (a *authenticator) refresh(ctx context.Context, refreshToken string) (*tokenResponse, error) {
+	a.mu.Lock()
+	call, ok := a.refreshing[refreshToken]
+	if !ok {
+		call = &refreshCall{done: make(chan struct{})}
+		a.refreshing[refreshToken] = call
+	}
+	a.mu.Unlock()
+	if !ok {
+		call.token, call.err = a.provider.token(context.WithoutCancel(ctx), url.Values{
+			"grant_type":    {grantRefreshToken},
+			"refresh_token": {refreshToken},
+		})
+		close(call.done)
+		time.AfterFunc(refreshReuse, func() {
+			a.mu.Lock()
+			defer a.mu.Unlock()
+			delete(a.refreshing, refreshToken)
+		})
+	}
+	select {
+	case <-call.done:
+		return call.token, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// tokenExpiry returns when the access token of t expires, the session
+// expiry when the provider does not tell.
+func tokenExpiry(t *tokenResponse, now time.Time, fallback time.Time) time.Time {
+	if t.ExpiresIn <= 0 {
+		return fallback
+	}
+	return now.Add(time.Duration(t.ExpiresIn) * time.Second)
+}
+
+func (a *authenticator) save(w http.ResponseWriter, r *http.Request, s *session, now time.Time) error {
+	value, err := a.codec.seal(a.opts.Cookie, s)
+	if err != nil {
+		return err
+	}
+	writeCookie(w, r, a.opts.Cookie, value, s.Expiry.Sub(now), a.secure)
+	return nil
+}
+
+func authRouter() http.Handler {
+	r := chi.NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if auth == nil {
+				http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Cache-Control", "no-store")
+			next.ServeHTTP(w, r)
+		})
+	})
+	r.Get("/login", login)
+	r.Get("/callback", callback)
+	r.Post("/logout", logout)
+	r.Get("/userinfo", userinfo)
+	return r
+}
+
+// localRedirect returns rd when it is a path of the platform, so the sign in
+// cannot be used to send users to another site.
+func localRedirect(rd string) string {
+	u, err := url.Parse(rd)
+	if err != nil || u.Scheme != "" || u.Host != "" || !strings.HasPrefix(rd, "/") ||
+		strings.HasPrefix(rd, "//") || strings.HasPrefix(rd, "/\\") {
+		return "/"
+	}
+	return rd
+}
+
+// login starts the authorization code flow with PKCE at the provider.
+func login(w http.ResponseWriter, r *http.Request) {
+	m, err := auth.provider.discover(r.Context())
+	if err != nil {
+		log.Error().Err(err).Msg("")
+		http.Error(w, "identity provider is unavailable", http.StatusBadGateway)
+		return
+	}
+	st := authState{
+		State:    randomString(24),
+		Nonce:    randomString(24),
+		Verifier: randomString(48),
+		Redirect: localRedirect(r.URL.Query().Get("rd")),
+	}
+	value, err := auth.codec.seal(auth.stateCookie(), st)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	writeCookie(w, r, auth.stateCookie(), value, loginTtl, auth.secure)
+	u, err := url.Parse(m.AuthorizationEndpoint)
+	if err != nil {
+		http.Error(w, "identity provider is misconfigured", http.StatusBadGateway)
+		return
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", auth.opts.ClientId)
+	q.Set("redirect_uri", auth.opts.RedirectUrl)
+	q.Set("scope", strings.Join(auth.opts.Scopes, " "))
+	q.Set("state", st.State)
+	q.Set("nonce", st.Nonce)
+	q.Set("code_challenge", pkceChallenge(st.Verifier))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+// callback redeems the authorization code and opens the session.
+func callback(w http.ResponseWriter, r *http.Request) {
+	st := authState{}
+	value, ok := readCookie(r, auth.stateCookie())
+	if !ok || auth.codec.open(auth.stateCookie(), value, &st) != nil {
+		http.Error(w, "sign in expired, please try again", http.StatusBadRequest)
+		return
+	}
+	writeCookie(w, r, auth.stateCookie(), "", 0, auth.secure)
+	q := r.URL.Query()
+	if subtle.ConstantTimeCompare([]byte(q.Get("state")), []byte(st.State)) != 1 {
+		http.Error(w, "invalid sign in state", http.StatusBadRequest)
+		return
+	}
+	if e := q.Get("error"); e != "" {
+		log.Warn().Str("error", e).Str("description", q.Get("error_description")).Msg("sign in failed")
+		http.Error(w, "sign in failed: "+e, http.StatusUnauthorized)
+		return
+	}
+	now := time.Now()
+	t, err := auth.provider.token(r.Context(), url.Values{
+		"grant_type":    {grantAuthorizationCode},
+		"code":          {q.Get("code")},
+		"redirect_uri":  {auth.opts.RedirectUrl},
+		"code_verifier": {st.Verifier},
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to redeem authorization code")
+		http.Error(w, "sign in failed", http.StatusBadGateway)
+		return
+	}
+	claims, err := auth.provider.verify(r.Context(), t.IdToken, st.Nonce, now)
+	if err != nil {
+		log.Warn().Err(err).Msg("invalid id token")
+		http.Error(w, "sign in failed", http.StatusUnauthorized)
+		return
+	}
+	s := &session{
+		Claims:       make(map[string]any),
+		AccessToken:  t.AccessToken,
+		RefreshToken: t.RefreshToken,
+		Expiry:       now.Add(auth.opts.SessionTtl),
+	}
+	s.Subject, _ = claims["sub"].(string)
+	s.Name, _ = claims["name"].(string)
+	s.Email, _ = claims["email"].(string)
+	for _, name := range auth.opts.Claims {
+		if v, ok := claims[name]; ok {
+			s.Claims[name] = v
+		}
+	}
+	s.TokenExpiry = tokenExpiry(t, now, s.Expiry)
+	if err := auth.save(w, r, s, now); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	log.Info().Str("subject", s.Subject).Msg("user signed in")
+	http.Redirect(w, r, st.Redirect, http.StatusFound)
+}
+
+// logout ends the session and, when the provider supports it, the session
+// at the provider. It only accepts posts of the pages of the platform so
+// another site cannot sign users out.
+func logout(w http.ResponseWriter, r *http.Request) {
+	if !auth.sameOrigin(r) {
+		http.Error(w, "cross-site request rejected", http.StatusForbidden)
+		return
+	}
+	writeCookie(w, r, auth.opts.Cookie, "", 0, auth.secure)
+	target := "/"
+	if m, err := auth.provider.discover(r.Context()); err == nil && m.EndSessionEndpoint != "" {
+		if u, err := url.Parse(m.EndSessionEndpoint); err == nil {
+			home, _ := url.Parse(auth.opts.RedirectUrl)
+			q := u.Query()
+			q.Set("client_id", auth.opts.ClientId)
+			q.Set("post_logout_redirect_uri", home.Scheme+"://"+home.Host+"/")
+			u.RawQuery = q.Encode()
+			target = u.String()
+		}
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// sameOrigin reports whether r comes from a page of the platform, according
+// to its Origin header or else its Referer.
+func (a *authenticator) sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		origin = r.Header.Get("Referer")
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	home, err := url.Parse(a.opts.RedirectUrl)
+	return err == nil && u.Scheme == home.Scheme && u.Host == home.Host
+}
+
+// userinfo describes the signed in user to the shells.
+func userinfo(w http.ResponseWriter, r *http.Request) {
+	s := sessionOf(r)
+	if s == nil {
+		writeError(w, http.StatusUnauthorized, errorBody{Error: "unauthenticated", Message: "sign in required"})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"sub":    s.Subject,
+		"name":   s.Name,
+		"email":  s.Email,
+		"claims": s.Claims,
+		"expiry": s.Expiry,
+	})
+}
+
+type forwardTokenKey struct{}
+
+// withForwardToken returns r carrying the token forwarded to the backend of
+// rt, it answers 502 and returns false when the token cannot be exchanged.
+func (rt *apiRoute) withForwardToken(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	s := sessionOf(r)
+	if auth == nil || s == nil || rt.forward == core.ForwardNone {
+		return r, true
+	}
+	token := s.AccessToken
+	if rt.forward == core.ForwardExchange {
+		var err error
+		if token, err = auth.exchange(r.Context(), s, rt.credentials); err != nil {
+			log.Error().Err(err).Str("backend", rt.backend).Str("subject", s.Subject).Msg("failed to exchange token")
+			writeError(w, http.StatusBadGateway, errorBody{
+				Error:   "token_exchange_failed",
+				Message: "no token for the backend",
+				Backend: rt.backend,
+			})
+			return r, false
+		}
+	}
+	return r.WithContext(context.WithValue(r.Context(), forwardTokenKey{}, token)), true
+}
+
+// exchange returns a token for the audience of p exchanged for the access
+// token of s, tokens are cached until they expire.
+func (a *authenticator) exchange(ctx context.Context, s *session, p core.ProxyAuth) (string, error) {
+	sum := sha256.Sum256([]byte(s.AccessToken))
+	key := hex.EncodeToString(sum[:]) + "|" + p.Audience + "|" + strings.Join(p.Scopes, " ")
+	now := time.Now()
+	a.mu.Lock()
+	cached, ok := a.exchanged[key]
+	a.mu.Unlock()
+	if ok && now.Add(refreshMargin).Before(cached.expiry) {
+		return cached.token, nil
+	}
+	form := url.Values{
+		"grant_type":           {grantTokenExchange},
+		"subject_token":        {s.AccessToken},
+		"subject_token_type":   {tokenTypeAccessToken},
+		"requested_token_type": {tokenTypeAccessToken},
+		"audience":             {p.Audience},
+	}
+	if len(p.Scopes) > 0 {
+		form.Set("scope", strings.Join(p.Scopes, " "))
+	}
+	t, err := a.provider.token(ctx, form)
+	if err != nil {
+		return "", err
+	}
+	a.cache(key, cachedToken{token: t.AccessToken, expiry: tokenExpiry(t, now, s.TokenExpiry)}, now)
+	return t.AccessToken, nil
+}
+
+// cache keeps c under key, once the cache is full it drops the expired
+// tokens and then the ones closest to expiry.
+func (a *authenticator) cache(key string, c cachedToken, now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.exchanged[key]; !ok && len(a.exchanged) >= maxCachedTokens {
+		keys := make([]string, 0, len(a.exchanged))
+		for k, cached := range a.exchanged {
+			if now.After(cached.expiry) {
+				delete(a.exchanged, k)
+			} else {
+				keys = append(keys, k)
+			}
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return a.exchanged[keys[i]].expiry.Before(a.exchanged[keys[j]].expiry)
+		})
+		for _, k := range keys[:max(0, len(keys)-maxCachedTokens+1)] {
+			delete(a.exchanged, k)
+		}
+	}
+	a.exchanged[key] = c
+}
+
+// forwardCredentials sets the token of the user on the forwarded request and
+// removes the session cookies, backends never see them.
+func forwardCredentials(pr *httputil.ProxyRequest) {
+	if auth == nil {
+		return
+	}
+	if token, ok := pr.In.Context().Value(forwardTokenKey{}).(string); ok {
+		pr.Out.Header.Set("Authorization", "Bearer "+token)
+	}
+	cookies := pr.Out.Cookies()
+	pr.Out.Header.Del("Cookie")
+	kept := make([]string, 0, len(cookies))
+	for _, c := range cookies {
+		if !isSessionCookie(c.Name, auth.opts) {
+			kept = append(kept, c.String())
+		}
+	}
+	if len(kept) > 0 {
+		pr.Out.Header.Set("Cookie", strings.Join(kept, "; "))
+	}
+}
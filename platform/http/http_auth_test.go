@@ -0,0 +1,312 @@
+package http
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"goruf/platform/core"
+	"goruf/platform/database"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testProvider is a stand-in OpenID provider issuing tokens to any user.
+type testProvider struct {
+	*httptest.Server
+	key       *rsa.PrivateKey
+	expiresIn atomic.Int64
+	issued    atomic.Int32
+
+	mu    sync.Mutex
+	codes map[string]url.Values
+}
+
+func newTestProvider(t *testing.T) *testProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	p := &testProvider{key: key, codes: make(map[string]url.Values)}
+	p.expiresIn.Store(3600)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(providerMetadata{
+			Issuer:                p.URL,
+			AuthorizationEndpoint: p.URL + "/authorize",
+			TokenEndpoint:         p.URL + "/token",
+			JwksUri:               p.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []jwk{{
+			Kid: "k1",
+			Kty: "RSA",
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256" || q.Get("client_id") != "platform" {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		code := randomString(16)
+		p.mu.Lock()
+		p.codes[code] = q
+		p.mu.Unlock()
+		http.Redirect(w, r, q.Get("redirect_uri")+"?code="+code+"&state="+url.QueryEscape(q.Get("state")), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, _ := r.BasicAuth(); id != "platform" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(tokenError{Code: "invalid_client"})
+			return
+		}
+		r.ParseForm()
+		n := p.issued.Add(1)
+		rs := tokenResponse{
+			AccessToken:  fmt.Sprintf("access-%d", n),
+			RefreshToken: fmt.Sprintf("refresh-%d", n),
+			ExpiresIn:    p.expiresIn.Load(),
+		}
+		switch r.Form.Get("grant_type") {
+		case grantAuthorizationCode:
+			p.mu.Lock()
+			q, ok := p.codes[r.Form.Get("code")]
+			delete(p.codes, r.Form.Get("code"))
+			p.mu.Unlock()
+			if !ok || pkceChallenge(r.Form.Get("code_verifier")) != q.Get("code_challenge") {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(tokenError{Code: "invalid_grant"})
+				return
+			}
+			rs.IdToken = p.sign(t, map[string]any{
+				"iss":   p.URL,
+				"aud":   "platform",
+				"sub":   "alice",
+				"name":  "Alice",
+				"exp":   time.Now().Add(time.Hour).Unix(),
+				"nonce": q.Get("nonce"),
+				"roles": []string{"admin"},
+			})
+		case grantRefreshToken:
+		case grantTokenExchange:
+			rs.AccessToken = "exchanged-" + r.Form.Get("audience") + "-" + r.Form.Get("subject_token")
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(tokenError{Code: "unsupported_grant_type"})
+			return
+		}
+		json.NewEncoder(w).Encode(rs)
+	})
+	p.Server = httptest.NewServer(mux)
+	return p
+}
+
+func (p *testProvider) sign(t *testing.T, claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "k1", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestAuthentication(t *testing.T) {
+	provider := newTestProvider(t)
+	defer provider.Close()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Authorization", r.Header.Get("Authorization"))
+		w.Header().Set("X-Cookie", r.Header.Get("Cookie"))
+	}))
+	defer backend.Close()
+	database.ConnectDatabase()
+	database.SaveRelease(database.Release{
+		Deployment: database.Deployment{Endpoint: "portal", Kind: string(core.KindContainer)},
+		Proxies: []database.Proxy{
+			{Id: "p1", BackendCode: "orders", BackendAddress: backend.URL},
+			{Id: "p2", BackendCode: "billing", BackendAddress: backend.URL, AuthForward: core.ForwardExchange, AuthAudience: "billing-api"},
+		},
+	})
+	platform := httptest.NewServer(newRouter())
+	defer platform.Close()
+	opts := DefaultAuthOptions()
+	opts.Issuer, opts.ClientId, opts.ClientSecret = provider.URL, "platform", "secret"
+	opts.RedirectUrl = platform.URL + "/auth/callback"
+	opts.SessionKey = []byte("test session key")
+	a, err := newAuthenticator(opts)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	auth = a
+	defer func() { auth = nil }()
+
+	anonymous := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	rs, err := anonymous.Get(platform.URL + "/portal/")
+	if err != nil || rs.StatusCode != http.StatusFound || rs.Header.Get("Location") != "/auth/login?rd=%2Fportal%2F" {
+		t.Logf("expected shell to redirect to sign in, actual = %v %v", rs, err)
+		t.FailNow()
+	}
+	api := func(c *http.Client, path string) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, platform.URL+path, nil)
+		req.Header.Set("X-Request-Type", "api")
+		rs, err := c.Do(req)
+		if err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		io.Copy(io.Discard, rs.Body)
+		rs.Body.Close()
+		return rs
+	}
+	if rs := api(anonymous, "/api/orders/1"); rs.StatusCode != http.StatusUnauthorized {
+		t.Logf("expected anonymous API request to be rejected, actual = %d", rs.StatusCode)
+		t.FailNow()
+	}
+
+	// the first access token expires within the refresh margin
+	provider.expiresIn.Store(10)
+	jar, _ := cookiejar.New(nil)
+	browser := &http.Client{Jar: jar, CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		// stop on the way back to the shell, which would refresh the token
+		if len(via) > 1 && req.URL.Path == "/portal/" {
+			return http.ErrUseLastResponse
+		}
+		return nil
+	}}
+	rs, err = browser.Get(platform.URL + "/portal/")
+	if err != nil || rs.StatusCode != http.StatusFound || rs.Header.Get("Location") != "/portal/" {
+		t.Logf("expected sign in to return to the shell, actual = %v %v", rs, err)
+		t.FailNow()
+	}
+	rs.Body.Close()
+	provider.expiresIn.Store(3600)
+	rs, err = browser.Get(platform.URL + "/portal/")
+	if err != nil || rs.StatusCode != http.StatusOK {
+		t.Logf("expected shell, actual = %v %v", rs, err)
+		t.FailNow()
+	}
+	rs.Body.Close()
+	u, _ := url.Parse(platform.URL)
+	jar.SetCookies(u, []*http.Cookie{{Name: "theme", Value: "dark"}})
+
+	for i := 0; i < 2; i++ {
+		rs := api(browser, "/api/orders/1")
+		if rs.Header.Get("X-Authorization") != "Bearer access-2" || rs.Header.Get("X-Cookie") != "theme=dark" {
+			t.Logf("expected refreshed access token without session cookie, actual = %q %q", rs.Header.Get("X-Authorization"), rs.Header.Get("X-Cookie"))
+			t.FailNow()
+		}
+	}
+	if rs := api(browser, "/api/billing/1"); rs.Header.Get("X-Authorization") != "Bearer exchanged-billing-api-access-2" {
+		t.Logf("expected exchanged token, actual = %q", rs.Header.Get("X-Authorization"))
+		t.FailNow()
+	}
+	if n := provider.issued.Load(); n != 3 {
+		t.Logf("expected sign in, one refresh and one exchange, actual tokens issued = %d", n)
+		t.FailNow()
+	}
+
+	rs, err = browser.Get(platform.URL + "/auth/userinfo")
+	var user map[string]any
+	if err == nil {
+		json.NewDecoder(rs.Body).Decode(&user)
+		rs.Body.Close()
+	}
+	if user["sub"] != "alice" || fmt.Sprint(user["claims"]) != "map[roles:[admin]]" {
+		t.Logf("expected user info of alice, actual = %v %v", user, err)
+		t.FailNow()
+	}
+
+	noRedirect := &http.Client{Jar: jar, CheckRedirect: anonymous.CheckRedirect}
+	logout := func(method string, origin string) int {
+		req, _ := http.NewRequest(method, platform.URL+"/auth/logout", nil)
+		req.Header.Set("Origin", origin)
+		rs, err := noRedirect.Do(req)
+		if err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		rs.Body.Close()
+		return rs.StatusCode
+	}
+	if code := logout(http.MethodGet, platform.URL); code != http.StatusMethodNotAllowed {
+		t.Logf("expected logout to require a post, actual = %d", code)
+		t.FailNow()
+	}
+	if code := logout(http.MethodPost, "https://evil.example"); code != http.StatusForbidden {
+		t.Logf("expected cross-site logout to be rejected, actual = %d", code)
+		t.FailNow()
+	}
+	if rs := api(browser, "/api/orders/1"); rs.StatusCode != http.StatusOK {
+		t.Logf("expected session to survive a rejected logout, actual = %d", rs.StatusCode)
+		t.FailNow()
+	}
+	if code := logout(http.MethodPost, platform.URL); code != http.StatusFound {
+		t.Logf("expected logout to redirect, actual = %d", code)
+		t.FailNow()
+	}
+	if rs := api(browser, "/api/orders/1"); rs.StatusCode != http.StatusUnauthorized {
+		t.Logf("expected session to end at logout, actual = %d", rs.StatusCode)
+		t.FailNow()
+	}
+}
+
+func TestLocalRedirect(t *testing.T) {
+	expected := map[string]string{
+		"/portal/orders?id=1":  "/portal/orders?id=1",
+		"":                     "/",
+		"https://evil.example": "/",
+		"//evil.example/":      "/",
+		"/\\evil.example":      "/",
+		"portal":               "/",
+	}
+	for rd, e := range expected {
+		if actual := localRedirect(rd); actual != e {
+			t.Logf("expected %q to redirect to %q, actual = %q", rd, e, actual)
+			t.FailNow()
+		}
+	}
+}
+
+func TestExchangedTokenCache(t *testing.T) {
+	a := &authenticator{exchanged: make(map[string]cachedToken)}
+	now := time.Now()
+	for i := 0; i < maxCachedTokens; i++ {
+		a.cache(fmt.Sprint(i), cachedToken{expiry: now.Add(time.Duration(i+1) * time.Minute)}, now)
+	}
+	a.cache("next", cachedToken{expiry: now.Add(time.Hour)}, now)
+	if len(a.exchanged) != maxCachedTokens {
+		t.Logf("expected cache bounded to %d tokens, actual = %d", maxCachedTokens, len(a.exchanged))
+		t.FailNow()
+	}
+	if _, ok := a.exchanged["0"]; ok {
+		t.Logf("expected the token closest to expiry to be dropped")
+		t.FailNow()
+	}
+	if _, ok := a.exchanged["next"]; !ok {
+		t.Logf("expected the new token to be cached")
+		t.FailNow()
+	}
+}
@@ -0,0 +1,167 @@
+package http
+
+import (
+	"goruf/platform/core"
+	"sync"
+	"time"
+)
+
+const (
+	defaultOpenDuration     = 30 * time.Second
+	defaultHalfOpenRequests = 1
+)
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+func (s circuitState) String() string {
+	switch s {
+	case circuitOpen:
+		return "open"
+	case circuitHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// breaker is the circuit breaker of an upstream, it is always closed when
+// settings is nil.
+type breaker struct {
+	settings *core.CircuitBreaker
+
+	mu       sync.Mutex
+	state    circuitState
+	failures int
+	openedAt time.Time
+	// trials in flight and succeeded while half-open
+	trials    int
+	successes int
+}
+
+func (b *breaker) openDuration() time.Duration {
+	return b.settings.OpenDuration.Or(defaultOpenDuration)
+}
+
+func (b *breaker) halfOpenRequests() int {
+	if b.settings.HalfOpenRequests <= 0 {
+		return defaultHalfOpenRequests
+	}
+	return b.settings.HalfOpenRequests
+}
+
+// current returns the state at now, an open circuit turns half-open once
+// its open duration elapsed.
+func (b *breaker) current(now time.Time) circuitState {
+	if b.state == circuitOpen && !now.Before(b.openedAt.Add(b.openDuration())) {
+		b.state, b.trials, b.successes = circuitHalfOpen, 0, 0
+	}
+	return b.state
+}
+
+// ready reports whether a request may be sent without acquiring it.
+func (b *breaker) ready(now time.Time) bool {
+	if b.settings == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.current(now) {
+	case circuitOpen:
+		return false
+	case circuitHalfOpen:
+		return b.trials < b.halfOpenRequests()
+	default:
+		return true
+	}
+}
+
+// acquire admits a request, every admitted request must be followed by
+// record or release.
+func (b *breaker) acquire(now time.Time) bool {
+	if b.settings == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.current(now) {
+	case circuitOpen:
+		return false
+	case circuitHalfOpen:
+		if b.trials >= b.halfOpenRequests() {
+			return false
+		}
+		b.trials++
+	}
+	return true
+}
+
+// record counts the outcome of an admitted request.
+func (b *breaker) record(failed bool, now time.Time) (circuitState, bool) {
+	if b.settings == nil {
+		return circuitClosed, false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	from := b.state
+	switch b.state {
+	case circuitClosed:
+		if !failed {
+			b.failures = 0
+		} else if b.failures++; b.failures >= b.settings.FailureThreshold {
+			b.open(now)
+		}
+	case circuitHalfOpen:
+		b.trials--
+		if failed {
+			b.open(now)
+		} else if b.successes++; b.successes >= b.halfOpenRequests() {
+			b.state, b.failures = circuitClosed, 0
+		}
+	}
+	return b.state, b.state != from
+}
+
+// release returns an admitted request whose outcome says nothing about the
+// upstream, e.g. because the client went away.
+func (b *breaker) release() {
+	if b.settings == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == circuitHalfOpen && b.trials > 0 {
+		b.trials--
+	}
+}
+
+func (b *breaker) open(now time.Time) {
+	b.state, b.openedAt, b.failures = circuitOpen, now, 0
+}
+
+// retryAfter returns how long the circuit stays open.
+func (b *breaker) retryAfter(now time.Time) time.Duration {
+	if b.settings == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.current(now) != circuitOpen {
+		return 0
+	}
+	return b.openedAt.Add(b.openDuration()).Sub(now)
+}
+
+func (b *breaker) String() string {
+	if b.settings == nil {
+		return ""
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.current(time.Now()).String()
+}
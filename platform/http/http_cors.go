@@ -0,0 +1,122 @@
+package http
+
+import (
+	"goruf/platform/core"
+	"goruf/platform/database"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// CorsOf returns the CORS policy of d, nil when only the same origin is allowed.
+func CorsOf(d database.Deployment) *core.Cors {
+	if len(d.CorsOrigins) == 0 {
+		return nil
+	}
+	return &core.Cors{
+		AllowedOrigins:   d.CorsOrigins,
+		AllowedMethods:   d.CorsMethods,
+		AllowedHeaders:   d.CorsHeaders,
+		ExposedHeaders:   d.CorsExposedHeaders,
+		AllowCredentials: d.CorsCredentials,
+		MaxAge:           core.Duration(d.CorsMaxAge),
+	}
+}
+
+// cdnDeployment returns the deployment publishing the assets of /cdn/{endpoint}/*.
+func cdnDeployment(r *http.Request) (database.Deployment, bool) {
+	return database.GetDeployment(chi.URLParam(r, "endpoint"))
+}
+
+// resourceDeployment returns the deployment declaring the backend of
+// /resource/{service}/*.
+func resourceDeployment(r *http.Request) (database.Deployment, bool) {
+	service := chi.URLParam(r, "service")
+	for _, rel := range database.ActiveReleases() {
+		for _, p := range rel.Proxies {
+			if p.BackendCode == service {
+				return rel.Deployment, true
+			}
+		}
+	}
+	return database.Deployment{}, false
+}
+
+// withCors answers the preflight requests and decorates the responses of
+// the routes it wraps according to the CORS policy of the deployment
+// deploymentOf returns.
+func withCors(deploymentOf func(r *http.Request) (database.Deployment, bool)) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == http.MethodOptions && origin != "" && r.Header.Get("Access-Control-Request-Method") != ""
+			var cors *core.Cors
+			if d, ok := deploymentOf(r); ok {
+				cors = CorsOf(d)
+			}
+			if preflight {
+				w.Header().Add("Vary", "Origin, Access-Control-Request-Method, Access-Control-Request-Headers")
+				if !allowsPreflight(cors, r) {
+					forbidden(w)
+					return
+				}
+				h := w.Header()
+				allowOrigin(h, cors, origin)
+				h.Set("Access-Control-Allow-Methods", strings.Join(cors.MethodsOf(), ", "))
+				if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+					h.Set("Access-Control-Allow-Headers", headers)
+				}
+				if cors.MaxAge > 0 {
+					h.Set("Access-Control-Max-Age", strconv.Itoa(int(time.Duration(cors.MaxAge).Seconds())))
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			if cors != nil {
+				w.Header().Add("Vary", "Origin")
+				if origin != "" && cors.AllowsOrigin(origin) {
+					allowOrigin(w.Header(), cors, origin)
+					if len(cors.ExposedHeaders) > 0 {
+						w.Header().Set("Access-Control-Expose-Headers", strings.Join(cors.ExposedHeaders, ", "))
+					}
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// allowsPreflight reports whether cors allows the request announced by the
+// preflight request r.
+func allowsPreflight(cors *core.Cors, r *http.Request) bool {
+	if cors == nil || !cors.AllowsOrigin(r.Header.Get("Origin")) || !cors.AllowsMethod(r.Header.Get("Access-Control-Request-Method")) {
+		return false
+	}
+	for _, name := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+		if name = strings.TrimSpace(name); name != "" && !cors.AllowsHeader(name) {
+			return false
+		}
+	}
+	return true
+}
+
+// allowOrigin sets the origin and credentials headers of an allowed request.
+func allowOrigin(h http.Header, cors *core.Cors, origin string) {
+	if contains(cors.AllowedOrigins, core.CorsAnyOrigin) && !cors.AllowCredentials {
+		h.Set("Access-Control-Allow-Origin", core.CorsAnyOrigin)
+		return
+	}
+	h.Set("Access-Control-Allow-Origin", origin)
+	if cors.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// notPreflight answers the OPTIONS requests of CORS routes that are not
+// preflight requests.
+func notPreflight(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+}
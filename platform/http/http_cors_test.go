@@ -0,0 +1,74 @@
+package http
+
+import (
+	"goruf/platform/core"
+	"goruf/platform/database"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCors(t *testing.T) {
+	database.ConnectDatabase()
+	database.SaveRelease(database.Release{
+		Deployment: database.Deployment{
+			Endpoint:           "widgets",
+			Kind:               string(core.KindCdn),
+			CorsOrigins:        []string{"https://*.shop.example"},
+			CorsHeaders:        []string{"X-Api-Key"},
+			CorsExposedHeaders: []string{"X-Version"},
+			CorsCredentials:    true,
+			CorsMaxAge:         10 * time.Minute,
+		},
+		Proxies: []database.Proxy{{Id: "p1", BackendCode: "catalog", BackendAddress: "localhost:9000"}},
+	})
+	database.SaveRelease(database.Release{Deployment: database.Deployment{Endpoint: "portal", Kind: string(core.KindContainer)}})
+	router := newRouter()
+	send := func(method, path, origin, requestMethod, requestHeaders string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Origin", origin)
+		if requestMethod != "" {
+			req.Header.Set("Access-Control-Request-Method", requestMethod)
+		}
+		if requestHeaders != "" {
+			req.Header.Set("Access-Control-Request-Headers", requestHeaders)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, path := range []string{"/cdn/widgets/index.js", "/resource/catalog/items"} {
+		w := send(http.MethodOptions, path, "https://eu.shop.example", "GET", "x-api-key")
+		h := w.Header()
+		if w.Code != http.StatusNoContent || h.Get("Access-Control-Allow-Origin") != "https://eu.shop.example" ||
+			h.Get("Access-Control-Allow-Credentials") != "true" || h.Get("Access-Control-Allow-Methods") != "GET, HEAD, POST" ||
+			h.Get("Access-Control-Allow-Headers") != "x-api-key" || h.Get("Access-Control-Max-Age") != "600" {
+			t.Logf("expected preflight of %s to be allowed, actual = %d %v", path, w.Code, h)
+			t.FailNow()
+		}
+	}
+	rejected := []*httptest.ResponseRecorder{
+		send(http.MethodOptions, "/cdn/widgets/index.js", "https://evil.example", "GET", ""),
+		send(http.MethodOptions, "/cdn/widgets/index.js", "https://eu.shop.example", "DELETE", ""),
+		send(http.MethodOptions, "/cdn/widgets/index.js", "https://eu.shop.example", "GET", "Authorization"),
+		send(http.MethodOptions, "/cdn/portal/index.js", "https://eu.shop.example", "GET", ""),
+	}
+	for i, w := range rejected {
+		if w.Code != http.StatusForbidden || w.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Logf("expected preflight %d to be rejected, actual = %d %v", i, w.Code, w.Header())
+			t.FailNow()
+		}
+	}
+
+	w := send(http.MethodGet, "/cdn/widgets/index.js", "https://eu.shop.example", "", "")
+	if w.Header().Get("Access-Control-Allow-Origin") != "https://eu.shop.example" || w.Header().Get("Access-Control-Expose-Headers") != "X-Version" || w.Header().Get("Vary") != "Origin" {
+		t.Logf("expected response to be decorated, actual = %v", w.Header())
+		t.FailNow()
+	}
+	if w := send(http.MethodGet, "/cdn/portal/index.js", "https://eu.shop.example", "", ""); w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Logf("expected same origin only without policy, actual = %v", w.Header())
+		t.FailNow()
+	}
+}
@@ -0,0 +1,89 @@
+package http
+
+import (
+	"goruf/platform/core"
+	"goruf/platform/database"
+	"net/http"
+	"time"
+
+	"golang.org/x/text/language"
+)
+
+type LocaleOptions struct {
+	// Default is the last resort of every fallback chain.
+	Default string
+	// Cookie remembers the locale picked with the query parameter Param.
+	Cookie string
+	Param  string
+}
+
+func DefaultLocaleOptions() LocaleOptions {
+	return LocaleOptions{
+		Default: "en",
+		Cookie:  "mfe_locale",
+		Param:   "lang",
+	}
+}
+
+// localePreferences returns the locales asked for by the request, the query
+// parameter first, then the cookie and Accept-Language in order of quality.
+// A valid query parameter is remembered in the cookie.
+func localePreferences(w http.ResponseWriter, r *http.Request, opts LocaleOptions) []string {
+	var rs []string
+	if v := r.URL.Query().Get(opts.Param); v != "" {
+		if locale, err := core.CanonicalLocale(v); err == nil {
+			rs = append(rs, locale)
+			http.SetCookie(w, &http.Cookie{
+				Name:     opts.Cookie,
+				Value:    locale,
+				Path:     "/",
+				MaxAge:   int((365 * 24 * time.Hour).Seconds()),
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+	}
+	if c, err := r.Cookie(opts.Cookie); err == nil {
+		rs = append(rs, c.Value)
+	}
+	if tags, _, err := language.ParseAcceptLanguage(r.Header.Get("Accept-Language")); err == nil {
+		for _, tag := range tags {
+			rs = append(rs, tag.String())
+		}
+	}
+	return rs
+}
+
+// negotiateLocale picks the locale of the response among the locales of the
+// active deployments and returns it with the fallback chain, starting at that
+// locale, used to pick translations.
+func negotiateLocale(w http.ResponseWriter, r *http.Request) (string, []string) {
+	opts := options.Locale
+	chain := core.LocaleChain(localePreferences(w, r, opts), opts.Default)
+	available := availableLocales()
+	locale, rest := opts.Default, core.LocaleChain(nil, opts.Default)
+	for i, l := range chain {
+		if available[l] {
+			locale, rest = l, chain[i:]
+			break
+		}
+	}
+	w.Header().Add("Vary", "Accept-Language, Cookie")
+	w.Header().Set("Content-Language", locale)
+	return locale, rest
+}
+
+// availableLocales returns the default locale and those the active deployments are translated to.
+func availableLocales() map[string]bool {
+	rs := make(map[string]bool)
+	if locale, err := core.CanonicalLocale(options.Locale.Default); err == nil {
+		rs[locale] = true
+	}
+	for _, d := range database.ListDeployments() {
+		for _, l := range d.Locales {
+			if locale, err := core.CanonicalLocale(l); err == nil {
+				rs[locale] = true
+			}
+		}
+	}
+	return rs
+}
@@ -0,0 +1,116 @@
+package http
+
+import (
+	"encoding/json"
+	"goruf/platform/core"
+	"goruf/platform/database"
+	"net/http"
+	"sort"
+
+	"github.com/rs/zerolog/log"
+)
+
+// NavigationsOf rebuilds the navigation tree of a deployment from its rows.
+func NavigationsOf(rows []database.Navigation) []core.Navigation {
+	children := make(map[string][]database.Navigation)
+	for _, row := range rows {
+		children[row.ParentId] = append(children[row.ParentId], row)
+	}
+	var build func(parentId string) []core.Navigation
+	build = func(parentId string) []core.Navigation {
+		level := children[parentId]
+		sort.SliceStable(level, func(i, j int) bool {
+			return level[i].Position < level[j].Position
+		})
+		var rs []core.Navigation
+		for _, row := range level {
+			rs = append(rs, core.Navigation{
+				Id:       row.Key,
+				Endpoint: row.Endpoint,
+				Title:    row.Title,
+				Titles:   row.Titles,
+				Icon:     row.Icon,
+				Order:    row.Order,
+				Hidden:   row.Hidden,
+				Roles:    row.Roles,
+				Claims:   row.Claims,
+				Children: build(row.Id),
+			})
+		}
+		return rs
+	}
+	return build("")
+}
+
+// NavigationSources returns the navigations of the active deployments keyed
+// by endpoint, as expected by core.MergeNavigations.
+func NavigationSources() map[string][]core.Navigation {
+	return navigationSources(func(database.Deployment) bool { return true })
+}
+
+// navigationSources is like NavigationSources for the deployments include accepts.
+func navigationSources(include func(d database.Deployment) bool) map[string][]core.Navigation {
+	sources := make(map[string][]core.Navigation)
+	for _, r := range database.ActiveReleases() {
+		if len(r.Navigations) > 0 && include(r.Deployment) {
+			sources[r.Deployment.Endpoint] = NavigationsOf(r.Navigations)
+		}
+	}
+	return sources
+}
+
+// serveNavigation returns the navigation tree merged from all deployments
+// with titles in the negotiated locale, shells render their menu from it.
+func serveNavigation(w http.ResponseWriter, r *http.Request) {
+	tree, conflicts := core.MergeNavigations(navigationSources(func(d database.Deployment) bool {
+		res := Resource{Kind: ResourceNavigation, Deployment: d.Endpoint, Name: d.Endpoint}
+		return allowed(r, res, AccessOf(d.AccessRoles, d.AccessClaims))
+	}))
+	for _, c := range conflicts {
+		log.Warn().Err(c).Msg("navigation conflict")
+	}
+	tree = filterNavigation(r, tree)
+	_, chain := negotiateLocale(w, r)
+	localizeNavigation(tree, chain)
+	if tree == nil {
+		tree = make([]*core.NavigationNode, 0)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	if err := json.NewEncoder(w).Encode(tree); err != nil {
+		log.Error().Err(err).Msg("failed to write navigation")
+	}
+}
+
+// filterNavigation drops the items of level the user of r may not see, and
+// the groups without endpoint left without items.
+func filterNavigation(r *http.Request, level []*core.NavigationNode) []*core.NavigationNode {
+	rs := level[:0]
+	for _, n := range level {
+		access := n.Access()
+		res := Resource{Kind: ResourceNavigation, Name: n.Id, Path: n.Endpoint}
+		if res.Name == "" {
+			res.Name = n.Endpoint
+		}
+		if len(n.Deployments) > 0 {
+			res.Deployment = n.Deployments[0]
+		}
+		if !allowed(r, res, &access) {
+			continue
+		}
+		if n.Children = filterNavigation(r, n.Children); len(n.Children) == 0 && n.Endpoint == "" {
+			continue
+		}
+		rs = append(rs, n)
+	}
+	return rs
+}
+
+// localizeNavigation replaces the titles of the tree by their translation,
+// Titles is kept so shells can switch locale without another request.
+func localizeNavigation(level []*core.NavigationNode, chain []string) {
+	for _, n := range level {
+		n.Title = core.Localize(n.Title, n.Titles, chain)
+		localizeNavigation(n.Children, chain)
+	}
+}
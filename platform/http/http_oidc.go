@@ -0,0 +1,335 @@
+package http
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// clockSkew is tolerated when checking the expiry of tokens.
+	clockSkew = time.Minute
+	// jwksMinAge throttles the reloads of the provider keys on unknown key ids.
+	jwksMinAge = 10 * time.Second
+)
+
+// providerMetadata is the part of the OpenID provider configuration used
+// by the platform.
+type providerMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksUri               string `json:"jwks_uri"`
+	EndSessionEndpoint    string `json:"end_session_endpoint"`
+}
+
+type tokenResponse struct {
+	AccessToken     string `json:"access_token"`
+	TokenType       string `json:"token_type"`
+	RefreshToken    string `json:"refresh_token"`
+	IdToken         string `json:"id_token"`
+	ExpiresIn       int64  `json:"expires_in"`
+	IssuedTokenType string `json:"issued_token_type"`
+}
+
+// tokenError is an error answered by the token endpoint.
+type tokenError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *tokenError) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+	return e.Code + ": " + e.Description
+}
+
+// provider is an OpenID provider, its configuration and keys are loaded on
+// first use so the platform starts while the provider is down.
+type provider struct {
+	issuer       string
+	clientId     string
+	clientSecret string
+	client       *http.Client
+
+	mu       sync.Mutex
+	metadata *providerMetadata
+	keys     map[string]crypto.PublicKey
+	loaded   time.Time
+}
+
+func newProvider(issuer, clientId, clientSecret string) *provider {
+	return &provider{
+		issuer:       strings.TrimSuffix(issuer, "/"),
+		clientId:     clientId,
+		clientSecret: clientSecret,
+		client:       &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *provider) getJson(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	rs, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rs.Body.Close()
+	if rs.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %s", url, rs.Status)
+	}
+	return json.NewDecoder(rs.Body).Decode(v)
+}
+
+func (p *provider) discover(ctx context.Context) (*providerMetadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+	m := &providerMetadata{}
+	if err := p.getJson(ctx, p.issuer+"/.well-known/openid-configuration", m); err != nil {
+		return nil, fmt.Errorf("failed to discover provider: %w", err)
+	}
+	if strings.TrimSuffix(m.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("provider issuer %s does not match %s", m.Issuer, p.issuer)
+	}
+	p.metadata = m
+	return m, nil
+}
+
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	decode := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		return new(big.Int).SetBytes(b), err
+	}
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+}
+
+// key returns the signing key kid of the provider, the keys are reloaded
+// when kid is unknown as the provider may have rotated them.
+func (p *provider) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	m, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if k, ok := p.keys[kid]; ok {
+		return k, nil
+	}
+	if time.Since(p.loaded) < jwksMinAge {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := p.getJson(ctx, m.JwksUri, &set); err != nil {
+		return nil, fmt.Errorf("failed to load provider keys: %w", err)
+	}
+	p.keys, p.loaded = make(map[string]crypto.PublicKey), time.Now()
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if key, err := k.publicKey(); err == nil {
+			p.keys[k.Kid] = key
+		}
+	}
+	if k, ok := p.keys[kid]; ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+// token posts form to the token endpoint, authenticating the client with
+// its secret when it has one.
+func (p *provider) token(ctx context.Context, form url.Values) (*tokenResponse, error) {
+	m, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if p.clientSecret == "" {
+		form.Set("client_id", p.clientId)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.clientId), url.QueryEscape(p.clientSecret))
+	}
+	rs, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer rs.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(rs.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if rs.StatusCode != http.StatusOK {
+		e := &tokenError{}
+		if json.Unmarshal(b, e) != nil || e.Code == "" {
+			return nil, fmt.Errorf("token endpoint answered %s", rs.Status)
+		}
+		return nil, e
+	}
+	t := &tokenResponse{}
+	if err := json.Unmarshal(b, t); err != nil {
+		return nil, err
+	}
+	if t.AccessToken == "" {
+		return nil, errors.New("token endpoint answered without access token")
+	}
+	return t, nil
+}
+
+// verify checks the signature and the claims of an ID token and returns its claims.
+func (p *provider) verify(ctx context.Context, raw string, nonce string, now time.Time) (map[string]any, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed token header: %w", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature: %w", err)
+	}
+	key, err := p.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if header.Alg != "RS256" {
+			return nil, fmt.Errorf("unsupported algorithm %s", header.Alg)
+		}
+		err = rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig)
+	case *ecdsa.PublicKey:
+		if header.Alg != "ES256" || len(sig) != 64 {
+			return nil, fmt.Errorf("unsupported algorithm %s", header.Alg)
+		}
+		if !ecdsa.Verify(k, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+			err = errors.New("invalid signature")
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify token: %w", err)
+	}
+	claims := make(map[string]any)
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims: %w", err)
+	}
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != p.issuer {
+		return nil, fmt.Errorf("token issued by %q", iss)
+	}
+	if !audienceContains(claims["aud"], p.clientId) {
+		return nil, errors.New("token is not issued for this client")
+	}
+	if exp, _ := claims["exp"].(float64); now.After(time.Unix(int64(exp), 0).Add(clockSkew)) {
+		return nil, errors.New("token is expired")
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, errors.New("token nonce does not match")
+	}
+	return claims, nil
+}
+
+func decodeSegment(s string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func audienceContains(aud any, clientId string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == clientId
+	case []any:
+		for _, v := range a {
+			if v == clientId {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// randomString returns n random bytes encoded for URLs.
+func randomString(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// pkceChallenge returns the S256 code challenge of verifier.
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,105 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+type peerKey struct{}
+
+// keepPeer remembers the address of the socket peer before realIP replaces
+// it with the address of the client behind a trusted proxy.
+func keepPeer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), peerKey{}, r.RemoteAddr)))
+	})
+}
+
+// peerOf returns the address of the socket peer of r.
+func peerOf(r *http.Request) string {
+	if addr, ok := r.Context().Value(peerKey{}).(string); ok {
+		return addr
+	}
+	return r.RemoteAddr
+}
+
+// ParseTrustedProxies parses addresses and CIDR ranges of proxies whose
+// forwarding headers are trusted.
+func ParseTrustedProxies(values []string) ([]netip.Prefix, error) {
+	rs := make([]netip.Prefix, 0, len(values))
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if p, err := netip.ParsePrefix(v); err == nil {
+			rs = append(rs, p.Masked())
+			continue
+		}
+		a, err := netip.ParseAddr(v)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q is neither an address nor a CIDR range", v)
+		}
+		rs = append(rs, netip.PrefixFrom(a, a.BitLen()))
+	}
+	return rs, nil
+}
+
+func trustedProxy(a netip.Addr) bool {
+	for _, p := range options.TrustedProxies {
+		if p.Contains(a.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// hostOf returns the host of addr, which may lack a port.
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// clientIP returns the address of the client of r. Forwarding headers are
+// only read when the socket peer is a trusted proxy, X-Forwarded-For is read
+// from the right up to the first address that is not a trusted proxy.
+func clientIP(r *http.Request) string {
+	peer := hostOf(peerOf(r))
+	a, err := netip.ParseAddr(peer)
+	if err != nil || !trustedProxy(a) {
+		return peer
+	}
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				break
+			}
+			a = hop
+			if !trustedProxy(hop) {
+				break
+			}
+		}
+		return a.String()
+	}
+	if v, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return v.String()
+	}
+	return peer
+}
+
+// realIP sets the remote address of requests to their client, see clientIP.
+func realIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.RemoteAddr = clientIP(r)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,56 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	previous := options
+	defer func() { options = previous }()
+	var err error
+	options.TrustedProxies, err = ParseTrustedProxies([]string{"10.0.0.0/8", "2001:db8::1"})
+	if err != nil {
+		t.Logf("failed to ParseTrustedProxies(values): %v", err)
+		t.FailNow()
+	}
+	if _, err := ParseTrustedProxies([]string{"proxy.local"}); err == nil {
+		t.Logf("expected host names to be rejected")
+		t.FailNow()
+	}
+	tests := []struct {
+		peer    string
+		headers map[string]string
+		client  string
+	}{
+		{"192.0.2.7:1234", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "192.0.2.7"},
+		{"192.0.2.7:1234", map[string]string{"X-Real-IP": "198.51.100.1"}, "192.0.2.7"},
+		{"10.0.0.2:1234", map[string]string{"X-Forwarded-For": "198.51.100.9, 198.51.100.1, 10.0.0.3"}, "198.51.100.1"},
+		{"10.0.0.2:1234", map[string]string{"X-Forwarded-For": "not an address"}, "10.0.0.2"},
+		{"10.0.0.2:1234", map[string]string{"X-Real-IP": "198.51.100.1"}, "198.51.100.1"},
+		{"10.0.0.2:1234", nil, "10.0.0.2"},
+		{"[2001:db8::1]:443", map[string]string{"X-Forwarded-For": "2001:db8::2"}, "2001:db8::2"},
+		{"[2001:db8::3]:443", map[string]string{"X-Forwarded-For": "2001:db8::2"}, "2001:db8::3"},
+	}
+	for _, test := range tests {
+		var actual string
+		h := keepPeer(realIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			actual = r.RemoteAddr
+			if clientIP(r) != actual {
+				t.Logf("expected clientIP to keep its value behind realIP, actual = %s", clientIP(r))
+				t.FailNow()
+			}
+		})))
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = test.peer
+		for k, v := range test.headers {
+			req.Header.Set(k, v)
+		}
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		if actual != test.client {
+			t.Logf("expected client of %s %v to be %s, actual = %s", test.peer, test.headers, test.client, actual)
+			t.FailNow()
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package http
+
+import (
+	"fmt"
+	"goruf/platform/core"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Subject is the user access is decided for, anonymous when Id is empty.
+type Subject struct {
+	Id string
+	// Claims are the claims kept in the session, see AuthOptions.Claims.
+	Claims map[string]any
+}
+
+type ResourceKind string
+
+const (
+	ResourceShell      ResourceKind = "shell"
+	ResourceNavigation ResourceKind = "navigation"
+	ResourceApi        ResourceKind = "api"
+)
+
+// Resource is what a subject asks access to.
+type Resource struct {
+	Kind ResourceKind
+	// Deployment is the endpoint of the deployment owning the resource.
+	Deployment string
+	// Name is the backend code of an API, the key of a navigation item.
+	Name string
+	Path string
+}
+
+// Policy decides whether a subject may access a resource restricted by
+// access, it is called for unrestricted resources as well.
+type Policy interface {
+	Allowed(s Subject, res Resource, access core.Access) bool
+}
+
+// ClaimsPolicy allows the subjects having any of the roles of an access in
+// the RolesClaim claim and, for each of its claims, one of the values.
+// Nested claims are looked up by their dotted path, anonymous subjects are
+// only allowed to unrestricted resources.
+type ClaimsPolicy struct {
+	RolesClaim string
+}
+
+func DefaultPolicy() Policy {
+	return ClaimsPolicy{RolesClaim: "roles"}
+}
+
+func (p ClaimsPolicy) Allowed(s Subject, res Resource, access core.Access) bool {
+	if access.IsEmpty() {
+		return true
+	}
+	if s.Id == "" {
+		return false
+	}
+	if len(access.Roles) > 0 && !matchesAny(claimValues(s.Claims, p.RolesClaim), access.Roles) {
+		return false
+	}
+	for name, values := range access.Claims {
+		if !matchesAny(claimValues(s.Claims, name), values) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchesAny(actual []string, expected []string) bool {
+	for _, v := range actual {
+		if contains(expected, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// claimValues returns the values of the claim at path, a claim named path
+// itself wins over a nested one.
+func claimValues(claims map[string]any, path string) []string {
+	v, ok := claims[path]
+	if !ok {
+		var cur any = claims
+		for _, name := range strings.Split(path, ".") {
+			m, ok := cur.(map[string]any)
+			if !ok {
+				return nil
+			}
+			cur = m[name]
+		}
+		v = cur
+	}
+	switch c := v.(type) {
+	case nil:
+		return nil
+	case string:
+		return []string{c}
+	case []any:
+		rs := make([]string, 0, len(c))
+		for _, item := range c {
+			rs = append(rs, fmt.Sprint(item))
+		}
+		return rs
+	default:
+		return []string{fmt.Sprint(c)}
+	}
+}
+
+// AccessOf returns the access stored as roles and claims, nil when it
+// allows everyone.
+func AccessOf(roles []string, claims map[string][]string) *core.Access {
+	if len(roles) == 0 && len(claims) == 0 {
+		return nil
+	}
+	return &core.Access{Roles: roles, Claims: claims}
+}
+
+// subjectOf returns the signed in user of r.
+func subjectOf(r *http.Request) Subject {
+	s := sessionOf(r)
+	if s == nil {
+		return Subject{}
+	}
+	return Subject{Id: s.Subject, Claims: s.Claims}
+}
+
+// allowed reports whether the user of r may access res, restricted by all
+// of accesses.
+func allowed(r *http.Request, res Resource, accesses ...*core.Access) bool {
+	s := subjectOf(r)
+	for _, a := range accesses {
+		access := core.Access{}
+		if a != nil {
+			access = *a
+		}
+		if !options.Policy.Allowed(s, res, access) {
+			log.Debug().
+				Str("subject", s.Id).
+				Str("kind", string(res.Kind)).
+				Str("endpoint", res.Deployment).
+				Str("name", res.Name).
+				Str("path", res.Path).
+				Msg("access denied")
+			return false
+		}
+	}
+	return true
+}
+
+// forbidden answers 403 to a request denied by the policy.
+func forbidden(w http.ResponseWriter) {
+	writeError(w, http.StatusForbidden, errorBody{Error: "forbidden", Message: "access denied"})
+}
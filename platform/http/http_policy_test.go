@@ -0,0 +1,153 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"goruf/platform/core"
+	"goruf/platform/database"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+)
+
+func TestClaimsPolicy(t *testing.T) {
+	policy := DefaultPolicy()
+	alice := Subject{Id: "alice", Claims: map[string]any{
+		"roles":        []any{"sales", "admin"},
+		"tenant":       "acme",
+		"realm_access": map[string]any{"roles": []any{"auditor"}},
+	}}
+	expected := []struct {
+		subject Subject
+		access  core.Access
+		allowed bool
+	}{
+		{Subject{}, core.Access{}, true},
+		{Subject{}, core.Access{Roles: []string{"sales"}}, false},
+		{alice, core.Access{Roles: []string{"support", "admin"}}, true},
+		{alice, core.Access{Roles: []string{"support"}}, false},
+		{alice, core.Access{Roles: []string{"sales"}, Claims: map[string][]string{"tenant": {"acme"}}}, true},
+		{alice, core.Access{Claims: map[string][]string{"tenant": {"globex"}}}, false},
+		{alice, core.Access{Claims: map[string][]string{"realm_access.roles": {"auditor"}}}, true},
+		{alice, core.Access{Claims: map[string][]string{"groups": {"auditor"}}}, false},
+	}
+	for _, e := range expected {
+		if actual := policy.Allowed(e.subject, Resource{Kind: ResourceApi}, e.access); actual != e.allowed {
+			t.Logf("expected %+v to be allowed = %v for %+v, actual = %v", e.subject, e.allowed, e.access, actual)
+			t.FailNow()
+		}
+	}
+}
+
+func TestAccessControl(t *testing.T) {
+	backend := replica("a", nil)
+	defer backend.Close()
+	database.ConnectDatabase()
+	database.SaveRelease(database.Release{
+		Deployment: database.Deployment{Endpoint: "portal", Kind: string(core.KindContainer)},
+		Navigations: []database.Navigation{
+			{Id: "n1", Key: "sales", Title: "Sales", Roles: []string{"sales"}},
+			{Id: "n2", ParentId: "n1", Endpoint: "orders", Title: "Orders"},
+			{Id: "n3", Endpoint: "home", Title: "Home", Position: 1},
+			{Id: "n5", Endpoint: "reports", Title: "Reports", Position: 2},
+			{Id: "n6", ParentId: "n5", Endpoint: "audit", Title: "Audit", Roles: []string{"admin"}},
+		},
+	})
+	database.SaveRelease(database.Release{
+		Deployment: database.Deployment{
+			Endpoint:    "backoffice",
+			Kind:        string(core.KindContainer),
+			AccessRoles: []string{"admin"},
+		},
+		Navigations: []database.Navigation{{Id: "n4", Endpoint: "settings", Title: "Settings"}},
+		Proxies:     []database.Proxy{{Id: "p1", BackendCode: "billing", BackendAddress: backend.URL, AccessRoles: []string{"sales", "admin"}}},
+		Routes: []database.ProxyRoute{
+			{ProxyId: "p1", Prefix: "/api/billing/invoices"},
+			{ProxyId: "p1", Position: 1, Prefix: "/api/billing/refunds", AccessClaims: map[string][]string{"tenant": {"acme"}}},
+		},
+	})
+	router := newRouter()
+	send := func(s *session, path string, api bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if api {
+			req.Header.Set("X-Request-Type", "api")
+		}
+		if s != nil {
+			req = req.WithContext(context.WithValue(req.Context(), sessionKey{}, s))
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	sales := &session{Subject: "bob", Claims: map[string]any{"roles": []any{"sales"}, "tenant": "globex"}}
+	admin := &session{Subject: "alice", Claims: map[string]any{"roles": []any{"admin"}, "tenant": "acme"}}
+
+	if w := send(sales, "/portal/", false); w.Code != http.StatusOK {
+		t.Logf("expected unrestricted shell, actual = %d", w.Code)
+		t.FailNow()
+	}
+	w := send(sales, "/backoffice/", false)
+	var body errorBody
+	json.NewDecoder(w.Body).Decode(&body)
+	if w.Code != http.StatusForbidden || body.Error != "forbidden" {
+		t.Logf("expected restricted shell to be forbidden, actual = %d %+v", w.Code, body)
+		t.FailNow()
+	}
+	if w := send(admin, "/backoffice/", false); w.Code != http.StatusOK {
+		t.Logf("expected admin to open the shell, actual = %d", w.Code)
+		t.FailNow()
+	}
+	if w := send(nil, "/backoffice/", false); w.Code != http.StatusForbidden {
+		t.Logf("expected anonymous user to be forbidden, actual = %d", w.Code)
+		t.FailNow()
+	}
+
+	endpoints := func(s *session) []string {
+		var tree []*core.NavigationNode
+		json.NewDecoder(send(s, "/navigation.json", false).Body).Decode(&tree)
+		var rs []string
+		var walk func(level []*core.NavigationNode)
+		walk = func(level []*core.NavigationNode) {
+			for _, n := range level {
+				if n.Endpoint != "" {
+					rs = append(rs, n.Endpoint)
+				}
+				walk(n.Children)
+			}
+		}
+		walk(tree)
+		return rs
+	}
+	expected := map[*session][]string{
+		nil:   {"home", "reports"},
+		sales: {"home", "orders", "reports"},
+		admin: {"audit", "home", "reports", "settings"},
+	}
+	for s, e := range expected {
+		actual := endpoints(s)
+		sort.Strings(actual)
+		if fmt.Sprint(actual) != fmt.Sprint(e) {
+			t.Logf("expected navigation %v, actual = %v", e, actual)
+			t.FailNow()
+		}
+	}
+
+	if w := send(sales, "/api/billing/invoices", true); w.Code != http.StatusOK {
+		t.Logf("expected proxy roles to allow sales, actual = %d", w.Code)
+		t.FailNow()
+	}
+	if w := send(sales, "/api/billing/refunds", true); w.Code != http.StatusForbidden {
+		t.Logf("expected route claims to forbid another tenant, actual = %d", w.Code)
+		t.FailNow()
+	}
+	if w := send(admin, "/api/billing/refunds", true); w.Code != http.StatusOK {
+		t.Logf("expected route claims to allow the tenant, actual = %d", w.Code)
+		t.FailNow()
+	}
+	if w := send(&session{Subject: "carol"}, "/api/billing/invoices", true); w.Code != http.StatusForbidden {
+		t.Logf("expected user without roles to be forbidden, actual = %d", w.Code)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,212 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"goruf/platform/core"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limit is the token bucket of a rate limit, it holds up to Burst tokens and
+// is refilled with Rate tokens per second.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Decision is the outcome of taking a token from a bucket.
+type Decision struct {
+	Allowed   bool
+	Remaining int
+	// Reset is the time until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is the time until a token is available when not allowed.
+	RetryAfter time.Duration
+}
+
+// RateLimitStore keeps the token buckets of the rate limits, an
+// implementation shared between servers makes the limits cluster wide.
+type RateLimitStore interface {
+	// Take removes a token from the bucket of key, which is created full.
+	Take(key string, limit Limit, now time.Time) Decision
+	// TakeAll removes a token from the bucket of every key, keys[i] being
+	// limited by limits[i], only when each of them has one. The decisions are
+	// in the order of keys.
+	TakeAll(keys []string, limits []Limit, now time.Time) []Decision
+}
+
+// sweepInterval is how often the memory store drops the buckets that
+// refilled, they are in the state a new bucket starts from.
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	limit   Limit
+}
+
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.updated).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(float64(b.limit.Burst), b.tokens+elapsed*b.limit.Rate)
+		b.updated = now
+	}
+}
+
+// decide takes a token when one is available and take is set.
+func (b *bucket) decide(take bool) Decision {
+	tokens := b.tokens
+	d := Decision{}
+	if tokens >= 1 {
+		tokens--
+		d.Allowed = true
+	} else {
+		d.RetryAfter = seconds((1 - tokens) / b.limit.Rate)
+	}
+	if take {
+		b.tokens = tokens
+	}
+	d.Remaining = int(tokens)
+	d.Reset = seconds((float64(b.limit.Burst) - tokens) / b.limit.Rate)
+	return d
+}
+
+type memoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+// NewMemoryRateLimitStore returns a RateLimitStore keeping the buckets in
+// memory, limits are then enforced per server.
+func NewMemoryRateLimitStore() RateLimitStore {
+	return &memoryStore{buckets: make(map[string]*bucket)}
+}
+
+func (s *memoryStore) Take(key string, limit Limit, now time.Time) Decision {
+	return s.TakeAll([]string{key}, []Limit{limit}, now)[0]
+}
+
+func (s *memoryStore) TakeAll(keys []string, limits []Limit, now time.Time) []Decision {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.swept) >= sweepInterval {
+		for k, b := range s.buckets {
+			if b.refill(now); b.tokens >= float64(b.limit.Burst) {
+				delete(s.buckets, k)
+			}
+		}
+		s.swept = now
+	}
+	buckets := make([]*bucket, len(keys))
+	allowed := true
+	for i, key := range keys {
+		b, ok := s.buckets[key]
+		if !ok || b.limit != limits[i] {
+			b = &bucket{tokens: float64(limits[i].Burst), updated: now, limit: limits[i]}
+			s.buckets[key] = b
+		}
+		b.refill(now)
+		buckets[i] = b
+		allowed = allowed && b.tokens >= 1
+	}
+	rs := make([]Decision, len(keys))
+	for i, b := range buckets {
+		rs[i] = b.decide(allowed)
+	}
+	return rs
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+type userKey struct{}
+
+// WithUser returns a copy of ctx carrying the id of the authenticated user.
+func WithUser(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, userKey{}, id)
+}
+
+// UserOf returns the id of the authenticated user of r, empty when anonymous.
+func UserOf(r *http.Request) string {
+	id, _ := r.Context().Value(userKey{}).(string)
+	return id
+}
+
+// clientKey returns the client r is limited as by l, its address when the
+// key is not sent.
+func clientKey(r *http.Request, l core.RateLimit) string {
+	key := l.KeyOf()
+	switch {
+	case key == core.RateLimitByBackend:
+		return ""
+	case key == core.RateLimitByUser:
+		if id := UserOf(r); id != "" {
+			return "user:" + id
+		}
+	case strings.HasPrefix(key, core.RateLimitHeaderPrefix):
+		if v := r.Header.Get(strings.TrimPrefix(key, core.RateLimitHeaderPrefix)); v != "" {
+			return key + ":" + v
+		}
+	}
+	return "ip:" + clientIP(r)
+}
+
+// limit takes a token for r from every rate limit of the route and sets the
+// RateLimit headers of the most restrictive one. It answers 429 and returns
+// false when a limit is exceeded.
+func (rt *apiRoute) limit(w http.ResponseWriter, r *http.Request) bool {
+	if len(rt.rateLimits) == 0 {
+		return true
+	}
+	now := time.Now()
+	// tokens are only taken when every limit allows the request, a client
+	// refused by its own limit must not drain the limits it shares
+	tightest, decision := rt.decide(r, now)
+	period := int(math.Ceil(tightest.PeriodOf().Seconds()))
+	w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d;burst=%d", tightest.Requests, period, tightest.BurstOf()))
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(tightest.BurstOf()))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(decision.Reset)))
+	if decision.Allowed {
+		return true
+	}
+	retryAfter := ceilSeconds(decision.RetryAfter)
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	writeError(w, http.StatusTooManyRequests, errorBody{
+		Error:      "rate_limited",
+		Message:    "too many requests, retry later",
+		Backend:    rt.backend,
+		RetryAfter: retryAfter,
+	})
+	return false
+}
+
+// decide takes a token for r from every rate limit of the route when all of
+// them allow it, and returns the most restrictive one with its decision.
+func (rt *apiRoute) decide(r *http.Request, now time.Time) (*core.RateLimit, Decision) {
+	keys := make([]string, 0, len(rt.rateLimits))
+	limits := make([]Limit, 0, len(rt.rateLimits))
+	for _, l := range rt.rateLimits {
+		keys = append(keys, fmt.Sprintf("%s/%s/%s/%s", rt.deployment, rt.backend, l.KeyOf(), clientKey(r, l)))
+		limits = append(limits, Limit{Rate: float64(l.Requests) / l.PeriodOf().Seconds(), Burst: l.BurstOf()})
+	}
+	var tightest *core.RateLimit
+	var decision Decision
+	for i, d := range options.RateLimit.TakeAll(keys, limits, now) {
+		if tightest == nil || !d.Allowed && decision.Allowed || d.Allowed == decision.Allowed && d.Remaining < decision.Remaining {
+			tightest, decision = &rt.rateLimits[i], d
+		}
+	}
+	return tightest, decision
+}
+
+// ceilSeconds rounds d up to whole seconds, at least one when d is positive.
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,176 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"goruf/platform/database"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	limit := Limit{Rate: 1, Burst: 2}
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if d := store.Take("client", limit, now); !d.Allowed || d.Remaining != 1-i {
+			t.Logf("expected burst of 2, actual = %+v", d)
+			t.FailNow()
+		}
+	}
+	if d := store.Take("client", limit, now); d.Allowed || d.RetryAfter != time.Second || d.Reset != 2*time.Second {
+		t.Logf("expected empty bucket, actual = %+v", d)
+		t.FailNow()
+	}
+	if d := store.Take("other", limit, now); !d.Allowed {
+		t.Logf("expected buckets per key, actual = %+v", d)
+		t.FailNow()
+	}
+	if d := store.Take("client", limit, now.Add(time.Second)); !d.Allowed || d.Remaining != 0 {
+		t.Logf("expected bucket to refill, actual = %+v", d)
+		t.FailNow()
+	}
+
+	ds := store.TakeAll([]string{"shared", "client"}, []Limit{limit, limit}, now.Add(time.Second))
+	if ds[0].Allowed == ds[1].Allowed || ds[1].Allowed {
+		t.Logf("expected the empty bucket to refuse the request, actual = %+v", ds)
+		t.FailNow()
+	}
+	if d := store.Take("shared", limit, now.Add(time.Second)); d.Remaining != 1 {
+		t.Logf("expected a refused request to leave the other buckets untouched, actual = %+v", d)
+		t.FailNow()
+	}
+}
+
+func TestMemoryRateLimitStoreConcurrent(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	now := time.Now()
+	shared, own := Limit{Rate: 0.001, Burst: 10}, Limit{Rate: 0.001, Burst: 1}
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// each client has a single token, only its first request is admitted
+			for j := 0; j < 4; j++ {
+				store.TakeAll([]string{"shared", fmt.Sprintf("client-%d", j)}, []Limit{shared, own}, now)
+			}
+		}()
+	}
+	wg.Wait()
+	if d := store.Take("shared", shared, now); !d.Allowed || d.Remaining != 5 {
+		t.Logf("expected only the 4 admitted requests to take from the shared bucket, actual = %+v", d)
+		t.FailNow()
+	}
+}
+
+func TestProxyRateLimit(t *testing.T) {
+	backend := replica("a", nil)
+	defer backend.Close()
+	database.ConnectDatabase()
+	database.SaveRelease(database.Release{
+		Deployment: database.Deployment{Endpoint: "search"},
+		Proxies:    []database.Proxy{{Id: "p1", BackendCode: "search", BackendAddress: backend.URL}},
+		RateLimits: []database.ProxyRateLimit{
+			{ProxyId: "p1", Requests: 2, Period: time.Minute},
+			{ProxyId: "p1", Position: 1, Key: "header:X-Api-Key", Requests: 3, Period: time.Minute},
+		},
+	})
+	send := func(addr, apiKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/search", nil)
+		req.RemoteAddr = addr + ":1234"
+		if apiKey != "" {
+			req.Header.Set("X-Api-Key", apiKey)
+		}
+		w := httptest.NewRecorder()
+		apiProxy(w, req)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := send("10.0.0.1", ""); w.Code != http.StatusOK || w.Header().Get("RateLimit-Remaining") != strconv.Itoa(1-i) {
+			t.Logf("expected request %d to pass, actual = %d %v", i, w.Code, w.Header())
+			t.FailNow()
+		}
+	}
+	w := send("10.0.0.1", "")
+	var body errorBody
+	json.NewDecoder(w.Body).Decode(&body)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "30" || body.Error != "rate_limited" {
+		t.Logf("expected client to be limited, actual = %d %v %+v", w.Code, w.Header(), body)
+		t.FailNow()
+	}
+	if w.Header().Get("RateLimit-Policy") != "2;w=60;burst=2" || w.Header().Get("RateLimit-Remaining") != "0" {
+		t.Logf("expected RateLimit headers of the exceeded limit, actual = %v", w.Header())
+		t.FailNow()
+	}
+	spoofed := httptest.NewRequest(http.MethodGet, "/api/search", nil)
+	spoofed.RemoteAddr = "10.0.0.1:1234"
+	spoofed.Header.Set("X-Forwarded-For", "192.0.2.1")
+	w = httptest.NewRecorder()
+	apiProxy(w, spoofed)
+	if w.Code != http.StatusTooManyRequests {
+		t.Logf("expected forwarding headers of an untrusted peer to be ignored, actual = %d", w.Code)
+		t.FailNow()
+	}
+	if w := send("10.0.0.2", ""); w.Code != http.StatusOK {
+		t.Logf("expected other client to pass, actual = %d", w.Code)
+		t.FailNow()
+	}
+
+	// the API key is limited whatever the address
+	for i, addr := range []string{"10.0.1.1", "10.0.1.2", "10.0.1.3", "10.0.1.4"} {
+		expected := http.StatusOK
+		if i == 3 {
+			expected = http.StatusTooManyRequests
+		}
+		if w := send(addr, "key-1"); w.Code != expected {
+			t.Logf("expected %d for request %d with API key, actual = %d", expected, i, w.Code)
+			t.FailNow()
+		}
+	}
+}
+
+func TestRateLimitSharedBudget(t *testing.T) {
+	backend := replica("a", nil)
+	defer backend.Close()
+	database.ConnectDatabase()
+	options.RateLimit = NewMemoryRateLimitStore()
+	database.SaveRelease(database.Release{
+		Deployment: database.Deployment{Endpoint: "search"},
+		Proxies:    []database.Proxy{{Id: "p1", BackendCode: "search", BackendAddress: backend.URL}},
+		RateLimits: []database.ProxyRateLimit{
+			{ProxyId: "p1", Key: "ip", Requests: 1, Period: time.Hour},
+			{ProxyId: "p1", Position: 1, Key: "backend", Requests: 3, Period: time.Hour},
+		},
+	})
+	send := func(addr string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/search", nil)
+		req.RemoteAddr = addr + ":1234"
+		w := httptest.NewRecorder()
+		apiProxy(w, req)
+		return w.Code
+	}
+	expected := []struct {
+		addr string
+		code int
+	}{
+		{"10.0.0.1", http.StatusOK},
+		{"10.0.0.1", http.StatusTooManyRequests},
+		{"10.0.0.1", http.StatusTooManyRequests},
+		{"10.0.0.1", http.StatusTooManyRequests},
+		{"10.0.0.2", http.StatusOK},
+		{"10.0.0.3", http.StatusOK},
+		{"10.0.0.4", http.StatusTooManyRequests},
+	}
+	for i, e := range expected {
+		if code := send(e.addr); code != e.code {
+			t.Logf("expected %d for request %d from %s, actual = %d", e.code, i, e.addr, code)
+			t.FailNow()
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package http
+
+import (
+	"bytes"
+	"fmt"
+	"goruf/platform/core"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultConnectTimeout = 30 * time.Second
+	defaultRetryBackoff   = 100 * time.Millisecond
+	defaultMaxBackoff     = 2 * time.Second
+	// maxReplayBody is the largest request body buffered to be retried.
+	maxReplayBody = 1 << 20
+)
+
+func newTransport(t *core.Timeouts) *http.Transport {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	connect := defaultConnectTimeout
+	if t != nil {
+		connect = t.Connect.Or(defaultConnectTimeout)
+		tr.ResponseHeaderTimeout = time.Duration(t.Response)
+	}
+	tr.DialContext = (&net.Dialer{Timeout: connect, KeepAlive: 30 * time.Second}).DialContext
+	return tr
+}
+
+// idempotent reports whether a request with method may safely be sent twice.
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// gatewayError reports whether status tells the upstream failed.
+func gatewayError(status int) bool {
+	switch status {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// RoundTrip sends req, whose URL holds the rewritten path, to an upstream
+// picked for each attempt. Idempotent requests are retried with backoff
+// when the upstream fails.
+func (p *upstreamPool) RoundTrip(req *http.Request) (*http.Response, error) {
+	attempts := 1
+	var body []byte
+	if rt := p.proxy.Retry; rt != nil && idempotent(req.Method) {
+		var ok bool
+		if body, ok = replayable(req); ok {
+			attempts += rt.Attempts
+		}
+	}
+	for n := 0; ; n++ {
+		u, err := p.pick(req)
+		if err != nil {
+			return nil, err
+		}
+		out := req.Clone(req.Context())
+		out.URL.Scheme, out.URL.Host = u.url.Scheme, u.url.Host
+		out.URL.Path = strings.TrimSuffix(u.url.Path, "/") + req.URL.Path
+		out.URL.RawPath = ""
+		if body != nil {
+			out.Body = io.NopCloser(bytes.NewReader(body))
+			out.ContentLength = int64(len(body))
+		}
+		u.active.Add(1)
+		rs, err := p.transport.RoundTrip(out)
+		if err != nil {
+			u.active.Add(-1)
+		} else {
+			rs.Body = &upstreamBody{ReadCloser: rs.Body, upstream: u}
+		}
+		if req.Context().Err() != nil {
+			u.breaker.release()
+			return rs, err
+		}
+		failed := err != nil || gatewayError(rs.StatusCode)
+		p.report(u, failed)
+		if !failed || n+1 >= attempts {
+			if err != nil {
+				return nil, fmt.Errorf("upstream %s: %w", u.url.Host, err)
+			}
+			return rs, nil
+		}
+		if rs != nil {
+			io.Copy(io.Discard, io.LimitReader(rs.Body, maxReplayBody))
+			rs.Body.Close()
+		}
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(backoff(p.proxy.Retry, n)):
+		}
+	}
+}
+
+// replayable reads the body of req so it can be sent again, it reports false
+// and leaves the body intact when it is larger than maxReplayBody.
+func replayable(req *http.Request) ([]byte, bool) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, true
+	}
+	b, err := io.ReadAll(io.LimitReader(req.Body, maxReplayBody+1))
+	if err != nil || len(b) > maxReplayBody {
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(b), req.Body), req.Body}
+		return nil, false
+	}
+	req.Body.Close()
+	if len(b) == 0 {
+		req.Body = http.NoBody
+		return nil, true
+	}
+	return b, true
+}
+
+// backoff returns the wait before retry n+1, doubled after each attempt up
+// to the max backoff and jittered so clients do not retry in lockstep.
+func backoff(rt *core.Retry, n int) time.Duration {
+	d := rt.Backoff.Or(defaultRetryBackoff)
+	max := rt.MaxBackoff.Or(defaultMaxBackoff)
+	if max < d {
+		max = d
+	}
+	for i := 0; i < n && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// upstreamBody counts a request as in flight until its response body is closed.
+type upstreamBody struct {
+	io.ReadCloser
+	upstream *upstream
+	once     sync.Once
+}
+
+func (b *upstreamBody) Close() error {
+	b.once.Do(func() {
+		b.upstream.active.Add(-1)
+	})
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,128 @@
+package http
+
+import (
+	"encoding/json"
+	"goruf/platform/database"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestProxyRetry(t *testing.T) {
+	var down atomic.Bool
+	down.Store(true)
+	a, b := replica("a", &down), replica("b", nil)
+	defer a.Close()
+	defer b.Close()
+	database.ConnectDatabase()
+	database.SaveRelease(database.Release{
+		Deployment: database.Deployment{Endpoint: "shipping"},
+		Proxies: []database.Proxy{{
+			Id:            "p1",
+			BackendCode:   "shipping",
+			Upstreams:     []string{a.URL, b.URL},
+			RetryAttempts: 1,
+			RetryBackoff:  time.Millisecond,
+		}},
+	})
+
+	for i := 0; i < 4; i++ {
+		if rs := get("/api/shipping", nil); rs.StatusCode != http.StatusOK || rs.Header.Get("X-Replica") != "b" {
+			t.Logf("expected GET to be retried on replica b, actual = %d %s", rs.StatusCode, rs.Header.Get("X-Replica"))
+			t.FailNow()
+		}
+	}
+	failures := 0
+	for i := 0; i < 4; i++ {
+		w := httptest.NewRecorder()
+		apiProxy(w, httptest.NewRequest(http.MethodPost, "/api/shipping", strings.NewReader("{}")))
+		if w.Code == http.StatusServiceUnavailable {
+			failures++
+		}
+	}
+	if failures != 2 {
+		t.Logf("expected POST not to be retried, actual failures = %d", failures)
+		t.FailNow()
+	}
+}
+
+func TestProxyResponseTimeout(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer slow.Close()
+	database.ConnectDatabase()
+	database.SaveRelease(database.Release{
+		Deployment: database.Deployment{Endpoint: "reports"},
+		Proxies: []database.Proxy{{
+			Id:              "p1",
+			BackendCode:     "reports",
+			BackendAddress:  slow.URL,
+			ResponseTimeout: 20 * time.Millisecond,
+		}},
+	})
+
+	w := httptest.NewRecorder()
+	apiProxy(w, httptest.NewRequest(http.MethodGet, "/api/reports", nil))
+	var body errorBody
+	json.NewDecoder(w.Body).Decode(&body)
+	if w.Code != http.StatusGatewayTimeout || body.Error != "upstream_timeout" {
+		t.Logf("expected gateway timeout, actual = %d %+v", w.Code, body)
+		t.FailNow()
+	}
+}
+
+func TestProxyCircuitBreaker(t *testing.T) {
+	var down atomic.Bool
+	down.Store(true)
+	a := replica("a", &down)
+	defer a.Close()
+	database.ConnectDatabase()
+	database.SaveRelease(database.Release{
+		Deployment: database.Deployment{Endpoint: "payments"},
+		Proxies: []database.Proxy{{
+			Id:                  "p1",
+			BackendCode:         "payments",
+			BackendAddress:      a.URL,
+			BreakerFailures:     2,
+			BreakerOpenDuration: 50 * time.Millisecond,
+		}},
+	})
+
+	for i := 0; i < 2; i++ {
+		if rs := get("/api/payments", nil); rs.StatusCode != http.StatusServiceUnavailable || rs.Header.Get("Content-Type") == "application/json" {
+			t.Logf("expected failure of the upstream, actual = %d", rs.StatusCode)
+			t.FailNow()
+		}
+	}
+	w := httptest.NewRecorder()
+	apiProxy(w, httptest.NewRequest(http.MethodGet, "/api/payments", nil))
+	var body errorBody
+	json.NewDecoder(w.Body).Decode(&body)
+	if w.Code != http.StatusServiceUnavailable || body.Error != "circuit_open" || body.Backend != "payments" || w.Header().Get("Retry-After") != "1" {
+		t.Logf("expected open circuit, actual = %d %+v", w.Code, body)
+		t.FailNow()
+	}
+
+	// a successful trial request closes the circuit
+	down.Store(false)
+	time.Sleep(60 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		if rs := get("/api/payments", nil); rs.StatusCode != http.StatusOK {
+			t.Logf("expected circuit to close, actual = %d", rs.StatusCode)
+			t.FailNow()
+		}
+	}
+
+	w = httptest.NewRecorder()
+	serveProxies(w, httptest.NewRequest(http.MethodGet, "/api/proxies", nil))
+	var statuses []proxyStatus
+	json.NewDecoder(w.Body).Decode(&statuses)
+	if len(statuses) != 1 || statuses[0].Proxy.CircuitBreaker == nil || len(statuses[0].Upstreams) != 1 || statuses[0].Upstreams[0].Circuit != "closed" {
+		t.Logf("expected proxy status with closed circuit, actual = %+v", statuses)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,286 @@
+package http
+
+import (
+	"encoding/json"
+	"expvar"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	FrameOptionsDeny       = "DENY"
+	FrameOptionsSameOrigin = "SAMEORIGIN"
+)
+
+const (
+	// cspReportPath receives the violation reports of the shells.
+	cspReportPath = "/csp-report"
+	// maxCspReportSize bounds the body of a violation report.
+	maxCspReportSize = 64 * 1024
+	// maxCspReports bounds the reports kept for /api/csp-reports.
+	maxCspReports = 1000
+)
+
+// SecurityOptions configures the security headers of the responses and the
+// Content-Security-Policy of the shells.
+type SecurityOptions struct {
+	// HstsMaxAge is sent to browsers reaching the platform over HTTPS,
+	// HSTS is disabled when zero.
+	HstsMaxAge            time.Duration
+	HstsIncludeSubdomains bool
+	// FrameOptions is DENY or SAMEORIGIN.
+	FrameOptions   string
+	ReferrerPolicy string
+	// CspReportOnly reports violations of the policy without enforcing it.
+	CspReportOnly bool
+}
+
+func DefaultSecurityOptions() SecurityOptions {
+	return SecurityOptions{
+		HstsMaxAge:     365 * 24 * time.Hour,
+		FrameOptions:   FrameOptionsSameOrigin,
+		ReferrerPolicy: "strict-origin-when-cross-origin",
+	}
+}
+
+// cspViolations counts the violation reports received from browsers.
+var cspViolations = expvar.NewInt("csp_violations")
+
+// SecurityHeaders sets the security headers of every response.
+func SecurityHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		opts := options.Security
+		h := w.Header()
+		if opts.HstsMaxAge > 0 && isHttps(r) {
+			hsts := "max-age=" + strconv.Itoa(int(opts.HstsMaxAge.Seconds()))
+			if opts.HstsIncludeSubdomains {
+				hsts += "; includeSubDomains"
+			}
+			h.Set("Strict-Transport-Security", hsts)
+		}
+		h.Set("X-Content-Type-Options", "nosniff")
+		if opts.ReferrerPolicy != "" {
+			h.Set("Referrer-Policy", opts.ReferrerPolicy)
+		}
+		if opts.FrameOptions != "" {
+			h.Set("X-Frame-Options", opts.FrameOptions)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isHttps reports whether the browser reached the platform over HTTPS,
+// directly or through a terminating proxy.
+func isHttps(r *http.Request) bool {
+	return r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+// origin returns the origin of the platform as seen by the browser of r.
+func origin(r *http.Request) string {
+	if isHttps(r) {
+		return "https://" + r.Host
+	}
+	return "http://" + r.Host
+}
+
+// contentSecurityPolicy returns the policy of a shell rendered with nonce.
+// Scripts are allowed from the asset directories of the modules, requests
+// to the prefixes of the API proxy routes and to the platform itself.
+func contentSecurityPolicy(r *http.Request, nonce string, modules []string) string {
+	base := origin(r)
+	scripts := []string{"'nonce-" + nonce + "'"}
+	for _, url := range modules {
+		scripts = append(scripts, sourceOf(base, url))
+	}
+	sort.Strings(scripts[1:])
+	connect := []string{
+		base + "/navigation.json",
+		base + "/auth/",
+		base + "/resource/",
+		base + "/cdn/",
+	}
+	routes := make([]string, 0)
+	for _, rt := range apiRoutes.get() {
+		routes = append(routes, base+strings.TrimSuffix(rt.Prefix, "/")+"/")
+	}
+	sort.Strings(routes)
+	connect = append(connect, routes...)
+	frameAncestors := "'self'"
+	if options.Security.FrameOptions == FrameOptionsDeny {
+		frameAncestors = "'none'"
+	}
+	directives := []string{
+		"default-src 'self'",
+		"script-src " + strings.Join(dedupe(scripts), " "),
+		"style-src 'self' 'nonce-" + nonce + "'",
+		"connect-src " + strings.Join(dedupe(connect), " "),
+		"img-src 'self' data:",
+		"object-src 'none'",
+		"base-uri 'self'",
+		"form-action 'self'",
+		"frame-ancestors " + frameAncestors,
+		"report-uri " + cspReportPath,
+		"report-to csp",
+	}
+	return strings.Join(directives, "; ")
+}
+
+// sourceOf returns the source expression of the directory holding the
+// asset at url, relative urls are resolved against base.
+func sourceOf(base string, url string) string {
+	if strings.HasPrefix(url, "/") && !strings.HasPrefix(url, "//") {
+		url = base + url
+	}
+	return url[:strings.LastIndex(url, "/")+1]
+}
+
+func dedupe(values []string) []string {
+	rs := make([]string, 0, len(values))
+	for _, v := range values {
+		if !contains(rs, v) {
+			rs = append(rs, v)
+		}
+	}
+	return rs
+}
+
+// setContentSecurityPolicy sets the policy of a shell on w.
+func setContentSecurityPolicy(w http.ResponseWriter, policy string) {
+	header := "Content-Security-Policy"
+	if options.Security.CspReportOnly {
+		header = "Content-Security-Policy-Report-Only"
+	}
+	w.Header().Set(header, policy)
+	w.Header().Set("Reporting-Endpoints", `csp="`+cspReportPath+`"`)
+}
+
+// CspReport is a violation of the Content-Security-Policy reported by a browser.
+type CspReport struct {
+	Time               time.Time `json:"time"`
+	DocumentUri        string    `json:"documentUri"`
+	BlockedUri         string    `json:"blockedUri"`
+	ViolatedDirective  string    `json:"violatedDirective"`
+	EffectiveDirective string    `json:"effectiveDirective,omitempty"`
+	SourceFile         string    `json:"sourceFile,omitempty"`
+	LineNumber         int       `json:"lineNumber,omitempty"`
+	Disposition        string    `json:"disposition,omitempty"`
+	UserAgent          string    `json:"userAgent,omitempty"`
+}
+
+// cspReports keeps the latest violation reports, oldest first.
+var cspReports = struct {
+	sync.Mutex
+	reports []CspReport
+}{}
+
+// legacyCspReport is the body sent to report-uri.
+type legacyCspReport struct {
+	Report struct {
+		DocumentUri        string `json:"document-uri"`
+		BlockedUri         string `json:"blocked-uri"`
+		ViolatedDirective  string `json:"violated-directive"`
+		EffectiveDirective string `json:"effective-directive"`
+		SourceFile         string `json:"source-file"`
+		LineNumber         int    `json:"line-number"`
+		Disposition        string `json:"disposition"`
+	} `json:"csp-report"`
+}
+
+// reportingApiReport is a report of the Reporting API sent to report-to.
+type reportingApiReport struct {
+	Type string `json:"type"`
+	Body struct {
+		DocumentUrl        string `json:"documentURL"`
+		BlockedUrl         string `json:"blockedURL"`
+		EffectiveDirective string `json:"effectiveDirective"`
+		SourceFile         string `json:"sourceFile"`
+		LineNumber         int    `json:"lineNumber"`
+		Disposition        string `json:"disposition"`
+	} `json:"body"`
+}
+
+// collectCspReport accepts the violation reports of both report-uri and report-to.
+func collectCspReport(w http.ResponseWriter, r *http.Request) {
+	b, err := io.ReadAll(io.LimitReader(r.Body, maxCspReportSize))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	var reports []CspReport
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/reports+json") {
+		var batch []reportingApiReport
+		if err := json.Unmarshal(b, &batch); err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		for _, rp := range batch {
+			if rp.Type != "csp-violation" {
+				continue
+			}
+			reports = append(reports, CspReport{
+				DocumentUri:        rp.Body.DocumentUrl,
+				BlockedUri:         rp.Body.BlockedUrl,
+				ViolatedDirective:  rp.Body.EffectiveDirective,
+				EffectiveDirective: rp.Body.EffectiveDirective,
+				SourceFile:         rp.Body.SourceFile,
+				LineNumber:         rp.Body.LineNumber,
+				Disposition:        rp.Body.Disposition,
+			})
+		}
+	} else {
+		var legacy legacyCspReport
+		if err := json.Unmarshal(b, &legacy); err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		rp := legacy.Report
+		reports = append(reports, CspReport{
+			DocumentUri:        rp.DocumentUri,
+			BlockedUri:         rp.BlockedUri,
+			ViolatedDirective:  rp.ViolatedDirective,
+			EffectiveDirective: rp.EffectiveDirective,
+			SourceFile:         rp.SourceFile,
+			LineNumber:         rp.LineNumber,
+			Disposition:        rp.Disposition,
+		})
+	}
+	cspReports.Lock()
+	for _, rp := range reports {
+		rp.Time, rp.UserAgent = now, r.UserAgent()
+		log.Warn().
+			Str("document", rp.DocumentUri).
+			Str("blocked", rp.BlockedUri).
+			Str("directive", rp.ViolatedDirective).
+			Str("source", rp.SourceFile).
+			Int("line", rp.LineNumber).
+			Msg("content security policy violation")
+		cspViolations.Add(1)
+		if len(cspReports.reports) >= maxCspReports {
+			cspReports.reports = append(cspReports.reports[:0:0], cspReports.reports[1:]...)
+		}
+		cspReports.reports = append(cspReports.reports, rp)
+	}
+	cspReports.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveCspReports lists the latest violation reports, latest first.
+func serveCspReports(w http.ResponseWriter, r *http.Request) {
+	cspReports.Lock()
+	rs := make([]CspReport, 0, len(cspReports.reports))
+	for i := len(cspReports.reports) - 1; i >= 0; i-- {
+		rs = append(rs, cspReports.reports[i])
+	}
+	cspReports.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(rs)
+}
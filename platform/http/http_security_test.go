@@ -0,0 +1,80 @@
+package http
+
+import (
+	"goruf/platform/core"
+	"goruf/platform/database"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestShellSecurityHeaders(t *testing.T) {
+	database.ConnectDatabase()
+	database.SaveRelease(database.Release{
+		Deployment: database.Deployment{Endpoint: "portal", Kind: string(core.KindContainer)},
+		Proxies:    []database.Proxy{{Id: "p1", BackendCode: "orders", BackendAddress: "localhost:9000"}},
+		Routes:     []database.ProxyRoute{{ProxyId: "p1", Prefix: "/api/orders"}},
+	})
+	database.SaveRelease(database.Release{
+		Deployment: database.Deployment{Endpoint: "cart", Kind: string(core.KindMicroapp), Container: "portal"},
+	})
+	router := newRouter()
+	render := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "http://shop.example/portal/", nil)
+		req.Header.Set("X-Forwarded-Proto", "https")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	w := render()
+	h := w.Header()
+	if h.Get("Strict-Transport-Security") != "max-age=31536000" || h.Get("X-Content-Type-Options") != "nosniff" ||
+		h.Get("X-Frame-Options") != "SAMEORIGIN" || h.Get("Referrer-Policy") != "strict-origin-when-cross-origin" {
+		t.Logf("expected security headers, actual = %v", h)
+		t.FailNow()
+	}
+	csp := h.Get("Content-Security-Policy")
+	m := regexp.MustCompile(`script-src 'nonce-([A-Za-z0-9_-]+)' https://shop.example/cdn/cart/ https://shop.example/cdn/portal/;`).FindStringSubmatch(csp)
+	if m == nil || !strings.Contains(csp, "connect-src https://shop.example/navigation.json") || !strings.Contains(csp, " https://shop.example/api/orders/;") {
+		t.Logf("expected policy derived from the assets and proxies, actual = %s", csp)
+		t.FailNow()
+	}
+	if body := w.Body.String(); strings.Count(body, `nonce="`+m[1]+`"`) != 2 {
+		t.Logf("expected scripts of the shell to carry the nonce %s, actual = %s", m[1], body)
+		t.FailNow()
+	}
+	if other := render().Header().Get("Content-Security-Policy"); strings.Contains(other, m[1]) {
+		t.Logf("expected a nonce per request, actual = %s", other)
+		t.FailNow()
+	}
+}
+
+func TestCollectCspReport(t *testing.T) {
+	cspReports.reports = nil
+	router := newRouter()
+	send := func(contentType, body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/csp-report", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	if code := send("application/csp-report", `{"csp-report":{"document-uri":"https://shop.example/portal/","blocked-uri":"inline","violated-directive":"script-src"}}`); code != http.StatusNoContent {
+		t.Logf("expected report-uri report to be accepted, actual = %d", code)
+		t.FailNow()
+	}
+	if code := send("application/reports+json", `[{"type":"csp-violation","body":{"documentURL":"https://shop.example/portal/","blockedURL":"https://evil.example/x.js","effectiveDirective":"script-src-elem"}},{"type":"deprecation","body":{}}]`); code != http.StatusNoContent {
+		t.Logf("expected report-to reports to be accepted, actual = %d", code)
+		t.FailNow()
+	}
+	if code := send("application/csp-report", `not json`); code != http.StatusBadRequest {
+		t.Logf("expected malformed report to be rejected, actual = %d", code)
+		t.FailNow()
+	}
+	if len(cspReports.reports) != 2 || cspReports.reports[1].BlockedUri != "https://evil.example/x.js" || cspReports.reports[0].ViolatedDirective != "script-src" {
+		t.Logf("expected the violations to be collected, actual = %+v", cspReports.reports)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,163 @@
+package http
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxCookieSize is the size of the chunks a cookie value is split into,
+// browsers drop cookies larger than 4096 bytes.
+const maxCookieSize = 3800
+
+// session is the state of a signed in user, it is kept in an encrypted cookie.
+type session struct {
+	Subject string `json:"sub"`
+	Name    string `json:"name,omitempty"`
+	Email   string `json:"email,omitempty"`
+	// Claims are the claims of the ID token listed in AuthOptions.Claims.
+	Claims       map[string]any `json:"claims,omitempty"`
+	AccessToken  string         `json:"at"`
+	RefreshToken string         `json:"rt,omitempty"`
+	TokenExpiry  time.Time      `json:"tokenExpiry"`
+	// Expiry ends the session, even if the tokens could be refreshed.
+	Expiry time.Time `json:"expiry"`
+}
+
+// authState is the state of a login in progress.
+type authState struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	Redirect string `json:"redirect"`
+}
+
+// cookieCodec encrypts cookie values with AES-GCM, the name of the cookie is
+// authenticated so a value cannot be replayed under another name.
+type cookieCodec struct {
+	aead cipher.AEAD
+}
+
+// newCookieCodec returns a codec for key, keys that are not 32 bytes long
+// are hashed to derive an AES-256 key.
+func newCookieCodec(key []byte) (*cookieCodec, error) {
+	if len(key) != 32 {
+		sum := sha256.Sum256(key)
+		key = sum[:]
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &cookieCodec{aead: aead}, nil
+}
+
+func (c *cookieCodec) seal(name string, v any) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(b)+c.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(c.aead.Seal(nonce, nonce, b, []byte(name))), nil
+}
+
+func (c *cookieCodec) open(name string, value string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return err
+	}
+	if len(b) < c.aead.NonceSize() {
+		return errors.New("cookie is too short")
+	}
+	b, err = c.aead.Open(nil, b[:c.aead.NonceSize()], b[c.aead.NonceSize():], []byte(name))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// chunkName is the name of chunk i of cookie name.
+func chunkName(name string, i int) string {
+	return name + "." + strconv.Itoa(i)
+}
+
+// readCookie returns the value of cookie name, joining its chunks.
+func readCookie(r *http.Request, name string) (string, bool) {
+	if c, err := r.Cookie(name); err == nil {
+		return c.Value, true
+	}
+	var sb strings.Builder
+	for i := 0; ; i++ {
+		c, err := r.Cookie(chunkName(name, i))
+		if err != nil {
+			return sb.String(), i > 0
+		}
+		sb.WriteString(c.Value)
+	}
+}
+
+// writeCookie sets cookie name to value, split into chunks when it is too
+// large, and deletes the chunks of the previous value that are not reused.
+// An empty value deletes the cookie.
+func writeCookie(w http.ResponseWriter, r *http.Request, name string, value string, maxAge time.Duration, secure bool) {
+	cookie := func(name, value string, maxAge int) {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Value:    value,
+			Path:     "/",
+			MaxAge:   maxAge,
+			HttpOnly: true,
+			Secure:   secure,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	age := int(maxAge.Seconds())
+	chunks, single := 0, false
+	switch {
+	case value == "":
+	case len(value) <= maxCookieSize:
+		cookie(name, value, age)
+		single = true
+	default:
+		for ; len(value) > 0; chunks++ {
+			n := min(len(value), maxCookieSize)
+			cookie(chunkName(name, chunks), value[:n], age)
+			value = value[n:]
+		}
+	}
+	if _, err := r.Cookie(name); err == nil && !single {
+		cookie(name, "", -1)
+	}
+	for i := chunks; ; i++ {
+		if _, err := r.Cookie(chunkName(name, i)); err != nil {
+			break
+		}
+		cookie(chunkName(name, i), "", -1)
+	}
+}
+
+// isSessionCookie reports whether name is a cookie of the authentication,
+// which is not forwarded to backends.
+func isSessionCookie(name string, opts AuthOptions) bool {
+	for _, prefix := range []string{opts.Cookie, opts.Cookie + "_auth"} {
+		if name == prefix || strings.HasPrefix(name, prefix+".") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,195 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"goruf/platform/core"
+	"goruf/platform/database"
+	"goruf/platform/storage"
+	"html/template"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// ImportMap lists the modules a shell can import by name, Integrity holds
+// the integrity of the modules keyed by url when it is known.
+type ImportMap struct {
+	Imports   map[string]string `json:"imports"`
+	Integrity map[string]string `json:"integrity,omitempty"`
+}
+
+var shellTemplate = template.Must(template.New("shell").Parse(`<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head>
+<meta charset="utf-8">
+<base href="/{{.Endpoint}}/">
+{{- with .Stylesheet}}
+<link rel="stylesheet" href="{{.Url}}" integrity="{{.Integrity}}" crossorigin="anonymous">
+{{- end}}
+{{.ImportMap}}
+</head>
+<body>
+<div id="root"></div>
+<script type="module" src="{{.Entry.Url}}"{{with .Entry.Integrity}} integrity="{{.}}"{{end}} crossorigin="anonymous" nonce="{{.Nonce}}"></script>
+</body>
+</html>
+`))
+
+type shellData struct {
+	Lang      string
+	Endpoint  string
+	ImportMap template.HTML
+	Entry     core.AssetInfo
+	// Stylesheet is only set when the deployment uploaded index.css.
+	Stylesheet *core.AssetInfo
+	// Nonce allows the scripts of the shell under its Content-Security-Policy.
+	Nonce string
+}
+
+func assetUrl(endpoint string, path string) string {
+	return "/cdn/" + endpoint + "/" + path
+}
+
+// AssetsOf returns the uploaded assets of the release active at endpoint
+// keyed by path.
+func AssetsOf(endpoint string) map[string]core.AssetInfo {
+	rs := make(map[string]core.AssetInfo)
+	r, ok := database.GetRelease(endpoint)
+	if !ok {
+		return rs
+	}
+	for _, a := range r.Assets {
+		rs[a.Path] = core.AssetInfo{
+			Path:      a.Path,
+			Url:       assetUrl(endpoint, a.Path),
+			Digest:    a.Digest,
+			Integrity: a.Integrity,
+		}
+	}
+	return rs
+}
+
+// BuildImportMap maps every microapp mounted into container to its entry module.
+func BuildImportMap(container string) ImportMap {
+	m := ImportMap{Imports: make(map[string]string), Integrity: make(map[string]string)}
+	for _, d := range database.ListDeployments() {
+		spec, _ := core.LookupKind(d.Kind)
+		if spec.Mountable && d.Container == container {
+			url := assetUrl(d.Endpoint, "index.js")
+			m.Imports[d.Endpoint] = url
+			if a, ok := AssetsOf(d.Endpoint)["index.js"]; ok && a.Integrity != "" {
+				m.Integrity[url] = a.Integrity
+			}
+		}
+	}
+	return m
+}
+
+// serveEndpoint dispatches /{endpoint}/* according to the kind of the deployment.
+func serveEndpoint(w http.ResponseWriter, r *http.Request) {
+	d, ok := database.GetDeployment(chi.URLParam(r, "endpoint"))
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	res := Resource{Kind: ResourceShell, Deployment: d.Endpoint, Name: d.Endpoint, Path: r.URL.Path}
+	if !allowed(r, res, AccessOf(d.AccessRoles, d.AccessClaims)) {
+		forbidden(w)
+		return
+	}
+	spec, _ := core.LookupKind(d.Kind)
+	switch {
+	case spec.RendersShell:
+		renderShell(w, r, d)
+	default:
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	}
+}
+
+func renderShell(w http.ResponseWriter, r *http.Request, d database.Deployment) {
+	imports := BuildImportMap(d.Endpoint)
+	importMap, err := json.Marshal(imports)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	assets := AssetsOf(d.Endpoint)
+	entry, ok := assets["index.js"]
+	if !ok {
+		entry = core.AssetInfo{Path: "index.js", Url: assetUrl(d.Endpoint, "index.js")}
+	}
+	var stylesheet *core.AssetInfo
+	if a, ok := assets["index.css"]; ok && a.Integrity != "" {
+		stylesheet = &a
+	}
+	modules := []string{entry.Url}
+	for _, url := range imports.Imports {
+		modules = append(modules, url)
+	}
+	nonce := randomString(16)
+	locale, _ := negotiateLocale(w, r)
+	setContentSecurityPolicy(w, contentSecurityPolicy(r, nonce, modules))
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	err = shellTemplate.Execute(w, shellData{
+		Lang:     locale,
+		Endpoint: d.Endpoint,
+		// json.Marshal escapes <, > and &, so the map cannot close the script element
+		ImportMap:  template.HTML(`<script type="importmap" nonce="` + nonce + `">` + string(importMap) + `</script>`),
+		Entry:      entry,
+		Stylesheet: stylesheet,
+		Nonce:      nonce,
+	})
+	if err != nil {
+		log.Error().Err(err).Str("endpoint", d.Endpoint).Msg("failed to render shell")
+	}
+}
+
+// serveAsset dispatches /cdn/{endpoint}/* to deployments that publish assets.
+func serveAsset(w http.ResponseWriter, r *http.Request) {
+	d, ok := database.GetDeployment(chi.URLParam(r, "endpoint"))
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if spec, _ := core.LookupKind(d.Kind); !spec.PublishesAssets {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	p := chi.URLParam(r, "*")
+	if p == core.AssetManifestPath {
+		serveAssetManifest(w, d)
+		return
+	}
+	a, ok := AssetsOf(d.Endpoint)[p]
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	b, err := storage.Load(a.Digest)
+	if err != nil {
+		log.Error().Err(err).Str("endpoint", d.Endpoint).Str("path", p).Msg("failed to load asset")
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	// the url of an asset is kept across uploads, browsers revalidate it by digest
+	w.Header().Set("ETag", `"`+a.Digest+`"`)
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, r, path.Base(p), time.Time{}, bytes.NewReader(b))
+}
+
+// serveAssetManifest lists the assets of d with their digest and integrity,
+// so that custom shells can load them with Subresource Integrity as well.
+func serveAssetManifest(w http.ResponseWriter, d database.Deployment) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(struct {
+		Endpoint string                    `json:"endpoint"`
+		Version  string                    `json:"version"`
+		Assets   map[string]core.AssetInfo `json:"assets"`
+	}{d.Endpoint, d.Version, AssetsOf(d.Endpoint)})
+}
@@ -0,0 +1,78 @@
+package http
+
+import (
+	"encoding/json"
+	"goruf/platform/core"
+	"goruf/platform/database"
+	"goruf/platform/storage"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestShellIntegrity(t *testing.T) {
+	database.ConnectDatabase()
+	database.SaveRelease(database.Release{
+		Deployment: database.Deployment{Endpoint: "portal", Kind: string(core.KindContainer), Version: "1.0.0"},
+	})
+	database.SaveRelease(database.Release{
+		Deployment: database.Deployment{Endpoint: "cart", Kind: string(core.KindMicroapp), Container: "portal"},
+	})
+	upload := func(endpoint, path, content string) core.AssetInfo {
+		a := database.Asset{Path: path, Digest: core.AssetDigest([]byte(content)), Integrity: core.Integrity([]byte(content))}
+		storage.Store(a.Digest, []byte(content))
+		if err := database.SaveAsset(endpoint, a); err != nil {
+			t.Logf("expected asset to be saved, actual = %v", err)
+			t.FailNow()
+		}
+		return core.AssetInfo{Path: path, Url: assetUrl(endpoint, path), Digest: a.Digest, Integrity: a.Integrity}
+	}
+	upload("portal", "index.js", "old")
+	entry := upload("portal", "index.js", "console.log('portal')")
+	css := upload("portal", "index.css", "body{}")
+	cart := upload("cart", "index.js", "export default {}")
+	router := newRouter()
+	get := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		return w
+	}
+	// html/template may escape the + of the base64 digests as &#43;
+	body := html.UnescapeString(get("/portal/").Body.String())
+	expected := []string{
+		`<script type="module" src="/cdn/portal/index.js" integrity="` + entry.Integrity + `" crossorigin="anonymous"`,
+		`<link rel="stylesheet" href="/cdn/portal/index.css" integrity="` + css.Integrity + `" crossorigin="anonymous">`,
+		`"integrity":{"/cdn/cart/index.js":"` + cart.Integrity + `"}`,
+	}
+	for _, e := range expected {
+		if !strings.Contains(body, e) {
+			t.Logf("expected shell to contain %s, actual = %s", e, body)
+			t.FailNow()
+		}
+	}
+	var manifest struct {
+		Version string
+		Assets  map[string]core.AssetInfo
+	}
+	w := get("/cdn/portal/" + core.AssetManifestPath)
+	if err := json.Unmarshal(w.Body.Bytes(), &manifest); err != nil || w.Code != http.StatusOK {
+		t.Logf("expected asset manifest, actual = %d %s", w.Code, w.Body.String())
+		t.FailNow()
+	}
+	if manifest.Version != "1.0.0" || len(manifest.Assets) != 2 || manifest.Assets["index.js"] != entry || manifest.Assets["index.css"] != css {
+		t.Logf("expected the uploaded assets in the manifest, actual = %+v", manifest)
+		t.FailNow()
+	}
+	w = get("/cdn/portal/index.js")
+	if w.Code != http.StatusOK || w.Body.String() != "console.log('portal')" || w.Header().Get("ETag") != `"`+entry.Digest+`"` ||
+		!strings.HasPrefix(w.Header().Get("Content-Type"), "text/javascript") {
+		t.Logf("expected the uploaded entry to be served, actual = %d %v %s", w.Code, w.Header(), w.Body.String())
+		t.FailNow()
+	}
+	if w = get("/cdn/portal/missing.js"); w.Code != http.StatusNotFound {
+		t.Logf("expected unknown asset to be missing, actual = %d", w.Code)
+		t.FailNow()
+	}
+}
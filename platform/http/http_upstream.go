@@ -0,0 +1,325 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"goruf/platform/core"
+	"hash/fnv"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultHealthInterval     = 10 * time.Second
+	defaultHealthTimeout      = 2 * time.Second
+	defaultHealthyThreshold   = 2
+	defaultUnhealthyThreshold = 3
+	defaultEjectDuration      = 30 * time.Second
+	// ringReplicas is the number of points each upstream has on the hash ring.
+	ringReplicas = 100
+)
+
+var errNoUpstream = errors.New("no healthy upstream")
+
+// circuitOpenError is returned when the circuits of all healthy upstreams
+// of a backend are open.
+type circuitOpenError struct {
+	backend    string
+	retryAfter time.Duration
+}
+
+func (e *circuitOpenError) Error() string {
+	return fmt.Sprintf("circuit of backend %s is open", e.backend)
+}
+
+// upstreamUrl returns the base URL of an address that may be host,
+// host:port or an URL.
+func upstreamUrl(addr string, secure bool) (*url.URL, error) {
+	addr = strings.TrimSpace(addr)
+	if !strings.Contains(addr, "://") {
+		scheme := "http"
+		if secure {
+			scheme = "https"
+		}
+		addr = scheme + "://" + addr
+	}
+	return url.Parse(addr)
+}
+
+type upstream struct {
+	url *url.URL
+	// active is the number of requests in flight.
+	active atomic.Int64
+
+	mu sync.Mutex
+	// healthy is cleared by the active health check.
+	healthy   bool
+	successes int
+	failures  int
+	// consecutive failed requests counted for ejection
+	errors       int
+	ejectedUntil time.Time
+
+	breaker breaker
+}
+
+func (u *upstream) available(now time.Time) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.healthy && !now.Before(u.ejectedUntil)
+}
+
+type ringPoint struct {
+	hash     uint64
+	upstream *upstream
+}
+
+// upstreamPool balances the requests of a proxy across its upstreams and
+// tracks their health.
+type upstreamPool struct {
+	proxy     core.Proxy
+	upstreams []*upstream
+	ring      []ringPoint
+	next      atomic.Uint64
+	stop      chan struct{}
+	// client runs the active health checks.
+	client *http.Client
+	// transport forwards the requests to the upstreams.
+	transport *http.Transport
+}
+
+func newUpstreamPool(p core.Proxy) (*upstreamPool, error) {
+	pool := &upstreamPool{proxy: p, stop: make(chan struct{}), transport: newTransport(p.Timeouts)}
+	for _, addr := range core.Targets(p) {
+		u, err := upstreamUrl(addr, p.Secure)
+		if err != nil {
+			return nil, fmt.Errorf("invalid upstream %s: %w", addr, err)
+		}
+		pool.upstreams = append(pool.upstreams, &upstream{url: u, healthy: true, breaker: breaker{settings: p.CircuitBreaker}})
+	}
+	if len(pool.upstreams) == 0 {
+		return nil, fmt.Errorf("no upstream")
+	}
+	if core.StrategyOf(p) == core.StrategyConsistentHash {
+		for _, u := range pool.upstreams {
+			for i := 0; i < ringReplicas; i++ {
+				pool.ring = append(pool.ring, ringPoint{hash: hashKey(u.url.Host + "#" + strconv.Itoa(i)), upstream: u})
+			}
+		}
+		sort.Slice(pool.ring, func(i, j int) bool {
+			return pool.ring[i].hash < pool.ring[j].hash
+		})
+	}
+	if hc := p.HealthCheck; hc != nil {
+		pool.client = &http.Client{Timeout: hc.Timeout.Or(defaultHealthTimeout)}
+		go pool.checkHealth(hc.Interval.Or(defaultHealthInterval))
+	}
+	return pool, nil
+}
+
+// Close stops the active health checks and closes the idle connections.
+func (p *upstreamPool) Close() {
+	close(p.stop)
+	p.transport.CloseIdleConnections()
+}
+
+// pick returns the upstream serving r according to the strategy of the
+// proxy and admits the request through its circuit breaker.
+func (p *upstreamPool) pick(r *http.Request) (*upstream, error) {
+	now := time.Now()
+	u := p.choose(r, now)
+	if u == nil || !u.breaker.acquire(now) {
+		return nil, p.unavailable(now)
+	}
+	return u, nil
+}
+
+func (p *upstreamPool) choose(r *http.Request, now time.Time) *upstream {
+	usable := func(u *upstream) bool {
+		return u.available(now) && u.breaker.ready(now)
+	}
+	switch core.StrategyOf(p.proxy) {
+	case core.StrategyConsistentHash:
+		h := hashKey(p.hashKey(r))
+		i := sort.Search(len(p.ring), func(i int) bool {
+			return p.ring[i].hash >= h
+		})
+		// walk the ring clockwise to the first usable upstream
+		for n := 0; n < len(p.ring); n++ {
+			if point := p.ring[(i+n)%len(p.ring)]; usable(point.upstream) {
+				return point.upstream
+			}
+		}
+		return nil
+	case core.StrategyLeastConnections:
+		var best *upstream
+		// start after the last pick so ties are spread round-robin
+		start := int(p.next.Add(1))
+		for n := 0; n < len(p.upstreams); n++ {
+			u := p.upstreams[(start+n)%len(p.upstreams)]
+			if usable(u) && (best == nil || u.active.Load() < best.active.Load()) {
+				best = u
+			}
+		}
+		return best
+	default:
+		start := int(p.next.Add(1))
+		for n := 0; n < len(p.upstreams); n++ {
+			if u := p.upstreams[(start+n)%len(p.upstreams)]; usable(u) {
+				return u
+			}
+		}
+		return nil
+	}
+}
+
+// unavailable returns why no upstream could be picked, a circuitOpenError
+// when healthy upstreams are held back by their circuit breaker.
+func (p *upstreamPool) unavailable(now time.Time) error {
+	var err *circuitOpenError
+	for _, u := range p.upstreams {
+		if !u.available(now) || u.breaker.ready(now) {
+			continue
+		}
+		wait := u.breaker.retryAfter(now)
+		if err == nil {
+			err = &circuitOpenError{backend: p.proxy.BackendCode, retryAfter: wait}
+		} else if wait < err.retryAfter {
+			err.retryAfter = wait
+		}
+	}
+	if err == nil {
+		return errNoUpstream
+	}
+	return err
+}
+
+// hashKey returns the value the consistent-hash strategy keys r by.
+func (p *upstreamPool) hashKey(r *http.Request) string {
+	if lb := p.proxy.LoadBalancer; lb != nil {
+		if lb.HashHeader != "" {
+			if v := r.Header.Get(lb.HashHeader); v != "" {
+				return v
+			}
+		}
+		if lb.HashCookie != "" {
+			if c, err := r.Cookie(lb.HashCookie); err == nil {
+				return c.Value
+			}
+		}
+	}
+	return clientIP(r)
+}
+
+func hashKey(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
+
+// report records the outcome of an admitted request for passive ejection
+// and the circuit breaker, failed is set for transport errors and gateway
+// error statuses.
+func (p *upstreamPool) report(u *upstream, failed bool) {
+	now := time.Now()
+	if state, changed := u.breaker.record(failed, now); changed {
+		log.Warn().
+			Str("backend", p.proxy.BackendCode).
+			Str("upstream", u.url.Host).
+			Stringer("circuit", state).
+			Msg("circuit breaker changed state")
+	}
+	e := p.proxy.Ejection
+	if e == nil {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if !failed {
+		u.errors = 0
+		return
+	}
+	u.errors++
+	if u.errors >= e.MaxFailures {
+		u.errors = 0
+		u.ejectedUntil = now.Add(e.Duration.Or(defaultEjectDuration))
+		log.Warn().
+			Str("backend", p.proxy.BackendCode).
+			Str("upstream", u.url.Host).
+			Time("until", u.ejectedUntil).
+			Msg("eject upstream")
+	}
+}
+
+func (p *upstreamPool) checkHealth(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for _, u := range p.upstreams {
+			p.probe(u)
+		}
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probe requests the health check path of u and updates its health once a
+// threshold is reached. Ejections expire on their own.
+func (p *upstreamPool) probe(u *upstream) {
+	hc := p.proxy.HealthCheck
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-p.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	ok := false
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.url.JoinPath(hc.Path).String(), nil)
+	if err == nil {
+		var rs *http.Response
+		if rs, err = p.client.Do(req); err == nil {
+			rs.Body.Close()
+			ok = rs.StatusCode >= 200 && rs.StatusCode < 400
+		}
+	}
+	healthy := hc.HealthyThreshold
+	if healthy <= 0 {
+		healthy = defaultHealthyThreshold
+	}
+	unhealthy := hc.UnhealthyThreshold
+	if unhealthy <= 0 {
+		unhealthy = defaultUnhealthyThreshold
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if ok {
+		u.failures = 0
+		u.successes++
+		if !u.healthy && u.successes >= healthy {
+			u.healthy = true
+			log.Info().Str("backend", p.proxy.BackendCode).Str("upstream", u.url.Host).Msg("upstream is healthy")
+		}
+		return
+	}
+	u.successes = 0
+	u.failures++
+	if u.healthy && u.failures >= unhealthy {
+		u.healthy = false
+		log.Warn().Err(err).Str("backend", p.proxy.BackendCode).Str("upstream", u.url.Host).Msg("upstream is unhealthy")
+	}
+}
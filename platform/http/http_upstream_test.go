@@ -0,0 +1,256 @@
+package http
+
+import (
+	"goruf/platform/core"
+	"goruf/platform/database"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// replica returns a backend answering with its name, or 503 while down is set.
+func replica(name string, down *atomic.Bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down != nil && down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("X-Replica", name)
+	}))
+}
+
+func get(path string, header map[string]string) *http.Response {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	apiProxy(w, req)
+	rs := w.Result()
+	io.Copy(io.Discard, rs.Body)
+	return rs
+}
+
+func TestUpstreamStrategies(t *testing.T) {
+	a, b := replica("a", nil), replica("b", nil)
+	defer a.Close()
+	defer b.Close()
+	database.ConnectDatabase()
+	database.SaveRelease(database.Release{
+		Deployment: database.Deployment{Endpoint: "catalog"},
+		Proxies: []database.Proxy{
+			{Id: "p1", BackendCode: "catalog", BackendAddress: a.URL, Upstreams: []string{b.URL}},
+			{Id: "p2", BackendCode: "cart", Upstreams: []string{a.URL, b.URL}, Strategy: core.StrategyConsistentHash, HashHeader: "X-User"},
+		},
+	})
+
+	counts := make(map[string]int)
+	for i := 0; i < 10; i++ {
+		counts[get("/api/catalog/items", nil).Header.Get("X-Replica")]++
+	}
+	if counts["a"] != 5 || counts["b"] != 5 {
+		t.Logf("expected round-robin across replicas, actual = %v", counts)
+		t.FailNow()
+	}
+
+	for _, user := range []string{"alice", "bob", "carol", "dave"} {
+		first := get("/api/cart", map[string]string{"X-User": user}).Header.Get("X-Replica")
+		for i := 0; i < 5; i++ {
+			if replica := get("/api/cart", map[string]string{"X-User": user}).Header.Get("X-Replica"); replica != first {
+				t.Logf("expected %s to stick to %s, actual = %s", user, first, replica)
+				t.FailNow()
+			}
+		}
+	}
+}
+
+func TestUpstreamLeastConnections(t *testing.T) {
+	pool, err := newUpstreamPool(core.Proxy{
+		BackendCode:  "search",
+		Upstreams:    []string{"a.internal", "b.internal"},
+		LoadBalancer: &core.LoadBalancer{Strategy: core.StrategyLeastConnections},
+	})
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	pool.upstreams[0].active.Store(3)
+	for i := 0; i < 4; i++ {
+		if u, _ := pool.pick(httptest.NewRequest(http.MethodGet, "/", nil)); u.url.Host != "b.internal" {
+			t.Logf("expected least busy upstream, actual = %s", u.url.Host)
+			t.FailNow()
+		}
+	}
+}
+
+func TestUpstreamEjection(t *testing.T) {
+	var down atomic.Bool
+	a, b := replica("a", &down), replica("b", nil)
+	defer a.Close()
+	defer b.Close()
+	database.ConnectDatabase()
+	database.SaveRelease(database.Release{
+		Deployment: database.Deployment{Endpoint: "billing"},
+		Proxies: []database.Proxy{{
+			Id:               "p1",
+			BackendCode:      "billing",
+			Upstreams:        []string{a.URL, b.URL},
+			EjectMaxFailures: 2,
+			EjectDuration:    time.Hour,
+		}},
+	})
+
+	down.Store(true)
+	failures := 0
+	for i := 0; i < 10; i++ {
+		if get("/api/billing", nil).StatusCode == http.StatusServiceUnavailable {
+			failures++
+		}
+	}
+	if failures != 2 {
+		t.Logf("expected replica a to be ejected after 2 failures, actual failures = %d", failures)
+		t.FailNow()
+	}
+
+	// a healthy probe leaves the ejection to expire
+	down.Store(false)
+	rt, _ := findRoute("/api/billing")
+	rt.pool.proxy.HealthCheck = &core.HealthCheck{Path: "/health"}
+	rt.pool.client = http.DefaultClient
+	ejected := rt.pool.upstreams[0]
+	rt.pool.probe(ejected)
+	counts := make(map[string]int)
+	for i := 0; i < 4; i++ {
+		counts[get("/api/billing", nil).Header.Get("X-Replica")]++
+	}
+	if counts["a"] != 0 {
+		t.Logf("expected replica a to stay ejected, actual = %v", counts)
+		t.FailNow()
+	}
+
+	ejected.mu.Lock()
+	ejected.ejectedUntil = time.Now().Add(-time.Second)
+	ejected.mu.Unlock()
+	counts = make(map[string]int)
+	for i := 0; i < 4; i++ {
+		counts[get("/api/billing", nil).Header.Get("X-Replica")]++
+	}
+	if counts["a"] != 2 {
+		t.Logf("expected replica a to recover once the ejection expired, actual = %v", counts)
+		t.FailNow()
+	}
+}
+
+func TestUpstreamHealthCheck(t *testing.T) {
+	var down atomic.Bool
+	a, b := replica("a", &down), replica("b", nil)
+	defer a.Close()
+	defer b.Close()
+	pool, err := newUpstreamPool(core.Proxy{
+		BackendCode: "profile",
+		Upstreams:   []string{a.URL, b.URL},
+		HealthCheck: &core.HealthCheck{
+			Path:               "/health",
+			Interval:           core.Duration(10 * time.Millisecond),
+			HealthyThreshold:   1,
+			UnhealthyThreshold: 2,
+		},
+	})
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer pool.Close()
+
+	await := func(healthy bool) {
+		deadline := time.Now().Add(2 * time.Second)
+		for pool.upstreams[0].available(time.Now()) != healthy {
+			if time.Now().After(deadline) {
+				t.Logf("expected replica a healthy = %v", healthy)
+				t.FailNow()
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	down.Store(true)
+	await(false)
+	for i := 0; i < 4; i++ {
+		if u, _ := pool.pick(httptest.NewRequest(http.MethodGet, "/", nil)); u != pool.upstreams[1] {
+			t.Logf("expected unhealthy replica to be skipped, actual = %s", u.url)
+			t.FailNow()
+		}
+	}
+	down.Store(false)
+	await(true)
+}
+
+func TestUpstreamHashKey(t *testing.T) {
+	previous := options
+	defer func() { options = previous }()
+	options.TrustedProxies, _ = ParseTrustedProxies([]string{"10.0.0.0/8"})
+	pool := &upstreamPool{}
+	tests := []struct {
+		peer    string
+		forward string
+		key     string
+	}{
+		{"[2001:db8::1]:443", "", "2001:db8::1"},
+		{"[2001:db8::2]:443", "", "2001:db8::2"},
+		{"192.0.2.7:1234", "198.51.100.1", "192.0.2.7"},
+		{"10.0.0.2:1234", "198.51.100.1", "198.51.100.1"},
+	}
+	for _, test := range tests {
+		var actual string
+		h := keepPeer(realIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			actual = pool.hashKey(r)
+		})))
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = test.peer
+		if test.forward != "" {
+			req.Header.Set("X-Forwarded-For", test.forward)
+		}
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		if actual != test.key {
+			t.Logf("expected key of %s forwarded for %q to be %s, actual = %s", test.peer, test.forward, test.key, actual)
+			t.FailNow()
+		}
+	}
+}
+
+func TestRouteTableWatch(t *testing.T) {
+	var checks atomic.Int64
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			checks.Add(1)
+		}
+	}))
+	defer backend.Close()
+	database.ConnectDatabase()
+	table, changes := &routeTable{}, make(chan struct{})
+	go table.watch(changes)
+	database.SaveRelease(database.Release{
+		Deployment: database.Deployment{Endpoint: "profile"},
+		Proxies: []database.Proxy{{
+			Id: "p1", BackendCode: "profile", BackendAddress: backend.URL,
+			HealthPath: "/health", HealthInterval: 10 * time.Millisecond,
+		}},
+	})
+	changes <- struct{}{}
+	close(changes)
+	deadline := time.Now().Add(2 * time.Second)
+	for checks.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Logf("expected upstreams to be checked before the first request")
+			t.FailNow()
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	for _, pool := range table.pools {
+		pool.Close()
+	}
+}
@@ -2,12 +2,17 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"fmt"
+	"goruf/platform/core"
 	"goruf/platform/database"
 	"goruf/platform/http"
 	"goruf/platform/storage"
 	"goruf/platform/tcp"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
@@ -68,25 +73,252 @@ func getFlags() []cli.Flag {
 			Usage:   "port to accept connection from client",
 			Value:   8081,
 		},
+		&cli.DurationFlag{
+			Name:    "heartbeat.interval",
+			Sources: cli.EnvVars("HEARTBEAT_INTERVAL"),
+			Usage:   "idle time before a client connection is pinged, 0 disables heartbeat",
+			Value:   30 * time.Second,
+		},
+		&cli.IntFlag{
+			Name:    "heartbeat.max-missed",
+			Sources: cli.EnvVars("HEARTBEAT_MAX_MISSED"),
+			Usage:   "number of unanswered pings before a client connection is closed",
+			Value:   3,
+		},
+		&cli.IntFlag{
+			Name:    "cluster.max-decompressed-size",
+			Sources: cli.EnvVars("CLUSTER_MAX_DECOMPRESSED_SIZE"),
+			Usage:   "max size in bytes of a decompressed message from client",
+			Value:   tcp.DefaultMaxDecompressedSize,
+		},
+		&cli.DurationFlag{
+			Name:    "cluster.read-timeout",
+			Sources: cli.EnvVars("CLUSTER_READ_TIMEOUT"),
+			Usage:   "time a client may take to send a whole frame once it started",
+			Value:   tcp.DefaultReadTimeout,
+		},
+		&cli.StringFlag{
+			Name:    "trusted-keys",
+			Sources: cli.EnvVars("TRUSTED_KEYS"),
+			Usage:   "directory of PEM encoded ed25519 public keys allowed to sign deployments",
+		},
+		&cli.StringFlag{
+			Name:    "cluster.tls-cert",
+			Sources: cli.EnvVars("CLUSTER_TLS_CERT"),
+			Usage:   "PEM encoded certificate served on the cluster port, enables TLS",
+		},
+		&cli.StringFlag{
+			Name:    "cluster.tls-key",
+			Sources: cli.EnvVars("CLUSTER_TLS_KEY"),
+			Usage:   "PEM encoded private key of the cluster certificate",
+		},
+		&cli.StringFlag{
+			Name:    "cluster.tls-client-ca",
+			Sources: cli.EnvVars("CLUSTER_TLS_CLIENT_CA"),
+			Usage:   "PEM encoded CA certificates clients must be signed by",
+		},
+		&cli.StringFlag{
+			Name:    "cluster.token",
+			Sources: cli.EnvVars("CLUSTER_TOKEN"),
+			Usage:   "token clients must present when connecting",
+		},
+		&cli.StringFlag{
+			Name:    "storage.dir",
+			Sources: cli.EnvVars("STORAGE_DIR"),
+			Usage:   "directory uploaded assets are kept in, they are kept in memory when empty",
+		},
+		&cli.StringFlag{
+			Name:    "tls-cert",
+			Sources: cli.EnvVars("TLS_CERT"),
+			Usage:   "PEM encoded certificate served on the main port, enables HTTPS",
+		},
+		&cli.StringFlag{
+			Name:    "tls-key",
+			Sources: cli.EnvVars("TLS_KEY"),
+			Usage:   "PEM encoded private key of the main certificate",
+		},
+		&cli.StringFlag{
+			Name:    "admin.client-ca",
+			Sources: cli.EnvVars("ADMIN_CLIENT_CA"),
+			Usage:   "PEM encoded CA certificates operators of the admin API may be signed by, requires tls-cert",
+		},
+		&cli.StringSliceFlag{
+			Name:    "admin.tokens",
+			Sources: cli.EnvVars("ADMIN_TOKENS"),
+			Usage:   "bearer tokens of the admin API as name=token",
+		},
+		&cli.StringSliceFlag{
+			Name:    "trusted-proxies",
+			Sources: cli.EnvVars("TRUSTED_PROXIES"),
+			Usage:   "addresses or CIDR ranges of proxies whose X-Forwarded-For and X-Real-IP headers name the client",
+		},
+		&cli.DurationFlag{
+			Name:    "security.hsts-max-age",
+			Sources: cli.EnvVars("SECURITY_HSTS_MAX_AGE"),
+			Usage:   "max age of Strict-Transport-Security sent over HTTPS, 0 disables it",
+			Value:   http.DefaultSecurityOptions().HstsMaxAge,
+		},
+		&cli.StringFlag{
+			Name:    "security.frame-options",
+			Sources: cli.EnvVars("SECURITY_FRAME_OPTIONS"),
+			Usage:   "pages of the platform may be framed by the same origin (SAMEORIGIN) or not at all (DENY)",
+			Value:   http.DefaultSecurityOptions().FrameOptions,
+		},
+		&cli.BoolFlag{
+			Name:    "security.csp-report-only",
+			Sources: cli.EnvVars("SECURITY_CSP_REPORT_ONLY"),
+			Usage:   "report violations of the Content-Security-Policy of the shells without enforcing it",
+		},
+		&cli.StringFlag{
+			Name:    "default-locale",
+			Sources: cli.EnvVars("DEFAULT_LOCALE"),
+			Usage:   "locale served when none of the locales asked for by a browser is available",
+			Value:   "en",
+		},
+		&cli.StringFlag{
+			Name:    "auth.issuer",
+			Sources: cli.EnvVars("AUTH_ISSUER"),
+			Usage:   "issuer URL of the OpenID provider users sign in at, enables authentication",
+		},
+		&cli.StringFlag{
+			Name:    "auth.client-id",
+			Sources: cli.EnvVars("AUTH_CLIENT_ID"),
+			Usage:   "client id of the platform at the OpenID provider",
+		},
+		&cli.StringFlag{
+			Name:    "auth.client-secret",
+			Sources: cli.EnvVars("AUTH_CLIENT_SECRET"),
+			Usage:   "client secret of the platform, public clients rely on PKCE only",
+		},
+		&cli.StringFlag{
+			Name:    "auth.redirect-url",
+			Sources: cli.EnvVars("AUTH_REDIRECT_URL"),
+			Usage:   "absolute URL of /auth/callback as seen by browsers",
+		},
+		&cli.StringSliceFlag{
+			Name:    "auth.scopes",
+			Sources: cli.EnvVars("AUTH_SCOPES"),
+			Usage:   "scopes requested at sign in",
+			Value:   http.DefaultAuthOptions().Scopes,
+		},
+		&cli.StringFlag{
+			Name:    "auth.session-key",
+			Sources: cli.EnvVars("AUTH_SESSION_KEY"),
+			Usage:   "secret encrypting the session cookies, shared by all servers",
+		},
+		&cli.DurationFlag{
+			Name:    "auth.session-ttl",
+			Sources: cli.EnvVars("AUTH_SESSION_TTL"),
+			Usage:   "time after which users sign in again",
+			Value:   http.DefaultAuthOptions().SessionTtl,
+		},
+		&cli.StringSliceFlag{
+			Name:    "auth.claims",
+			Sources: cli.EnvVars("AUTH_CLAIMS"),
+			Usage:   "claims of the ID token kept in the session, roles and claims required by deployments are matched against them",
+			Value:   http.DefaultAuthOptions().Claims,
+		},
+		&cli.BoolFlag{
+			Name:    "require-signature",
+			Sources: cli.EnvVars("REQUIRE_SIGNATURE"),
+			Usage:   "reject deployments that are not signed by a trusted key",
+		},
 	}
 }
 
 func run(ctx context.Context, cmd *cli.Command) error {
 	httpPort := cmd.Int("port")
 	clusterPort := cmd.Int("cluster.port")
-	err := database.ConnectDatabase()
+	serverOpts := tcp.ServerOptions{
+		Heartbeat:           core.NewHeartbeat(cmd.Duration("heartbeat.interval"), int(cmd.Int("heartbeat.max-missed"))),
+		MaxDecompressedSize: cmd.Int("cluster.max-decompressed-size"),
+		ReadTimeout:         cmd.Duration("cluster.read-timeout"),
+	}
+	if certFile := cmd.String("cluster.tls-cert"); certFile != "" {
+		tlsConfig, err := tcp.ServerTLSConfig(certFile, cmd.String("cluster.tls-key"), cmd.String("cluster.tls-client-ca"))
+		if err != nil {
+			return err
+		}
+		serverOpts.TLSConfig = tlsConfig
+	}
+	trustedKeys, err := core.LoadTrustedKeys(cmd.String("trusted-keys"))
+	if err != nil {
+		return err
+	}
+	config := &ServerConfig{
+		TrustedKeys:      trustedKeys,
+		RequireSignature: cmd.Bool("require-signature"),
+		Token:            cmd.String("cluster.token"),
+		Version:          version,
+		StartedAt:        time.Now(),
+		MaxMessageSize:   cmd.Int("cluster.max-decompressed-size"),
+	}
+	err = database.ConnectDatabase()
+	if err != nil {
+		return err
+	}
+	err = storage.ConnectStorage(cmd.String("storage.dir"))
 	if err != nil {
 		return err
 	}
-	err = storage.ConnectStorage()
+	webOpts := http.DefaultOptions()
+	webOpts.Locale.Default, err = core.CanonicalLocale(cmd.String("default-locale"))
+	if err != nil {
+		return fmt.Errorf("invalid default locale: %w", err)
+	}
+	webOpts.Auth.Issuer = cmd.String("auth.issuer")
+	webOpts.Auth.ClientId = cmd.String("auth.client-id")
+	webOpts.Auth.ClientSecret = cmd.String("auth.client-secret")
+	webOpts.Auth.RedirectUrl = cmd.String("auth.redirect-url")
+	webOpts.Auth.Scopes = cmd.StringSlice("auth.scopes")
+	webOpts.Auth.SessionKey = []byte(cmd.String("auth.session-key"))
+	webOpts.Auth.SessionTtl = cmd.Duration("auth.session-ttl")
+	webOpts.Auth.Claims = cmd.StringSlice("auth.claims")
+	webOpts.Security.HstsMaxAge = cmd.Duration("security.hsts-max-age")
+	webOpts.Security.FrameOptions = strings.ToUpper(cmd.String("security.frame-options"))
+	if fo := webOpts.Security.FrameOptions; fo != http.FrameOptionsDeny && fo != http.FrameOptionsSameOrigin {
+		return fmt.Errorf("frame options must be %s or %s", http.FrameOptionsDeny, http.FrameOptionsSameOrigin)
+	}
+	webOpts.Security.CspReportOnly = cmd.Bool("security.csp-report-only")
+	webOpts.Admin.Tokens, err = parseAdminTokens(cmd.StringSlice("admin.tokens"))
 	if err != nil {
 		return err
 	}
-	err = http.StartWebService(httpPort)
+	webOpts.TrustedProxies, err = http.ParseTrustedProxies(cmd.StringSlice("trusted-proxies"))
 	if err != nil {
 		return err
 	}
-	return tcp.OpenListener(int(clusterPort), func() tcp.MessageHandler {
-		return NewServerMessageHandler()
+	if certFile := cmd.String("tls-cert"); certFile != "" {
+		tlsConfig, err := tcp.ServerTLSConfig(certFile, cmd.String("tls-key"), cmd.String("admin.client-ca"))
+		if err != nil {
+			return err
+		}
+		// browsers do not present certificates, only operators do
+		if tlsConfig.ClientCAs != nil {
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+		webOpts.TLSConfig = tlsConfig
+	} else if cmd.String("admin.client-ca") != "" {
+		return fmt.Errorf("admin.client-ca requires tls-cert")
+	}
+	err = http.StartWebService(httpPort, webOpts)
+	if err != nil {
+		return err
+	}
+	return tcp.OpenListener(int(clusterPort), serverOpts, func() tcp.MessageHandler {
+		return NewServerMessageHandler(config)
 	})
 }
+
+// parseAdminTokens reads tokens given as name=token.
+func parseAdminTokens(values []string) (map[string]string, error) {
+	tokens := make(map[string]string)
+	for _, v := range values {
+		name, token, ok := strings.Cut(v, "=")
+		if !ok || name == "" || token == "" {
+			return nil, fmt.Errorf("admin token must be given as name=token")
+		}
+		tokens[name] = token
+	}
+	return tokens, nil
+}
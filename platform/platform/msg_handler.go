@@ -1,19 +1,52 @@
 package main
 
 import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"goruf/platform/core"
+	"goruf/platform/database"
+	"goruf/platform/http"
+	"goruf/platform/storage"
 	"goruf/platform/tcp"
 	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
 )
 
+type ServerConfig struct {
+	TrustedKeys core.TrustedKeys
+	// RequireSignature rejects deployments that are not signed by a trusted key.
+	RequireSignature bool
+	// Token, when set, must be presented in the handshake before any other request.
+	Token string
+	// Version and StartedAt are reported by the status command.
+	Version   string
+	StartedAt time.Time
+	// MaxMessageSize bounds the size of a message reassembled from its pages,
+	// tcp.DefaultMaxDecompressedSize when not set.
+	MaxMessageSize int64
+}
+
+// mutations serializes the commands changing releases across connections,
+// each of them checks the active releases before saving its own.
+var mutations sync.Mutex
+
 type ServerMessageHandler struct {
-	queue []tcp.Msg
+	config        *ServerConfig
+	queue         []tcp.Msg
+	queued        int64
+	authenticated bool
+	peer          tcp.Peer
 }
 
-func NewServerMessageHandler() tcp.MessageHandler {
+func NewServerMessageHandler(config *ServerConfig) tcp.MessageHandler {
 	return &ServerMessageHandler{
-		queue: make([]tcp.Msg, 0),
+		config: config,
+		queue:  make([]tcp.Msg, 0),
 	}
 }
 
@@ -21,6 +54,15 @@ func (s *ServerMessageHandler) Handle(msg tcp.Msg) ([]byte, error) {
 	if msg.TotalPage == 1 {
 		return s.handle(msg.Payload)
 	}
+	limit := s.config.MaxMessageSize
+	if limit <= 0 {
+		limit = tcp.DefaultMaxDecompressedSize
+	}
+	s.queued += int64(len(msg.Payload))
+	if s.queued > limit {
+		s.Close()
+		return nil, fmt.Errorf("message exceeds %d bytes", limit)
+	}
 	s.queue = append(s.queue, msg)
 	if len(s.queue) < int(msg.TotalPage) {
 		return nil, nil
@@ -32,29 +74,689 @@ func (s *ServerMessageHandler) Handle(msg tcp.Msg) ([]byte, error) {
 	for _, msg := range s.queue {
 		payload = append(payload, msg.Payload...)
 	}
-	s.queue = make([]tcp.Msg, 0)
+	s.Close()
 	return s.handle(payload)
 }
 
+// SetPeer keeps the client of the connection for the audit log.
+func (s *ServerMessageHandler) SetPeer(p tcp.Peer) {
+	s.peer = p
+}
+
+// Close drops pages of uploads that were still being staged when the connection ended.
+func (s *ServerMessageHandler) Close() {
+	s.queue = make([]tcp.Msg, 0)
+	s.queued = 0
+}
+
 func (s *ServerMessageHandler) handle(b []byte) ([]byte, error) {
 	cmd, err := tcp.GetTlv(tcp.TypeCmd, b)
 	if err != nil {
 		return nil, err
 	}
+	if err := s.authenticate(cmd.GetUInt32(), b); err != nil {
+		return nil, err
+	}
 	switch cmd.GetUInt32() {
 	case core.CmdConnectReq:
 		{
-			return tcp.Join(tcp.TlvUInt32(tcp.TypeCmd, core.CmdConnectRep)), nil
+			if payload, err := tcp.GetTlv(tcp.TypePayload, b); err == nil {
+				dryRun, _ := tcp.GetTlv(core.TypeDryRun, b)
+				diff, err := s.deploy(payload.Value, b, dryRun.GetBool())
+				if err != nil {
+					return nil, err
+				}
+				if diff == nil {
+					return tcp.Join(tcp.TlvUInt32(tcp.TypeCmd, core.CmdConnectRep)), nil
+				}
+				return jsonReply(core.CmdConnectRep, diff)
+			}
+			offered, err := tcp.GetTlv(tcp.TypeCodec, b)
+			if err != nil {
+				return tcp.Join(tcp.TlvUInt32(tcp.TypeCmd, core.CmdConnectRep)), nil
+			}
+			return tcp.Join(
+				tcp.TlvUInt32(tcp.TypeCmd, core.CmdConnectRep),
+				tcp.TlvString(tcp.TypeCodec, tcp.NegotiateCodec(offered.GetString())),
+			), nil
+		}
+	case core.CmdRollbackReq:
+		{
+			endpoint, err := tcp.GetTlv(core.TypeEndpoint, b)
+			if err != nil {
+				return nil, fmt.Errorf("endpoint is missing")
+			}
+			r, restored, err := s.rollback(core.NormalizeEndpoint(endpoint.GetString()))
+			if err != nil {
+				return nil, err
+			}
+			log.Info().
+				Str("endpoint", endpoint.GetString()).
+				Bool("restored", restored).
+				Str("version", r.Deployment.Version).
+				Msg("rollback deployment")
+			return tcp.Join(tcp.TlvUInt32(tcp.TypeCmd, core.CmdRollbackRep)), nil
+		}
+	case core.CmdListReq:
+		{
+			ds := database.ListDeployments()
+			rs := make([]core.DeploymentInfo, 0, len(ds))
+			for _, d := range ds {
+				r, _ := database.GetRelease(d.Endpoint)
+				rs = append(rs, toInfo(r, true, false))
+			}
+			return jsonReply(core.CmdListRep, rs)
+		}
+	case core.CmdGetReq:
+		{
+			endpoint, err := requestEndpoint(b)
+			if err != nil {
+				return nil, err
+			}
+			r, ok := database.GetRelease(endpoint)
+			if !ok {
+				return nil, fmt.Errorf("%s is not deployed", endpoint)
+			}
+			return jsonReply(core.CmdGetRep, toInfo(r, true, true))
+		}
+	case core.CmdDeleteReq:
+		{
+			endpoint, err := requestEndpoint(b)
+			if err != nil {
+				return nil, err
+			}
+			r, err := s.delete(endpoint)
+			if err != nil {
+				return nil, err
+			}
+			log.Info().
+				Str("endpoint", endpoint).
+				Str("version", r.Deployment.Version).
+				Msg("delete deployment")
+			return tcp.Join(tcp.TlvUInt32(tcp.TypeCmd, core.CmdDeleteRep)), nil
+		}
+	case core.CmdReleasesReq:
+		{
+			endpoint, err := requestEndpoint(b)
+			if err != nil {
+				return nil, err
+			}
+			releases, ok := database.ListReleases(endpoint)
+			if !ok {
+				return nil, fmt.Errorf("%s is not deployed", endpoint)
+			}
+			rs := make([]core.DeploymentInfo, 0, len(releases))
+			for i, r := range releases {
+				rs = append(rs, toInfo(r, i == 0, false))
+			}
+			return jsonReply(core.CmdReleasesRep, rs)
+		}
+	case core.CmdStatusReq:
+		{
+			deployments, releases := database.CountReleases()
+			return jsonReply(core.CmdStatusRep, core.ServerStatus{
+				Version:     s.config.Version,
+				StartedAt:   s.config.StartedAt,
+				Deployments: deployments,
+				Releases:    releases,
+				Connections: tcp.ActiveConnections(),
+			})
 		}
 	case core.CmdUploadJsReq:
 		{
-			return tcp.Join(tcp.TlvUInt32(tcp.TypeCmd, core.CmdUploadJsRep)), nil
+			return s.upload(core.CmdUploadJsRep, b)
 		}
 	case core.CmdUploadCssReq:
 		{
-			return tcp.Join(tcp.TlvUInt32(tcp.TypeCmd, core.CmdUploadCssRep)), nil
+			return s.upload(core.CmdUploadCssRep, b)
+		}
+	case core.CmdPingReq:
+		{
+			return tcp.Join(tcp.TlvUInt32(tcp.TypeCmd, core.CmdPingRep)), nil
+		}
+	case core.CmdPingRep:
+		{
+			return nil, nil
 		}
 	default:
 		return nil, fmt.Errorf("")
 	}
 }
+
+// upload adds the asset in the payload of b to the active release of its
+// endpoint and answers rep with its digest and integrity. Requests without
+// payload are acknowledged only.
+func (s *ServerMessageHandler) upload(rep uint32, b []byte) ([]byte, error) {
+	payload, err := tcp.GetTlv(tcp.TypePayload, b)
+	if err != nil {
+		return tcp.Join(tcp.TlvUInt32(tcp.TypeCmd, rep)), nil
+	}
+	endpoint, err := requestEndpoint(b)
+	if err != nil {
+		return nil, err
+	}
+	p, err := tcp.GetTlv(core.TypePath, b)
+	if err != nil {
+		return nil, fmt.Errorf("asset path is missing")
+	}
+	path, err := core.CleanAssetPath(p.GetString())
+	if err != nil {
+		return nil, err
+	}
+	a := database.Asset{
+		Id:        database.NewId(),
+		Path:      path,
+		Digest:    core.AssetDigest(payload.Value),
+		Integrity: core.Integrity(payload.Value),
+	}
+	mutations.Lock()
+	before, _ := database.GetRelease(endpoint)
+	if err = s.checkUpload(endpoint, before, a); err == nil {
+		if err = storage.Store(a.Digest, payload.Value); err == nil {
+			err = database.SaveAsset(endpoint, a)
+		}
+	}
+	s.audit("upload", endpoint, &before, err)
+	mutations.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	log.Info().
+		Str("endpoint", endpoint).
+		Str("path", path).
+		Str("digest", a.Digest).
+		Msg("upload asset")
+	return jsonReply(rep, core.AssetInfo{Path: path, Digest: a.Digest, Integrity: a.Integrity})
+}
+
+// checkUpload makes sure a may be added to r, the release active at
+// endpoint. The assets of a release sent with a manifest, and of any signed
+// release, must be listed in the manifest with the same digest.
+func (s *ServerMessageHandler) checkUpload(endpoint string, r database.Release, a database.Asset) error {
+	if r.Deployment.Endpoint == "" {
+		return fmt.Errorf("%s is not deployed", endpoint)
+	}
+	if r.Deployment.Signer == "" && r.Manifest == nil {
+		if s.config.RequireSignature {
+			return fmt.Errorf("assets of %s must be declared by a signed deployment", endpoint)
+		}
+		return nil
+	}
+	digest, ok := r.Manifest[a.Path]
+	if !ok {
+		return fmt.Errorf("%s is not declared by the manifest of %s", a.Path, endpoint)
+	}
+	if digest != a.Digest {
+		return fmt.Errorf("digest of %s does not match the manifest of %s", a.Path, endpoint)
+	}
+	return nil
+}
+
+// delete removes the deployment at endpoint unless it mounts other deployments.
+func (s *ServerMessageHandler) rollback(endpoint string) (database.Release, bool, error) {
+	mutations.Lock()
+	defer mutations.Unlock()
+	before, _ := database.GetRelease(endpoint)
+	r, restored, err := database.RollbackRelease(endpoint)
+	s.audit("rollback", endpoint, &before, err)
+	return r, restored, err
+}
+
+func (s *ServerMessageHandler) delete(endpoint string) (r database.Release, err error) {
+	mutations.Lock()
+	defer mutations.Unlock()
+	before, _ := database.GetRelease(endpoint)
+	defer func() { s.audit("delete", endpoint, &before, err) }()
+	for _, d := range database.ListDeployments() {
+		if d.Container == endpoint && d.Endpoint != endpoint {
+			return r, fmt.Errorf("%s still mounts %s", endpoint, d.Endpoint)
+		}
+	}
+	return database.DeleteRelease(endpoint)
+}
+
+// deploy validates the deployment in payload and activates it, or only
+// returns the diff against the active release when dryRun is set.
+func (s *ServerMessageHandler) deploy(payload []byte, b []byte, dryRun bool) (diff *core.ReleaseDiff, err error) {
+	mutations.Lock()
+	defer mutations.Unlock()
+	// the endpoint and its release are known once the payload is parsed
+	var endpoint string
+	var before *database.Release
+	if !dryRun {
+		defer func() { s.audit("deploy", endpoint, before, err) }()
+	}
+	contentType := ""
+	if ct, err := tcp.GetTlv(core.TypeContentType, b); err == nil {
+		contentType = ct.GetString()
+	}
+	format, err := core.FormatOfContentType(contentType)
+	if err != nil {
+		return nil, err
+	}
+	depl, err := core.ParseDeploymentAs(payload, format, false)
+	if err != nil {
+		return nil, err
+	}
+	assets, err := requestAssets(b)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := s.verify(core.Manifest{Deployment: payload, Assets: assets}, b)
+	if err != nil {
+		return nil, err
+	}
+	spec, _ := core.LookupKind(string(depl.Kind))
+	endpoint = core.NormalizeEndpoint(depl.Endpoint)
+	current, deployed := database.GetRelease(endpoint)
+	if deployed {
+		before = &current
+	}
+	if deployed && current.Deployment.Kind != string(spec.Name) {
+		return nil, fmt.Errorf("%s is already deployed as %s", endpoint, current.Deployment.Kind)
+	}
+	var warnings []string
+	if err := checkContainer(spec, depl); err != nil {
+		if !dryRun {
+			return nil, err
+		}
+		// the container may be part of the same batch
+		warnings = append(warnings, err.Error())
+	}
+	if err := checkNavigations(endpoint, depl.Navigations); err != nil {
+		if !dryRun {
+			return nil, err
+		}
+		// the conflicting deployment may be changed by the same batch
+		warnings = append(warnings, err.Error())
+	}
+	if err := checkProxies(endpoint, depl.Proxies); err != nil {
+		if !dryRun {
+			return nil, err
+		}
+		warnings = append(warnings, err.Error())
+	}
+	release := toRelease(depl, signer)
+	release.Manifest = assets
+	release.Assets = carryAssets(current, release)
+	if dryRun {
+		var before *core.Release
+		if deployed {
+			r := fromRelease(current)
+			before = &r
+		}
+		// the diff shows the release as it would be activated
+		next := core.Release{Deployment: depl, Assets: fromRelease(release).Assets}
+		diff := core.DiffReleases(before, next)
+		diff.Warnings = warnings
+		return &diff, nil
+	}
+	log.Info().
+		Str("endpoint", endpoint).
+		Str("kind", string(spec.Name)).
+		Str("version", depl.Version).
+		Str("signer", signer).
+		Msg("activate deployment")
+	return nil, database.SaveRelease(release)
+}
+
+// carryAssets returns the assets of the current release attached to the
+// deployment of next, uploads are kept across deployments. A release sent
+// with a manifest, or signed, only keeps the assets its manifest lists.
+func carryAssets(current database.Release, next database.Release) []database.Asset {
+	bound := next.Deployment.Signer != "" || next.Manifest != nil
+	rs := make([]database.Asset, 0, len(current.Assets))
+	for _, a := range current.Assets {
+		if bound && next.Manifest[a.Path] != a.Digest {
+			continue
+		}
+		a.Id = database.NewId()
+		a.DeploymentId = next.Deployment.Id
+		rs = append(rs, a)
+	}
+	return rs
+}
+
+// requestAssets returns the digests of the assets sent with a deployment,
+// nil when there are none.
+func requestAssets(b []byte) (map[string]string, error) {
+	tlv, err := tcp.GetTlv(core.TypeAssets, b)
+	if err != nil || tlv.IsNullOrEmpty() {
+		return nil, nil
+	}
+	var assets map[string]string
+	if err := json.Unmarshal(tlv.Value, &assets); err != nil {
+		return nil, fmt.Errorf("assets are malformed: %w", err)
+	}
+	return assets, core.ValidateAssets(assets)
+}
+
+// checkContainer makes sure the container a mountable deployment names is deployed.
+func checkContainer(spec core.KindSpec, depl core.DeploymentRequest) error {
+	if !spec.Mountable {
+		return nil
+	}
+	endpoint := core.NormalizeEndpoint(depl.Endpoint)
+	container := core.NormalizeEndpoint(depl.Container)
+	c, ok := database.GetDeployment(container)
+	if !ok {
+		return fmt.Errorf("container %s of %s is not deployed", container, endpoint)
+	}
+	if cs, _ := core.LookupKind(c.Kind); !cs.RendersShell {
+		return fmt.Errorf("%s is a %s and cannot mount %s", container, c.Kind, endpoint)
+	}
+	return nil
+}
+
+// checkNavigations makes sure no other deployment declares the navigation
+// endpoints of the deployment at endpoint.
+func checkNavigations(endpoint string, navigations []core.Navigation) error {
+	sources := http.NavigationSources()
+	sources[endpoint] = navigations
+	_, conflicts := core.MergeNavigations(sources)
+	var errs []error
+	for _, c := range conflicts {
+		switch endpoint {
+		case c.Deployment:
+			errs = append(errs, c)
+		case c.DeclaredBy:
+			// reported from the point of view of the deployment being activated
+			errs = append(errs, core.NavigationConflict{Endpoint: c.Endpoint, Deployment: endpoint, DeclaredBy: c.Deployment})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// checkProxies makes sure the backend codes and route prefixes of proxies are
+// not taken by another deployment, routes of all deployments share /api.
+func checkProxies(endpoint string, proxies []core.Proxy) error {
+	var errs []error
+	for _, r := range database.ActiveReleases() {
+		if r.Deployment.Endpoint == endpoint {
+			continue
+		}
+		for _, other := range http.ProxiesOf(r) {
+			for _, p := range proxies {
+				if p.BackendCode == other.BackendCode {
+					errs = append(errs, fmt.Errorf("backend %s is already declared by %s", p.BackendCode, r.Deployment.Endpoint))
+					continue
+				}
+				for _, route := range core.RoutesOf(p) {
+					for _, taken := range core.RoutesOf(other) {
+						if route.Overlaps(taken) {
+							errs = append(errs, fmt.Errorf("route %s of %s overlaps route %s of %s", route.Prefix, p.BackendCode, taken.Prefix, r.Deployment.Endpoint))
+						}
+					}
+				}
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func toRelease(depl core.DeploymentRequest, signer string) database.Release {
+	spec, _ := core.LookupKind(string(depl.Kind))
+	endpoint := core.NormalizeEndpoint(depl.Endpoint)
+	d := database.Deployment{
+		Id:        database.NewId(),
+		Kind:      string(spec.Name),
+		Name:      endpoint,
+		Version:   depl.Version,
+		Endpoint:  endpoint,
+		Container: core.NormalizeEndpoint(depl.Container),
+		Signer:    signer,
+		Locales:   depl.Locales,
+		CreatedAt: time.Now(),
+	}
+	if a := depl.Access; a != nil {
+		d.AccessRoles, d.AccessClaims = a.Roles, a.Claims
+	}
+	if c := depl.Cors; c != nil {
+		d.CorsOrigins, d.CorsMethods, d.CorsHeaders = c.AllowedOrigins, c.AllowedMethods, c.AllowedHeaders
+		d.CorsExposedHeaders, d.CorsCredentials, d.CorsMaxAge = c.ExposedHeaders, c.AllowCredentials, time.Duration(c.MaxAge)
+	}
+	r := database.Release{Deployment: d}
+	r.Navigations = flattenNavigations(depl.Navigations, d.Id, "", r.Navigations)
+	for _, p := range depl.Proxies {
+		proxy := database.Proxy{
+			Id:             database.NewId(),
+			DeploymentId:   d.Id,
+			BackendCode:    p.BackendCode,
+			BackendAddress: p.BackendAddress,
+			Secure:         p.Secure,
+			Upstreams:      p.Upstreams,
+		}
+		if lb := p.LoadBalancer; lb != nil {
+			proxy.Strategy, proxy.HashHeader, proxy.HashCookie = lb.Strategy, lb.HashHeader, lb.HashCookie
+		}
+		if hc := p.HealthCheck; hc != nil {
+			proxy.HealthPath = hc.Path
+			proxy.HealthInterval = time.Duration(hc.Interval)
+			proxy.HealthTimeout = time.Duration(hc.Timeout)
+			proxy.HealthyThreshold = hc.HealthyThreshold
+			proxy.UnhealthyThreshold = hc.UnhealthyThreshold
+		}
+		if e := p.Ejection; e != nil {
+			proxy.EjectMaxFailures = e.MaxFailures
+			proxy.EjectDuration = time.Duration(e.Duration)
+		}
+		if t := p.Timeouts; t != nil {
+			proxy.ConnectTimeout = time.Duration(t.Connect)
+			proxy.ResponseTimeout = time.Duration(t.Response)
+		}
+		if rt := p.Retry; rt != nil {
+			proxy.RetryAttempts = rt.Attempts
+			proxy.RetryBackoff = time.Duration(rt.Backoff)
+			proxy.RetryMaxBackoff = time.Duration(rt.MaxBackoff)
+		}
+		if cb := p.CircuitBreaker; cb != nil {
+			proxy.BreakerFailures = cb.FailureThreshold
+			proxy.BreakerOpenDuration = time.Duration(cb.OpenDuration)
+			proxy.BreakerHalfOpenRequests = cb.HalfOpenRequests
+		}
+		if a := p.Auth; a != nil {
+			proxy.AuthForward, proxy.AuthAudience, proxy.AuthScopes = a.Forward, a.Audience, a.Scopes
+		}
+		if a := p.Access; a != nil {
+			proxy.AccessRoles, proxy.AccessClaims = a.Roles, a.Claims
+		}
+		r.Proxies = append(r.Proxies, proxy)
+		for i, l := range p.RateLimits {
+			r.RateLimits = append(r.RateLimits, database.ProxyRateLimit{
+				Id:       database.NewId(),
+				ProxyId:  proxy.Id,
+				Position: i,
+				Key:      l.Key,
+				Requests: l.Requests,
+				Period:   time.Duration(l.Period),
+				Burst:    l.Burst,
+			})
+		}
+		for i, route := range p.Routes {
+			row := database.ProxyRoute{
+				Id:            database.NewId(),
+				ProxyId:       proxy.Id,
+				Position:      i,
+				Prefix:        route.Prefix,
+				StripPrefix:   route.StripPrefix,
+				ReplacePrefix: route.ReplacePrefix,
+				Methods:       route.Methods,
+				SetHeaders:    route.SetHeaders,
+				RemoveHeaders: route.RemoveHeaders,
+				Host:          route.Host,
+			}
+			if a := route.Access; a != nil {
+				row.AccessRoles, row.AccessClaims = a.Roles, a.Claims
+			}
+			r.Routes = append(r.Routes, row)
+		}
+	}
+	return r
+}
+
+// flattenNavigations appends items and their children to rows, children
+// pointing to their group by ParentId.
+func flattenNavigations(items []core.Navigation, deploymentId string, parentId string, rows []database.Navigation) []database.Navigation {
+	for i, n := range items {
+		row := database.Navigation{
+			Id:           database.NewId(),
+			DeploymentId: deploymentId,
+			ParentId:     parentId,
+			Position:     i,
+			Key:          n.Id,
+			Endpoint:     n.Endpoint,
+			Title:        n.Title,
+			Titles:       n.Titles,
+			Icon:         n.Icon,
+			Order:        n.Order,
+			Hidden:       n.Hidden,
+			Roles:        n.Roles,
+			Claims:       n.Claims,
+		}
+		rows = append(rows, row)
+		rows = flattenNavigations(n.Children, deploymentId, row.Id, rows)
+	}
+	return rows
+}
+
+func fromRelease(r database.Release) core.Release {
+	rs := core.Release{
+		Deployment: core.DeploymentRequest{
+			Version:   r.Deployment.Version,
+			Kind:      core.Kind(r.Deployment.Kind),
+			Endpoint:  r.Deployment.Endpoint,
+			Container: r.Deployment.Container,
+			Locales:   r.Deployment.Locales,
+			Access:    http.AccessOf(r.Deployment.AccessRoles, r.Deployment.AccessClaims),
+			Cors:      http.CorsOf(r.Deployment),
+		},
+		Assets: make(map[string]string),
+	}
+	rs.Deployment.Navigations = http.NavigationsOf(r.Navigations)
+	rs.Deployment.Proxies = http.ProxiesOf(r)
+	for _, a := range r.Assets {
+		rs.Assets[a.Path] = a.Digest
+	}
+	return rs
+}
+
+// audit records a mutating command on endpoint, the diff compares the
+// release active before the command, if any, with the one active now.
+func (s *ServerMessageHandler) audit(command string, endpoint string, before *database.Release, err error) {
+	e := core.AuditEntry{
+		Actor:    s.actor(),
+		Address:  s.peer.Address,
+		Source:   core.AuditSourceCluster,
+		Command:  command,
+		Endpoint: endpoint,
+	}
+	if err != nil {
+		e.Error = err.Error()
+		http.RecordAudit(e)
+		return
+	}
+	var previous *core.Release
+	if before != nil && before.Deployment.Endpoint != "" {
+		r := fromRelease(*before)
+		previous = &r
+	}
+	next := core.Release{}
+	if r, ok := database.GetRelease(endpoint); ok {
+		next = fromRelease(r)
+	}
+	diff := core.DiffReleases(previous, next)
+	diff.Endpoint = endpoint
+	e.Diff = &diff
+	http.RecordAudit(e)
+}
+
+// actor names the operator of the connection, the subject of its client
+// certificate when it presented one.
+func (s *ServerMessageHandler) actor() string {
+	switch {
+	case s.peer.Name != "":
+		return s.peer.Name
+	case s.config.Token != "" && s.authenticated:
+		return "token"
+	}
+	return "anonymous"
+}
+
+// authenticate checks the token of the handshake, requests of a session
+// are only accepted once it succeeded.
+func (s *ServerMessageHandler) authenticate(cmd uint32, b []byte) error {
+	if s.config.Token == "" || s.authenticated {
+		return nil
+	}
+	switch cmd {
+	case core.CmdPingReq, core.CmdPingRep:
+		return nil
+	case core.CmdConnectReq:
+		if _, err := tcp.GetTlv(tcp.TypePayload, b); err != nil {
+			token, err := tcp.GetTlv(core.TypeToken, b)
+			if err == nil && subtle.ConstantTimeCompare(token.Value, []byte(s.config.Token)) == 1 {
+				s.authenticated = true
+				return nil
+			}
+			return fmt.Errorf("invalid token")
+		}
+	}
+	return fmt.Errorf("authentication required")
+}
+
+func toInfo(r database.Release, active bool, detailed bool) core.DeploymentInfo {
+	info := core.DeploymentInfo{
+		Id:          r.Deployment.Id,
+		Endpoint:    r.Deployment.Endpoint,
+		Kind:        core.Kind(r.Deployment.Kind),
+		Version:     r.Deployment.Version,
+		Container:   r.Deployment.Container,
+		Signer:      r.Deployment.Signer,
+		CreatedAt:   r.Deployment.CreatedAt,
+		Active:      active,
+		Navigations: len(r.Navigations),
+		Proxies:     len(r.Proxies),
+		Assets:      len(r.Assets),
+	}
+	if detailed {
+		d := fromRelease(r).Deployment
+		info.Deployment = &d
+	}
+	return info
+}
+
+func requestEndpoint(b []byte) (string, error) {
+	endpoint, err := tcp.GetTlv(core.TypeEndpoint, b)
+	if err != nil || endpoint.IsNullOrEmpty() {
+		return "", fmt.Errorf("endpoint is missing")
+	}
+	return core.NormalizeEndpoint(endpoint.GetString()), nil
+}
+
+// jsonReply answers cmd with v encoded as JSON in the payload.
+func jsonReply(cmd uint32, v any) ([]byte, error) {
+	rs, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return tcp.Join(
+		tcp.TlvUInt32(tcp.TypeCmd, cmd),
+		tcp.NewTlv(tcp.TypePayload, rs),
+	), nil
+}
+
+// verify checks the signature of the manifest, if any, and returns the signer.
+func (s *ServerMessageHandler) verify(m core.Manifest, b []byte) (string, error) {
+	sig, err := tcp.GetTlv(core.TypeSignature, b)
+	if err != nil || sig.IsNullOrEmpty() {
+		if s.config.RequireSignature {
+			return "", fmt.Errorf("deployment must be signed")
+		}
+		return "", nil
+	}
+	keyId, err := tcp.GetTlv(core.TypeKeyId, b)
+	if err != nil {
+		return "", fmt.Errorf("key id of signature is missing")
+	}
+	return s.config.TrustedKeys.Verify(m, keyId.GetString(), sig.Value)
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"goruf/platform/core"
+	"goruf/platform/database"
+	"goruf/platform/tcp"
+	"sync"
+	"testing"
+)
+
+// request sends req to h as a single page message and returns the reply.
+func request(t *testing.T, h tcp.MessageHandler, req []byte) []byte {
+	reply, err := h.Handle(tcp.Msg{Page: 1, TotalPage: 1, Payload: req})
+	if err != nil {
+		t.Logf("expected request to succeed, actual = %v", err)
+		t.FailNow()
+	}
+	return reply
+}
+
+func deployRequest(yaml string) []byte {
+	return core.CmdConnect{Cmd: core.CmdConnectReq, Payload: []byte(yaml), ContentType: core.FormatYaml.ContentType()}.Pack()
+}
+
+func TestRedeployKeepsAssets(t *testing.T) {
+	database.ConnectDatabase()
+	h := NewServerMessageHandler(&ServerConfig{})
+	request(t, h, deployRequest("Kind: container\nEndpoint: shop\nVersion: v1\n"))
+	reply := request(t, h, core.NewUpload(core.CmdUploadJsReq, "shop", "index.js", []byte("console.log(1)")))
+	payload, _ := tcp.GetTlv(tcp.TypePayload, reply)
+	var info core.AssetInfo
+	if err := json.Unmarshal(payload.Value, &info); err != nil || info.Integrity != core.Integrity([]byte("console.log(1)")) {
+		t.Logf("expected integrity of the upload, actual = %s %v", payload.Value, err)
+		t.FailNow()
+	}
+	request(t, h, deployRequest("Kind: container\nEndpoint: shop\nVersion: v2\n"))
+	r, _ := database.GetRelease("shop")
+	if r.Deployment.Version != "v2" || len(r.Assets) != 1 || r.Assets[0].Integrity != info.Integrity || r.Assets[0].DeploymentId != r.Deployment.Id {
+		t.Logf("expected the upload to be kept by v2, actual = %+v", r)
+		t.FailNow()
+	}
+}
+
+func TestDryRunKeepsAssets(t *testing.T) {
+	database.ConnectDatabase()
+	h := NewServerMessageHandler(&ServerConfig{})
+	request(t, h, deployRequest("Kind: container\nEndpoint: shop\nVersion: v1\n"))
+	request(t, h, core.NewUpload(core.CmdUploadJsReq, "shop", "index.js", []byte("console.log(1)")))
+	dryRun := func(assets map[string]string) core.ReleaseDiff {
+		req := core.CmdConnect{Cmd: core.CmdConnectReq, Payload: []byte("Kind: container\nEndpoint: shop\nVersion: v2\n"), Assets: assets, DryRun: true}
+		payload, _ := tcp.GetTlv(tcp.TypePayload, request(t, h, req.Pack()))
+		var diff core.ReleaseDiff
+		if err := json.Unmarshal(payload.Value, &diff); err != nil {
+			t.Logf("expected diff, actual = %s %v", payload.Value, err)
+			t.FailNow()
+		}
+		return diff
+	}
+	if diff := dryRun(nil); len(diff.Assets) != 0 || len(diff.Fields) != 1 {
+		t.Logf("expected only the version to change, actual = %+v", diff)
+		t.FailNow()
+	}
+	diff := dryRun(map[string]string{"main.js": core.AssetDigest([]byte("console.log(2)"))})
+	if len(diff.Assets) != 1 || diff.Assets[0].Key != "index.js" || diff.Assets[0].Op != core.ChangeRemoved {
+		t.Logf("expected the asset missing from the manifest to be removed, actual = %+v", diff)
+		t.FailNow()
+	}
+}
+
+func TestSignedAssets(t *testing.T) {
+	database.ConnectDatabase()
+	pub, key, _ := ed25519.GenerateKey(nil)
+	h := NewServerMessageHandler(&ServerConfig{
+		TrustedKeys:      core.TrustedKeys{core.KeyId(pub): {Name: "ci", Key: pub}},
+		RequireSignature: true,
+	})
+	payload := []byte("Kind: container\nEndpoint: shop\n")
+	assets := map[string]string{"index.js": core.AssetDigest([]byte("console.log(1)"))}
+	deploy := core.CmdConnect{Cmd: core.CmdConnectReq, Payload: payload, Assets: assets, KeyId: core.KeyId(pub)}
+	deploy.Signature = core.Sign(key, core.Manifest{Deployment: payload})
+	if _, err := h.Handle(tcp.Msg{TotalPage: 1, Payload: deploy.Pack()}); err == nil {
+		t.Logf("expected assets outside of the signature to be rejected")
+		t.FailNow()
+	}
+	deploy.Signature = core.Sign(key, core.Manifest{Deployment: payload, Assets: assets})
+	request(t, h, deploy.Pack())
+	rejected := map[string][]byte{
+		"index.js": []byte("console.log(2)"),
+		"other.js": []byte("console.log(1)"),
+	}
+	for path, content := range rejected {
+		if _, err := h.Handle(tcp.Msg{TotalPage: 1, Payload: core.NewUpload(core.CmdUploadJsReq, "shop", path, content)}); err == nil {
+			t.Logf("expected upload of %s outside of the manifest to be rejected", path)
+			t.FailNow()
+		}
+	}
+	request(t, h, core.NewUpload(core.CmdUploadJsReq, "shop", "index.js", []byte("console.log(1)")))
+	if r, _ := database.GetRelease("shop"); len(r.Assets) != 1 || r.Assets[0].Digest != assets["index.js"] {
+		t.Logf("expected the signed asset to be uploaded, actual = %+v", r.Assets)
+		t.FailNow()
+	}
+}
+
+func TestMessageSizeLimit(t *testing.T) {
+	database.ConnectDatabase()
+	h := NewServerMessageHandler(&ServerConfig{MaxMessageSize: 16})
+	if _, err := h.Handle(tcp.Msg{Page: 1, TotalPage: 3, Payload: make([]byte, 9)}); err != nil {
+		t.Logf("expected first page to be staged, actual = %v", err)
+		t.FailNow()
+	}
+	if _, err := h.Handle(tcp.Msg{Page: 2, TotalPage: 3, Payload: make([]byte, 9)}); err == nil {
+		t.Logf("expected message over the limit to be rejected")
+		t.FailNow()
+	}
+	req := tcp.Join(tcp.TlvUInt32(tcp.TypeCmd, core.CmdStatusReq))
+	if len(req) > 16 {
+		t.Logf("expected status request to fit the limit, actual = %d", len(req))
+		t.FailNow()
+	}
+	h.Handle(tcp.Msg{Page: 1, TotalPage: 2, Payload: req[:4]})
+	if reply, err := h.Handle(tcp.Msg{Page: 2, TotalPage: 2, Payload: req[4:]}); err != nil || reply == nil {
+		t.Logf("expected pages after the rejected message to be reassembled, actual = %v", err)
+		t.FailNow()
+	}
+}
+
+// decodeReply unmarshals the payload of reply into v.
+func decodeReply(t *testing.T, reply []byte, v any) {
+	payload, err := tcp.GetTlv(tcp.TypePayload, reply)
+	if err == nil {
+		err = json.Unmarshal(payload.Value, v)
+	}
+	if err != nil {
+		t.Logf("failed to decode reply: %v", err)
+		t.FailNow()
+	}
+}
+
+func TestAdminCommands(t *testing.T) {
+	database.ConnectDatabase()
+	h := NewServerMessageHandler(&ServerConfig{Version: "v0.1.0"})
+	request(t, h, deployRequest("Kind: container\nEndpoint: shop\nVersion: v1\n"))
+	request(t, h, deployRequest("Kind: container\nEndpoint: shop\nVersion: v2\n"))
+	request(t, h, deployRequest("Kind: microapp\nEndpoint: cart\nContainer: shop\nVersion: v1\n"))
+
+	var list []core.DeploymentInfo
+	decodeReply(t, request(t, h, core.NewRequest(core.CmdListReq, "")), &list)
+	if len(list) != 2 {
+		t.Logf("expected 2 deployments, actual = %+v", list)
+		t.FailNow()
+	}
+
+	var info core.DeploymentInfo
+	decodeReply(t, request(t, h, core.NewRequest(core.CmdGetReq, "/shop")), &info)
+	if info.Version != "v2" || !info.Active || info.Deployment == nil || info.Deployment.Endpoint != "shop" {
+		t.Logf("expected the active release of shop, actual = %+v", info)
+		t.FailNow()
+	}
+	if _, err := h.Handle(tcp.Msg{TotalPage: 1, Payload: core.NewRequest(core.CmdGetReq, "orders")}); err == nil {
+		t.Logf("expected get of an endpoint that is not deployed to fail")
+		t.FailNow()
+	}
+
+	var releases []core.DeploymentInfo
+	decodeReply(t, request(t, h, core.NewRequest(core.CmdReleasesReq, "shop")), &releases)
+	if len(releases) != 2 || releases[0].Version != "v2" || !releases[0].Active || releases[1].Active {
+		t.Logf("expected v2 then v1, actual = %+v", releases)
+		t.FailNow()
+	}
+
+	request(t, h, core.NewRollback("shop"))
+	if r, _ := database.GetRelease("shop"); r.Deployment.Version != "v1" {
+		t.Logf("expected rollback to restore v1, actual = %s", r.Deployment.Version)
+		t.FailNow()
+	}
+
+	if _, err := h.Handle(tcp.Msg{TotalPage: 1, Payload: core.NewRequest(core.CmdDeleteReq, "shop")}); err == nil {
+		t.Logf("expected delete of a container still mounting cart to fail")
+		t.FailNow()
+	}
+	request(t, h, core.NewRequest(core.CmdDeleteReq, "cart"))
+	if _, ok := database.GetRelease("cart"); ok {
+		t.Logf("expected cart to be deleted")
+		t.FailNow()
+	}
+
+	var status core.ServerStatus
+	decodeReply(t, request(t, h, core.NewRequest(core.CmdStatusReq, "")), &status)
+	if status.Version != "v0.1.0" || status.Deployments != 1 || status.Releases != 1 {
+		t.Logf("expected shop with a single release, actual = %+v", status)
+		t.FailNow()
+	}
+}
+
+func TestProxyConflicts(t *testing.T) {
+	database.ConnectDatabase()
+	h := NewServerMessageHandler(&ServerConfig{})
+	shop := "Kind: container\nEndpoint: shop\nProxies:\n  - BackendCode: orders\n    BackendAddress: orders:8080\n"
+	request(t, h, deployRequest(shop))
+	request(t, h, deployRequest(shop))
+	rejected := []string{
+		"Kind: container\nEndpoint: portal\nProxies:\n  - BackendCode: orders\n    BackendAddress: other:8080\n",
+		"Kind: container\nEndpoint: portal\nProxies:\n  - BackendCode: v2\n    BackendAddress: other:8080\n    Routes:\n      - Prefix: /api/orders/v2\n",
+		"Kind: container\nEndpoint: portal\nProxies:\n  - BackendCode: all\n    BackendAddress: other:8080\n    Routes:\n      - Prefix: /api\n",
+	}
+	for _, yaml := range rejected {
+		if _, err := h.Handle(tcp.Msg{TotalPage: 1, Payload: deployRequest(yaml)}); err == nil {
+			t.Logf("expected deployment taking the backend or routes of shop to be rejected:\n%s", yaml)
+			t.FailNow()
+		}
+	}
+	request(t, h, deployRequest("Kind: container\nEndpoint: portal\nProxies:\n  - BackendCode: billing\n    BackendAddress: billing:8080\n"))
+}
+
+func TestConcurrentUploadsAndDeploys(t *testing.T) {
+	database.ConnectDatabase()
+	config := &ServerConfig{}
+	request(t, NewServerMessageHandler(config), deployRequest("Kind: container\nEndpoint: shop\nVersion: v1\n"))
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			h := NewServerMessageHandler(config)
+			path := fmt.Sprintf("chunk-%d.js", i)
+			h.Handle(tcp.Msg{TotalPage: 1, Payload: core.NewUpload(core.CmdUploadJsReq, "shop", path, []byte(path))})
+		}()
+		go func() {
+			defer wg.Done()
+			h := NewServerMessageHandler(config)
+			h.Handle(tcp.Msg{TotalPage: 1, Payload: deployRequest(fmt.Sprintf("Kind: container\nEndpoint: shop\nVersion: v%d\n", i+2))})
+		}()
+	}
+	wg.Wait()
+	if r, _ := database.GetRelease("shop"); len(r.Assets) != 20 {
+		t.Logf("expected every upload to survive the redeploys, actual = %d", len(r.Assets))
+		t.FailNow()
+	}
+}
@@ -1,11 +1,65 @@
 package storage
 
-type Storage interface{}
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+)
 
-func ConnectStorage() error {
+var ErrNotFound = errors.New("content not found")
+
+// Storage keeps the content of uploaded assets addressed by its hex encoded
+// sha256 digest, see core.AssetDigest.
+type Storage interface {
+	Put(digest string, content []byte) error
+	Get(digest string) ([]byte, error)
+}
+
+var (
+	mu    sync.RWMutex
+	store Storage = NewDbStorage()
+)
+
+// ConnectStorage keeps the assets in dir, or in memory when dir is empty.
+func ConnectStorage(dir string) error {
+	var s Storage = NewDbStorage()
+	if dir != "" {
+		fs, err := NewFileStorage(dir)
+		if err != nil {
+			return err
+		}
+		s = fs
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	store = s
 	return nil
 }
 
-func Store() error {
+// Store keeps content under digest, storing the same content twice is a no-op.
+func Store(digest string, content []byte) error {
+	if err := checkDigest(digest); err != nil {
+		return err
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	return store.Put(digest, content)
+}
+
+// Load returns the content stored under digest or ErrNotFound.
+func Load(digest string) ([]byte, error) {
+	if err := checkDigest(digest); err != nil {
+		return nil, err
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	return store.Get(digest)
+}
+
+func checkDigest(digest string) error {
+	if b, err := hex.DecodeString(digest); err != nil || len(b) != 32 {
+		return fmt.Errorf("invalid digest %q", digest)
+	}
 	return nil
 }
@@ -1,3 +1,32 @@
 package storage
 
-type DbStorage struct{}
+import "sync"
+
+// DbStorage keeps the assets in memory, next to the releases of the database.
+type DbStorage struct {
+	mu       sync.RWMutex
+	contents map[string][]byte
+}
+
+func NewDbStorage() *DbStorage {
+	return &DbStorage{contents: make(map[string][]byte)}
+}
+
+func (s *DbStorage) Put(digest string, content []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.contents[digest]; !ok {
+		s.contents[digest] = append([]byte(nil), content...)
+	}
+	return nil
+}
+
+func (s *DbStorage) Get(digest string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	b, ok := s.contents[digest]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return b, nil
+}
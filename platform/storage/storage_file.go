@@ -1,3 +1,57 @@
 package storage
 
-type FileStorage struct{}
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// FileStorage keeps every asset in a file of Dir named by its digest, below
+// a directory named by the first two characters of the digest.
+type FileStorage struct {
+	Dir string
+}
+
+func NewFileStorage(dir string) (*FileStorage, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileStorage{Dir: dir}, nil
+}
+
+func (s *FileStorage) path(digest string) string {
+	return filepath.Join(s.Dir, digest[:2], digest)
+}
+
+func (s *FileStorage) Put(digest string, content []byte) error {
+	p := s.path(digest)
+	if _, err := os.Stat(p); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	// written aside and renamed, so that a reader never sees a partial file
+	tmp, err := os.CreateTemp(filepath.Dir(p), digest+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+func (s *FileStorage) Get(digest string) ([]byte, error) {
+	b, err := os.ReadFile(s.path(digest))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return b, err
+}
@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestFileStorage(t *testing.T) {
+	if err := ConnectStorage(t.TempDir()); err != nil {
+		t.Logf("expected file storage, actual = %v", err)
+		t.FailNow()
+	}
+	defer ConnectStorage("")
+	content := []byte("console.log('portal')")
+	sum := sha256.Sum256(content)
+	digest := hex.EncodeToString(sum[:])
+	for i := 0; i < 2; i++ {
+		if err := Store(digest, content); err != nil {
+			t.Logf("expected content to be stored, actual = %v", err)
+			t.FailNow()
+		}
+	}
+	if b, err := Load(digest); err != nil || string(b) != string(content) {
+		t.Logf("expected stored content, actual = %s %v", b, err)
+		t.FailNow()
+	}
+	missing := sha256.Sum256(nil)
+	if _, err := Load(hex.EncodeToString(missing[:])); !errors.Is(err, ErrNotFound) {
+		t.Logf("expected not found, actual = %v", err)
+		t.FailNow()
+	}
+	if _, err := Load("../../etc/passwd"); err == nil {
+		t.Logf("expected malformed digest to be rejected")
+		t.FailNow()
+	}
+}
@@ -1,6 +1,14 @@
 package tcp
 
-import "net"
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
 
 type TransferData func(conn net.Conn) error
 
@@ -16,3 +24,267 @@ func ConnectAndTransferData(addr string, f TransferData) error {
 	defer conn.Close()
 	return f(conn)
 }
+
+// ReplyError is returned by Client.Do when the server answers with an error reply.
+type ReplyError struct {
+	Message string
+}
+
+func (e ReplyError) Error() string {
+	return fmt.Sprintf("server replied with error: %s", e.Message)
+}
+
+type ClientOptions struct {
+	Address        string
+	MaxPayloadSize uint32
+	DialTimeout    time.Duration
+	RequestTimeout time.Duration
+	KeepAlive      time.Duration
+	// MaxRetries is the number of extra attempts made for idempotent requests.
+	MaxRetries      int
+	RetryBackoff    time.Duration
+	MaxRetryBackoff time.Duration
+	// PoolSize is the number of idle connections kept open, zero disables pooling.
+	PoolSize int
+	// Idempotent reports whether a request may be safely sent more than once.
+	Idempotent func(payload []byte) bool
+	// Heartbeat is used to answer pings sent by the server while waiting for a reply.
+	Heartbeat Heartbeat
+	// Handshake is sent on every new connection. A TypeCodec TLV in its reply
+	// selects the codec used to compress the frames of that connection.
+	Handshake           []byte
+	MaxDecompressedSize int64
+	// TLSConfig enables TLS on new connections when set.
+	TLSConfig *tls.Config
+}
+
+func DefaultClientOptions(addr string) ClientOptions {
+	return ClientOptions{
+		Address:             addr,
+		MaxPayloadSize:      1024 * 1024,
+		DialTimeout:         5 * time.Second,
+		RequestTimeout:      60 * time.Second,
+		KeepAlive:           30 * time.Second,
+		MaxRetries:          3,
+		RetryBackoff:        200 * time.Millisecond,
+		MaxRetryBackoff:     5 * time.Second,
+		MaxDecompressedSize: DefaultMaxDecompressedSize,
+	}
+}
+
+// Client sends requests to the control plane and waits for their replies.
+// It is safe for concurrent use when pooling is enabled; otherwise each
+// call to Do uses its own connection.
+type Client struct {
+	opts   ClientOptions
+	dialer net.Dialer
+	pool   chan *clientConn
+}
+
+type clientConn struct {
+	net.Conn
+	codec Codec
+	// r lives as long as the connection, it may hold bytes read past a reply
+	// such as a ping sent right behind it.
+	r *bufio.Reader
+}
+
+func NewClient(opts ClientOptions) *Client {
+	c := &Client{
+		opts: opts,
+		dialer: net.Dialer{
+			Timeout:   opts.DialTimeout,
+			KeepAlive: opts.KeepAlive,
+		},
+	}
+	if opts.PoolSize > 0 {
+		c.pool = make(chan *clientConn, opts.PoolSize)
+	}
+	return c
+}
+
+// Do packs the payload, sends it to the server and returns the reassembled reply.
+func (c *Client) Do(ctx context.Context, payload []byte) ([]byte, error) {
+	retries := 0
+	if c.opts.Idempotent != nil && c.opts.Idempotent(payload) {
+		retries = c.opts.MaxRetries
+	}
+	var lastErr error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			if err := c.wait(ctx, attempt); err != nil {
+				return nil, err
+			}
+		}
+		conn, err := c.get(ctx)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		reply, err := c.roundTrip(ctx, conn, payload)
+		c.put(conn, err)
+		if err == nil {
+			return reply, nil
+		}
+		var replyErr ReplyError
+		if errors.As(err, &replyErr) {
+			return nil, err
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// Close closes all idle connections kept in the pool.
+func (c *Client) Close() error {
+	if c.pool == nil {
+		return nil
+	}
+	for {
+		select {
+		case conn := <-c.pool:
+			conn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+func (c *Client) wait(ctx context.Context, attempt int) error {
+	backoff := c.opts.RetryBackoff << (attempt - 1)
+	if c.opts.MaxRetryBackoff > 0 && (backoff > c.opts.MaxRetryBackoff || backoff <= 0) {
+		backoff = c.opts.MaxRetryBackoff
+	}
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (c *Client) get(ctx context.Context) (*clientConn, error) {
+	if c.pool != nil {
+		select {
+		case conn := <-c.pool:
+			return conn, nil
+		default:
+		}
+	}
+	nc, err := c.dialer.DialContext(ctx, "tcp", c.opts.Address)
+	if err != nil {
+		return nil, err
+	}
+	if c.opts.TLSConfig != nil {
+		config := c.opts.TLSConfig.Clone()
+		if config.ServerName == "" {
+			config.ServerName, _, _ = net.SplitHostPort(c.opts.Address)
+		}
+		tc := tls.Client(nc, config)
+		if err := tc.HandshakeContext(ctx); err != nil {
+			nc.Close()
+			return nil, err
+		}
+		nc = tc
+	}
+	conn := &clientConn{Conn: nc, r: bufio.NewReader(nc)}
+	if c.opts.Handshake == nil {
+		return conn, nil
+	}
+	reply, err := c.roundTrip(ctx, conn, c.opts.Handshake)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if v, err := GetTlv(TypeCodec, reply); err == nil {
+		conn.codec, err = GetCodec(v.GetString())
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (c *Client) put(conn *clientConn, err error) {
+	if err != nil || c.pool == nil {
+		conn.Close()
+		return
+	}
+	select {
+	case c.pool <- conn:
+	default:
+		conn.Close()
+	}
+}
+
+func (c *Client) roundTrip(ctx context.Context, conn *clientConn, payload []byte) ([]byte, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok && c.opts.RequestTimeout > 0 {
+		deadline = time.Now().Add(c.opts.RequestTimeout)
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	msgs, err := PackWith(payload, c.opts.MaxPayloadSize, conn.codec)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(conn)
+	for _, msg := range msgs {
+		if _, err := w.Write(msg); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	reply, err := ReadReply(conn.r, conn.codec, c.opts.MaxDecompressedSize)
+	for err == nil && c.opts.Heartbeat.IsPing != nil && c.opts.Heartbeat.IsPing(reply) {
+		if err = c.pong(w, conn.codec); err != nil {
+			return nil, err
+		}
+		reply, err = ReadReply(conn.r, conn.codec, c.opts.MaxDecompressedSize)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if v, err := GetTlv(TypeError, reply); err == nil {
+		return nil, ReplyError{Message: v.GetString()}
+	}
+	return reply, nil
+}
+
+func (c *Client) pong(w *bufio.Writer, codec Codec) error {
+	msgs, err := PackWith(c.opts.Heartbeat.Pong, c.opts.MaxPayloadSize, codec)
+	if err != nil {
+		return err
+	}
+	for _, msg := range msgs {
+		if _, err := w.Write(msg); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// ReadReply reads all pages of a reply, decompresses them with codec and joins their payloads.
+func ReadReply(r *bufio.Reader, codec Codec, limit int64) ([]byte, error) {
+	payload := make([]byte, 0)
+	for {
+		msg, err := Read(r)
+		if err != nil {
+			return nil, err
+		}
+		msg, err = Decompress(msg, codec, limit)
+		if err != nil {
+			return nil, err
+		}
+		payload = append(payload, msg.Payload...)
+		if msg.Page >= msg.TotalPage {
+			return payload, nil
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package tcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listen serves connections with the given handler on a random local port.
+func listen(t *testing.T, handler func() MessageHandler) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Logf("failed to listen: %v", err)
+		t.FailNow()
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go NewClientConn(conn, handler()).handleRequest()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestClientDo(t *testing.T) {
+	addr := listen(t, func() MessageHandler { return &echoHandler{} })
+	opts := DefaultClientOptions(addr)
+	opts.MaxPayloadSize = 8
+	opts.PoolSize = 1
+	client := NewClient(opts)
+	defer client.Close()
+
+	for i := 0; i < 3; i++ {
+		reply, err := client.Do(context.Background(), Join(TlvString(TypePayload, "This is a message")))
+		if err != nil {
+			t.Logf("failed to Do(ctx, payload): %v", err)
+			t.FailNow()
+		}
+		if v, err := GetTlv(TypePayload, reply); err != nil || v.GetString() != "This is a message" {
+			t.Logf("expected reply is This is a message, actual = %v", v.GetString())
+			t.FailNow()
+		}
+	}
+}
+
+func TestClientDoErrorReply(t *testing.T) {
+	addr := listen(t, func() MessageHandler { return panicHandler{} })
+	client := NewClient(DefaultClientOptions(addr))
+	_, err := client.Do(context.Background(), Join(TlvString(TypePayload, "hello")))
+	var replyErr ReplyError
+	if !errors.As(err, &replyErr) {
+		t.Logf("expected ReplyError, actual = %v", err)
+		t.FailNow()
+	}
+}
+
+func TestClientDoRetries(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Logf("failed to listen: %v", err)
+		t.FailNow()
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	opts := DefaultClientOptions(addr)
+	opts.RetryBackoff = 10 * time.Millisecond
+	opts.MaxRetries = 2
+	attempts := 0
+	opts.Idempotent = func(payload []byte) bool {
+		attempts++
+		return true
+	}
+	client := NewClient(opts)
+	start := time.Now()
+	_, err = client.Do(context.Background(), Join())
+	if err == nil || !strings.Contains(err.Error(), "refused") {
+		t.Logf("expected connection refused, actual = %v", err)
+		t.FailNow()
+	}
+	if attempts != 1 || time.Since(start) < 30*time.Millisecond {
+		t.Logf("request should be retried with backoff, elapsed = %v", time.Since(start))
+		t.FailNow()
+	}
+}
+
+func TestClientKeepsBytesReadAhead(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Logf("failed to listen: %v", err)
+		t.FailNow()
+	}
+	defer l.Close()
+	ping, pong := Join(TlvString(TypePayload, "ping")), Join(TlvString(TypePayload, "pong"))
+	frame := func(payload []byte) []byte {
+		msgs, _ := Pack(payload, 1024)
+		return msgs[0]
+	}
+	pongs := make(chan bool, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		if _, err := Read(r); err != nil {
+			return
+		}
+		// the ping goes out in the same write as the reply
+		conn.Write(append(frame(Join(TlvString(TypePayload, "first"))), frame(ping)...))
+		if _, err := Read(r); err != nil {
+			return
+		}
+		msg, err := Read(r)
+		pongs <- err == nil && bytes.Equal(msg.Payload, pong)
+		conn.Write(frame(Join(TlvString(TypePayload, "second"))))
+	}()
+	opts := DefaultClientOptions(l.Addr().String())
+	opts.PoolSize = 1
+	opts.RequestTimeout = time.Second
+	opts.Heartbeat = Heartbeat{Pong: pong, IsPing: func(payload []byte) bool { return bytes.Equal(payload, ping) }}
+	client := NewClient(opts)
+	defer client.Close()
+	for _, expected := range []string{"first", "second"} {
+		reply, err := client.Do(context.Background(), Join(TlvString(TypePayload, "request")))
+		if err != nil {
+			t.Logf("failed to Do(ctx, payload): %v", err)
+			t.FailNow()
+		}
+		if v, _ := GetTlv(TypePayload, reply); v.GetString() != expected {
+			t.Logf("expected reply %s, actual = %v", expected, v.GetString())
+			t.FailNow()
+		}
+	}
+	if !<-pongs {
+		t.Logf("expected ping read ahead with the first reply to be answered")
+		t.FailNow()
+	}
+}
@@ -0,0 +1,122 @@
+package tcp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	CodecNone = "none"
+	CodecGzip = "gzip"
+	CodecZstd = "zstd"
+)
+
+// Codec compresses the payload of a single frame.
+type Codec interface {
+	Name() string
+	Compress(b []byte) ([]byte, error)
+	// Decompress fails when the decompressed data would exceed limit bytes.
+	Decompress(b []byte, limit int64) ([]byte, error)
+}
+
+var codecs = map[string]Codec{
+	CodecGzip: gzipCodec{},
+	CodecZstd: newZstdCodec(),
+}
+
+// GetCodec returns the codec registered under name, nil stands for no compression.
+func GetCodec(name string) (Codec, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" || name == CodecNone {
+		return nil, nil
+	}
+	c, ok := codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported codec %s", name)
+	}
+	return c, nil
+}
+
+// NegotiateCodec picks the first codec offered by the client that is supported.
+func NegotiateCodec(offered string) string {
+	for _, name := range strings.Split(offered, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, ok := codecs[name]; ok {
+			return name
+		}
+	}
+	return CodecNone
+}
+
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	b, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > limit {
+		return nil, fmt.Errorf("decompressed payload exceeds %d bytes", limit)
+	}
+	return b, nil
+}
+
+type gzipCodec struct{}
+
+func (gzipCodec) Name() string {
+	return CodecGzip
+}
+
+func (gzipCodec) Compress(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCodec) Decompress(b []byte, limit int64) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readLimited(r, limit)
+}
+
+type zstdCodec struct {
+	encoder *zstd.Encoder
+	err     error
+}
+
+func newZstdCodec() zstdCodec {
+	encoder, err := zstd.NewWriter(nil)
+	return zstdCodec{encoder: encoder, err: err}
+}
+
+func (zstdCodec) Name() string {
+	return CodecZstd
+}
+
+func (c zstdCodec) Compress(b []byte) ([]byte, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c.encoder.EncodeAll(b, nil), nil
+}
+
+func (zstdCodec) Decompress(b []byte, limit int64) ([]byte, error) {
+	r, err := zstd.NewReader(bytes.NewReader(b), zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readLimited(r, limit)
+}
@@ -0,0 +1,65 @@
+package tcp
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPackWithCodec(t *testing.T) {
+	for _, name := range []string{CodecGzip, CodecZstd} {
+		codec, err := GetCodec(name)
+		if err != nil {
+			t.Logf("failed to GetCodec(%s): %v", name, err)
+			t.FailNow()
+		}
+		payload := []byte(strings.Repeat("micro-frontend ", 200))
+		data, err := PackWith(payload, 1024, codec)
+		if err != nil {
+			t.Logf("failed to PackWith(payload, size, %s): %v", name, err)
+			t.FailNow()
+		}
+		r := bufio.NewReader(bytes.NewBuffer(bytes.Join(data, nil)))
+		reply, err := ReadReply(r, codec, 1024*1024)
+		if err != nil {
+			t.Logf("failed to ReadReply(r, %s): %v", name, err)
+			t.FailNow()
+		}
+		if !bytes.Equal(reply, payload) {
+			t.Logf("payload should be restored by %s", name)
+			t.FailNow()
+		}
+	}
+}
+
+func TestDecompressLimit(t *testing.T) {
+	for _, name := range []string{CodecGzip, CodecZstd} {
+		codec, _ := GetCodec(name)
+		data, err := PackWith(make([]byte, 64*1024), 64*1024, codec)
+		if err != nil {
+			t.Logf("failed to PackWith(payload, size, %s): %v", name, err)
+			t.FailNow()
+		}
+		msg, err := Read(bufio.NewReader(bytes.NewBuffer(data[0])))
+		if err != nil || !msg.IsCompressed() {
+			t.Logf("frame should be compressed by %s: %v", name, err)
+			t.FailNow()
+		}
+		if _, err := Decompress(msg, codec, 1024); err == nil {
+			t.Logf("decompression beyond limit should fail with %s", name)
+			t.FailNow()
+		}
+	}
+}
+
+func TestNegotiateCodec(t *testing.T) {
+	if v := NegotiateCodec("brotli, zstd,gzip"); v != CodecZstd {
+		t.Logf("expected codec is zstd, actual = %v", v)
+		t.FailNow()
+	}
+	if v := NegotiateCodec("brotli"); v != CodecNone {
+		t.Logf("expected codec is none, actual = %v", v)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,28 @@
+package tcp
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// Heartbeat describes how idle connections are probed. The tcp package does
+// not know about commands, so callers provide the ping and pong payloads.
+type Heartbeat struct {
+	// Interval is how long a connection may stay silent before it is probed, zero disables heartbeats.
+	Interval time.Duration
+	// MaxMissed is the number of unanswered pings tolerated before the connection is closed.
+	MaxMissed int
+	Ping      []byte
+	Pong      []byte
+	IsPing    func(payload []byte) bool
+}
+
+func (h Heartbeat) Enabled() bool {
+	return h.Interval > 0 && len(h.Ping) > 0
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -8,6 +8,7 @@ import (
 
 type Msg struct {
 	Version   uint32
+	Flags     uint32
 	Size      uint32
 	TotalPage uint32
 	Page      uint32
@@ -19,6 +20,7 @@ func (m Msg) Pack() []byte {
 		Stx, 0x4D, 0x46, 0x45,
 	}
 	bs = binary.BigEndian.AppendUint32(bs, m.Version)
+	bs = binary.BigEndian.AppendUint32(bs, m.Flags)
 	bs = binary.BigEndian.AppendUint32(bs, m.Size)
 	bs = binary.BigEndian.AppendUint32(bs, m.TotalPage)
 	bs = binary.BigEndian.AppendUint32(bs, m.Page)
@@ -28,11 +30,20 @@ func (m Msg) Pack() []byte {
 }
 
 const (
-	Version = uint32(1)
+	Version = uint32(2)
 	Stx     = 0x02
 	Etx     = 0x03
 )
 
+const (
+	// FlagCompressed marks a frame whose payload is compressed with the codec negotiated for the connection.
+	FlagCompressed uint32 = 1 << iota
+)
+
+func (m Msg) IsCompressed() bool {
+	return m.Flags&FlagCompressed != 0
+}
+
 func ValidateHeader(bytes []byte) bool {
 	return bytes[0] == 0x4D && bytes[1] == 0x46 && bytes[2] == 0x45
 }
@@ -72,6 +83,14 @@ func Read(r *bufio.Reader) (Msg, error) {
 		return msg, err
 	}
 	msg.Version = binary.BigEndian.Uint32(arr)
+	// read flags, frames of version 1 do not carry them
+	if msg.Version >= 2 {
+		arr, err = ReadFull(r, 4)
+		if err != nil {
+			return msg, err
+		}
+		msg.Flags = binary.BigEndian.Uint32(arr)
+	}
 	//read size
 	arr, err = ReadFull(r, 4)
 	if err != nil {
@@ -107,6 +126,12 @@ func Read(r *bufio.Reader) (Msg, error) {
 }
 
 func Pack(payload []byte, maxSize uint32) ([][]byte, error) {
+	return PackWith(payload, maxSize, nil)
+}
+
+// PackWith splits payload into frames like Pack and compresses each page
+// with codec. Pages that do not shrink are sent uncompressed.
+func PackWith(payload []byte, maxSize uint32, codec Codec) ([][]byte, error) {
 	totalPage := uint32(1)
 	if uint32(len(payload)) > maxSize {
 		totalPage = uint32(len(payload)) / maxSize
@@ -127,8 +152,37 @@ func Pack(payload []byte, maxSize uint32) ([][]byte, error) {
 			Page:      page + 1,
 			Payload:   payload[:size],
 		}
+		if codec != nil && size > 0 {
+			compressed, err := codec.Compress(msg.Payload)
+			if err != nil {
+				return nil, err
+			}
+			if len(compressed) < len(msg.Payload) {
+				msg.Flags |= FlagCompressed
+				msg.Size = uint32(len(compressed))
+				msg.Payload = compressed
+			}
+		}
 		payload = payload[size:]
 		rs = append(rs, msg.Pack())
 	}
 	return rs, nil
 }
+
+// Decompress returns msg with its payload decompressed by codec.
+func Decompress(msg Msg, codec Codec, limit int64) (Msg, error) {
+	if !msg.IsCompressed() {
+		return msg, nil
+	}
+	if codec == nil {
+		return msg, fmt.Errorf("compressed frame received but no codec was negotiated")
+	}
+	payload, err := codec.Decompress(msg.Payload, limit)
+	if err != nil {
+		return msg, err
+	}
+	msg.Flags &^= FlagCompressed
+	msg.Size = uint32(len(payload))
+	msg.Payload = payload
+	return msg, nil
+}
@@ -28,7 +28,7 @@ func TestRead(t *testing.T) {
 		t.FailNow()
 	}
 	if msg.Version != Version {
-		t.Logf("Versions should be 1, actual = %v", msg.Version)
+		t.Logf("Versions should be 2, actual = %v", msg.Version)
 		t.FailNow()
 	}
 }
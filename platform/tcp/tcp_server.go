@@ -2,11 +2,15 @@ package tcp
 
 import (
 	"bufio"
+	"crypto/tls"
 	"errors"
+	"expvar"
 	"fmt"
 	"io"
 	"net"
+	"runtime/debug"
 	"sync"
+	"time"
 
 	"github.com/rs/zerolog/log"
 )
@@ -17,33 +21,117 @@ type MessageHandler interface {
 
 type HandlerCreator func() MessageHandler
 
-func OpenListener(port int, creator HandlerCreator) error {
+// SessionCloser is implemented by handlers holding per-connection state,
+// such as staged uploads, that must be released when the connection ends.
+type SessionCloser interface {
+	Close()
+}
+
+// Peer is the client of a connection.
+type Peer struct {
+	Address string
+	// Name is the common name of the verified client certificate, empty
+	// when the client did not present one.
+	Name string
+}
+
+// PeerAware is implemented by handlers that need to know their client, such
+// as to audit its requests. SetPeer is called before the first message.
+type PeerAware interface {
+	SetPeer(p Peer)
+}
+
+// MaxReplySize is the max payload size of a single page sent back to clients.
+const MaxReplySize = uint32(1024 * 1024)
+
+// DefaultMaxDecompressedSize bounds the decompressed size of a single frame.
+const DefaultMaxDecompressedSize = int64(64 * 1024 * 1024)
+
+// DefaultReadTimeout bounds the time a client takes to send a whole frame
+// once its first byte arrived.
+const DefaultReadTimeout = 30 * time.Second
+
+type ServerOptions struct {
+	Heartbeat           Heartbeat
+	MaxDecompressedSize int64
+	// ReadTimeout closes connections stalling in the middle of a frame.
+	ReadTimeout time.Duration
+	// TLSConfig enables TLS on the cluster port when set.
+	TLSConfig *tls.Config
+}
+
+// panicCounter counts panics recovered while serving cluster connections.
+var panicCounter = expvar.NewInt("tcp_recovered_panics")
+
+// connGauge tracks the cluster connections currently being served.
+var connGauge = expvar.NewInt("tcp_active_connections")
+
+// ActiveConnections returns the number of cluster connections currently being served.
+func ActiveConnections() int64 {
+	return connGauge.Value()
+}
+
+// ErrorReply builds the payload sent back to a client when its request could not be handled.
+func ErrorReply(err error) []byte {
+	return Join(TlvString(TypeError, err.Error()))
+}
+
+func OpenListener(port int, opts ServerOptions, creator HandlerCreator) error {
 	l, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", port))
 	if err != nil {
 		return err
 	}
+	if opts.TLSConfig != nil {
+		l = tls.NewListener(l, opts.TLSConfig)
+	}
 	defer l.Close()
-	log.Info().Int("port", port).Msg("")
+	log.Info().Int("port", port).Bool("tls", opts.TLSConfig != nil).Msg("")
+	return Serve(l, opts, creator)
+}
+
+// Serve handles the connections accepted by l until it is closed.
+func Serve(l net.Listener, opts ServerOptions, creator HandlerCreator) error {
 	for {
 		conn, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return err
+		}
 		if err != nil {
 			log.Error().Err(err).Msg("failed to accept connection")
 			continue
 		}
-		client := ClientConn{
-			conn:    conn,
-			handler: creator(),
-			buffer:  make(chan []byte, 10),
+		client := NewClientConn(conn, creator())
+		client.heartbeat = opts.Heartbeat
+		if opts.MaxDecompressedSize > 0 {
+			client.maxDecompressedSize = opts.MaxDecompressedSize
+		}
+		if opts.ReadTimeout > 0 {
+			client.readTimeout = opts.ReadTimeout
 		}
 		go client.handleRequest()
 	}
 }
 
+func NewClientConn(conn net.Conn, handler MessageHandler) *ClientConn {
+	return &ClientConn{
+		conn:                conn,
+		handler:             handler,
+		buffer:              make(chan []byte, 10),
+		maxDecompressedSize: DefaultMaxDecompressedSize,
+		readTimeout:         DefaultReadTimeout,
+	}
+}
+
 type ClientConn struct {
 	sync.Mutex
-	conn    net.Conn
-	handler MessageHandler
-	buffer  chan []byte
+	conn      net.Conn
+	handler   MessageHandler
+	buffer    chan []byte
+	heartbeat Heartbeat
+	// codec is negotiated by the handler through a TypeCodec TLV in its reply.
+	codec               Codec
+	maxDecompressedSize int64
+	readTimeout         time.Duration
 }
 
 func (c *ClientConn) send(b []byte) {
@@ -55,11 +143,52 @@ func (c *ClientConn) send(b []byte) {
 func (c *ClientConn) handleRequest() {
 	r := bufio.NewReader(c.conn)
 	w := bufio.NewWriter(c.conn)
+	connGauge.Add(1)
 	defer func() {
+		connGauge.Add(-1)
+		if v := recover(); v != nil {
+			panicCounter.Add(1)
+			log.Error().
+				Str("remote", c.conn.RemoteAddr().String()).
+				Interface("panic", v).
+				Str("stack", string(debug.Stack())).
+				Msg("recovered from panic while serving connection")
+		}
+		if closer, ok := c.handler.(SessionCloser); ok {
+			closer.Close()
+		}
 		c.conn.Close()
 		close(c.buffer)
 	}()
+	peer, err := c.peer()
+	if err != nil {
+		log.Error().Err(err).Str("remote", c.conn.RemoteAddr().String()).Msg("failed to complete tls handshake")
+		return
+	}
+	if aware, ok := c.handler.(PeerAware); ok {
+		aware.SetPeer(peer)
+	}
+	missed := 0
 	for {
+		idle, err := c.waitForData(r)
+		if idle {
+			if missed >= c.heartbeat.MaxMissed {
+				log.Warn().
+					Str("remote", c.conn.RemoteAddr().String()).
+					Int("missed", missed).
+					Msg("closing dead connection")
+				break
+			}
+			missed++
+			if err = c.ping(w); err != nil {
+				break
+			}
+			continue
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("failed to set read deadline")
+			break
+		}
 		msg, err := Read(r)
 		if err != nil {
 			if errors.Is(io.EOF, err) {
@@ -69,23 +198,135 @@ func (c *ClientConn) handleRequest() {
 			log.Error().Err(err).Msg("failed to read message from connection")
 			break
 		}
-		resp, err := c.handler.Handle(msg)
+		missed = 0
+		msg, err = Decompress(msg, c.codec, c.maxDecompressedSize)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to decompress message")
+			c.reply(w, ErrorReply(err))
+			break
+		}
+		resp, err := c.handle(msg)
 		if err != nil {
 			log.Error().Err(err).Msg("failed to handle incoming message")
+			c.reply(w, ErrorReply(err))
 			break
 		}
 		if resp == nil {
 			continue
 		}
-		_, err = w.Write(resp)
-		if err != nil {
-			log.Error().Err(err).Msg("failed to send msg over tcp")
+		if err = c.reply(w, resp); err != nil {
 			break
 		}
-		err = w.Flush()
-		if err != nil {
-			log.Error().Err(err).Msg("failed to flush msg over tcp")
+		if err = c.negotiate(resp); err != nil {
+			log.Error().Err(err).Msg("failed to negotiate codec")
 			break
 		}
 	}
 }
+
+// peer describes the client, completing the TLS handshake within the read
+// timeout so the client certificate is known.
+func (c *ClientConn) peer() (Peer, error) {
+	p := Peer{Address: c.conn.RemoteAddr().String()}
+	if tc, ok := c.conn.(*tls.Conn); ok {
+		if c.readTimeout > 0 {
+			if err := tc.SetDeadline(time.Now().Add(c.readTimeout)); err != nil {
+				return p, err
+			}
+		}
+		if err := tc.Handshake(); err != nil {
+			return p, err
+		}
+		if err := tc.SetDeadline(time.Time{}); err != nil {
+			return p, err
+		}
+		if chains := tc.ConnectionState().VerifiedChains; len(chains) > 0 {
+			p.Name = chains[0][0].Subject.CommonName
+		}
+	}
+	return p, nil
+}
+
+// waitForData blocks until the client sends something or, when heartbeat
+// is enabled, its interval elapses. Peek does not consume bytes, so a timeout
+// never leaves a message half read. The frame that follows must then be
+// read within the read timeout.
+func (c *ClientConn) waitForData(r *bufio.Reader) (bool, error) {
+	deadline := time.Time{}
+	if c.heartbeat.Enabled() {
+		deadline = time.Now().Add(c.heartbeat.Interval)
+	}
+	if err := c.conn.SetReadDeadline(deadline); err != nil {
+		return false, err
+	}
+	_, err := r.Peek(1)
+	if err != nil && isTimeout(err) && c.heartbeat.Enabled() {
+		return true, nil
+	}
+	// other errors are reported again by the following Read
+	deadline = time.Time{}
+	if c.readTimeout > 0 {
+		deadline = time.Now().Add(c.readTimeout)
+	}
+	return false, c.conn.SetReadDeadline(deadline)
+}
+
+func (c *ClientConn) ping(w *bufio.Writer) error {
+	if err := c.conn.SetWriteDeadline(time.Now().Add(c.heartbeat.Interval)); err != nil {
+		return err
+	}
+	defer c.conn.SetWriteDeadline(time.Time{})
+	return c.reply(w, c.heartbeat.Ping)
+}
+
+// handle passes msg to the handler and turns a panic into an error so that
+// a single malformed request only costs its own connection.
+func (c *ClientConn) handle(msg Msg) (resp []byte, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			panicCounter.Add(1)
+			log.Error().
+				Str("remote", c.conn.RemoteAddr().String()).
+				Interface("panic", v).
+				Str("stack", string(debug.Stack())).
+				Msg("recovered from panic while handling message")
+			resp = nil
+			err = fmt.Errorf("internal error while handling message")
+		}
+	}()
+	return c.handler.Handle(msg)
+}
+
+// negotiate switches the codec of the connection once a reply announcing it has been sent.
+func (c *ClientConn) negotiate(resp []byte) error {
+	v, err := GetTlv(TypeCodec, resp)
+	if err != nil {
+		return nil
+	}
+	codec, err := GetCodec(v.GetString())
+	if err != nil {
+		return err
+	}
+	c.codec = codec
+	return nil
+}
+
+func (c *ClientConn) reply(w *bufio.Writer, b []byte) error {
+	msgs, err := PackWith(b, MaxReplySize, c.codec)
+	if err != nil {
+		return err
+	}
+	for _, msg := range msgs {
+		_, err = w.Write(msg)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to send msg over tcp")
+			return err
+		}
+	}
+	err = w.Flush()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to flush msg over tcp")
+		return err
+	}
+	return nil
+}
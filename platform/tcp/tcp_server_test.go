@@ -0,0 +1,168 @@
+package tcp
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+type echoHandler struct {
+	payload []byte
+}
+
+func (h *echoHandler) Handle(msg Msg) ([]byte, error) {
+	h.payload = append(h.payload, msg.Payload...)
+	if msg.Page < msg.TotalPage {
+		return nil, nil
+	}
+	v, err := GetTlv(TypePayload, h.payload)
+	h.payload = nil
+	if err != nil {
+		return nil, err
+	}
+	return Join(v), nil
+}
+
+type panicHandler struct{}
+
+func (h panicHandler) Handle(msg Msg) ([]byte, error) {
+	var m map[string]string
+	m["boom"] = "boom"
+	return nil, nil
+}
+
+// pipeClient starts handleRequest on one end of a net.Pipe and returns the other end.
+func pipeClient(t *testing.T, handler MessageHandler) net.Conn {
+	server, client := net.Pipe()
+	go NewClientConn(server, handler).handleRequest()
+	t.Cleanup(func() { client.Close() })
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	return client
+}
+
+func sendAndRead(conn net.Conn, payload []byte) (Msg, error) {
+	msgs, err := Pack(payload, 1024)
+	if err != nil {
+		return Msg{}, err
+	}
+	for _, msg := range msgs {
+		if _, err := conn.Write(msg); err != nil {
+			return Msg{}, err
+		}
+	}
+	return Read(bufio.NewReader(conn))
+}
+
+func TestHandleRequestRecoversFromPanic(t *testing.T) {
+	before := panicCounter.Value()
+	conn := pipeClient(t, panicHandler{})
+	msg, err := sendAndRead(conn, Join(TlvString(TypePayload, "hello")))
+	if err != nil {
+		t.Logf("failed to read error reply: %v", err)
+		t.FailNow()
+	}
+	if v, err := GetTlv(TypeError, msg.Payload); err != nil || v.GetString() == "" {
+		t.Logf("reply should carry an error, actual = %v", msg.Payload)
+		t.FailNow()
+	}
+	if panicCounter.Value() != before+1 {
+		t.Logf("panic counter should be increased, actual = %v", panicCounter.Value())
+		t.FailNow()
+	}
+}
+
+func TestHandleRequestIsolatesConnections(t *testing.T) {
+	bad := pipeClient(t, panicHandler{})
+	good := pipeClient(t, &echoHandler{})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		sendAndRead(bad, Join(TlvString(TypePayload, "bad")))
+	}()
+	wg.Wait()
+
+	for i := 0; i < 3; i++ {
+		msg, err := sendAndRead(good, Join(TlvString(TypePayload, "good")))
+		if err != nil {
+			t.Logf("healthy connection should still be served: %v", err)
+			t.FailNow()
+		}
+		if v, err := GetTlv(TypePayload, msg.Payload); err != nil || v.GetString() != "good" {
+			t.Logf("expected reply is good, actual = %v", v.GetString())
+			t.FailNow()
+		}
+	}
+}
+
+type sessionHandler struct {
+	echoHandler
+	closed chan struct{}
+}
+
+func (h *sessionHandler) Close() {
+	close(h.closed)
+}
+
+func TestHandleRequestClosesDeadConnection(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	handler := &sessionHandler{closed: make(chan struct{})}
+	conn := NewClientConn(server, handler)
+	conn.heartbeat = Heartbeat{
+		Interval:  20 * time.Millisecond,
+		MaxMissed: 2,
+		Ping:      Join(TlvString(TypePayload, "ping")),
+	}
+	go conn.handleRequest()
+
+	r := bufio.NewReader(client)
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	for i := 0; i < 2; i++ {
+		msg, err := Read(r)
+		if err != nil {
+			t.Logf("failed to read ping: %v", err)
+			t.FailNow()
+		}
+		if v, err := GetTlv(TypePayload, msg.Payload); err != nil || v.GetString() != "ping" {
+			t.Logf("expected ping, actual = %v", v.GetString())
+			t.FailNow()
+		}
+	}
+	select {
+	case <-handler.closed:
+	case <-time.After(time.Second):
+		t.Logf("session should be closed after missed heartbeats")
+		t.FailNow()
+	}
+}
+
+func TestHandleRequestClosesStalledFrame(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	handler := &sessionHandler{closed: make(chan struct{})}
+	conn := NewClientConn(server, handler)
+	conn.readTimeout = 20 * time.Millisecond
+	go conn.handleRequest()
+
+	msgs, err := Pack(Join(TlvString(TypePayload, "upload")), 1024)
+	if err != nil {
+		t.Logf("failed to pack message: %v", err)
+		t.FailNow()
+	}
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	// the client stalls half way through the frame
+	if _, err := client.Write(msgs[0][:len(msgs[0])/2]); err != nil {
+		t.Logf("failed to write half of the frame: %v", err)
+		t.FailNow()
+	}
+	select {
+	case <-handler.closed:
+	case <-time.After(time.Second):
+		t.Logf("connection should be closed when a frame stalls")
+		t.FailNow()
+	}
+}
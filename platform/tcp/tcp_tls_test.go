@@ -0,0 +1,142 @@
+package tcp
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+func selfSignedCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Logf("failed to generate key: %v", err)
+		t.FailNow()
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Logf("failed to create certificate: %v", err)
+		t.FailNow()
+	}
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+func TestClientDoTLS(t *testing.T) {
+	cert, pool := selfSignedCert(t)
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Logf("failed to listen: %v", err)
+		t.FailNow()
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go NewClientConn(conn, &echoHandler{}).handleRequest()
+		}
+	}()
+
+	opts := DefaultClientOptions(l.Addr().String())
+	opts.TLSConfig = &tls.Config{RootCAs: pool}
+	client := NewClient(opts)
+	defer client.Close()
+	reply, err := client.Do(context.Background(), Join(TlvString(TypePayload, "hello")))
+	if err != nil {
+		t.Logf("failed to Do(ctx, payload): %v", err)
+		t.FailNow()
+	}
+	if v, err := GetTlv(TypePayload, reply); err != nil || v.GetString() != "hello" {
+		t.Logf("expected reply is hello, actual = %v", v.GetString())
+		t.FailNow()
+	}
+
+	opts.TLSConfig = &tls.Config{}
+	opts.MaxRetries = 0
+	if _, err := NewClient(opts).Do(context.Background(), Join(TlvString(TypePayload, "hello"))); err == nil {
+		t.Logf("expected untrusted certificate to be rejected")
+		t.FailNow()
+	}
+}
+
+type peerHandler struct {
+	echoHandler
+	peers chan Peer
+}
+
+func (h *peerHandler) SetPeer(p Peer) {
+	h.peers <- p
+}
+
+func TestPeerOfMutualTLS(t *testing.T) {
+	cert, pool := selfSignedCert(t)
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+	if err != nil {
+		t.Logf("failed to listen: %v", err)
+		t.FailNow()
+	}
+	defer l.Close()
+	handler := &peerHandler{peers: make(chan Peer, 1)}
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			NewClientConn(conn, handler).handleRequest()
+		}
+	}()
+
+	opts := DefaultClientOptions(l.Addr().String())
+	opts.TLSConfig = &tls.Config{RootCAs: pool, Certificates: []tls.Certificate{cert}}
+	client := NewClient(opts)
+	defer client.Close()
+	if _, err := client.Do(context.Background(), Join(TlvString(TypePayload, "hello"))); err != nil {
+		t.Logf("failed to Do(ctx, payload): %v", err)
+		t.FailNow()
+	}
+	if p := <-handler.peers; p.Name != "localhost" || p.Address == "" {
+		t.Logf("expected peer named by its certificate, actual = %+v", p)
+		t.FailNow()
+	}
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	cert, _ := selfSignedCert(t)
+	server, client := net.Pipe()
+	defer client.Close()
+	handler := &sessionHandler{closed: make(chan struct{})}
+	conn := NewClientConn(tls.Server(server, &tls.Config{Certificates: []tls.Certificate{cert}}), handler)
+	conn.readTimeout = 20 * time.Millisecond
+	go conn.handleRequest()
+	// the client opens the connection and never says hello
+	select {
+	case <-handler.closed:
+	case <-time.After(time.Second):
+		t.Logf("connection should be closed when the tls handshake stalls")
+		t.FailNow()
+	}
+}
@@ -8,6 +8,8 @@ import (
 
 const (
 	TypeCmd     uint8 = 0
+	TypeError   uint8 = 1
+	TypeCodec   uint8 = 2
 	TypePayload uint8 = 10
 )
 